@@ -0,0 +1,99 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/orchestrator-agent/go/config"
+	"github.com/outbrain/orchestrator-agent/go/osagent"
+)
+
+// BinlogArchiveStatus describes the state of the continuous binary log archiver
+type BinlogArchiveStatus struct {
+	Enabled          bool
+	Directory        string
+	RetentionDays    uint
+	LastCheck        time.Time
+	LastArchived     string
+	LastArchivedTime time.Time
+	ArchivedCount    int
+	PurgedCount      int
+	Error            string
+}
+
+var binlogArchiveStatus = BinlogArchiveStatus{}
+var binlogArchiveMutex = &sync.Mutex{}
+
+// GetBinlogArchiveStatus returns a copy of the binary log archiver's current state
+func GetBinlogArchiveStatus() BinlogArchiveStatus {
+	binlogArchiveMutex.Lock()
+	defer binlogArchiveMutex.Unlock()
+	return binlogArchiveStatus
+}
+
+func updateBinlogArchiveStatus(update func(status *BinlogArchiveStatus)) {
+	binlogArchiveMutex.Lock()
+	defer binlogArchiveMutex.Unlock()
+	update(&binlogArchiveStatus)
+}
+
+// archiveBinlogs archives newly rotated binary logs, then applies retention
+func archiveBinlogs() {
+	archived, err := osagent.ArchiveRotatedBinlogs()
+	var purged []string
+	if err == nil && config.Config.BinlogArchiveRetentionDays > 0 {
+		purged, err = osagent.PurgeArchivedBinlogs(time.Duration(config.Config.BinlogArchiveRetentionDays) * 24 * time.Hour)
+	}
+	if err != nil {
+		log.Errorf("Binary log archiving failed: %+v", err)
+	}
+	updateBinlogArchiveStatus(func(status *BinlogArchiveStatus) {
+		status.LastCheck = time.Now()
+		status.Error = ""
+		if err != nil {
+			status.Error = err.Error()
+		}
+		if len(archived) > 0 {
+			status.LastArchived = archived[len(archived)-1].LogFile
+			status.LastArchivedTime = archived[len(archived)-1].ArchivedAt
+			status.ArchivedCount += len(archived)
+		}
+		status.PurgedCount += len(purged)
+	})
+}
+
+// ContinuousBinlogArchive periodically copies rotated binary logs into BinlogArchiveDirectory.
+// It returns immediately if no archive directory or poll interval is configured.
+func ContinuousBinlogArchive() {
+	if config.Config.BinlogArchiveDirectory == "" || config.Config.BinlogArchivePollSeconds == 0 {
+		return
+	}
+	log.Infof("Starting binary log archiver into %s", config.Config.BinlogArchiveDirectory)
+	updateBinlogArchiveStatus(func(status *BinlogArchiveStatus) {
+		status.Enabled = true
+		status.Directory = config.Config.BinlogArchiveDirectory
+		status.RetentionDays = config.Config.BinlogArchiveRetentionDays
+	})
+
+	for {
+		archiveBinlogs()
+		time.Sleep(time.Duration(config.Config.BinlogArchivePollSeconds) * time.Second)
+	}
+}
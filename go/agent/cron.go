@@ -0,0 +1,210 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField describes the valid range of a single cron expression field
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	cronMinute     = cronField{name: "minute", min: 0, max: 59}
+	cronHour       = cronField{name: "hour", min: 0, max: 23}
+	cronDayOfMonth = cronField{name: "day-of-month", min: 1, max: 31}
+	cronMonth      = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDayOfWeek = cronField{name: "day-of-week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// CronSchedule is a parsed, standard 5-field cron expression:
+// minute, hour, day of month, month, day of week
+type CronSchedule struct {
+	Expression string
+
+	minutes     map[int]bool
+	hours       map[int]bool
+	daysOfMonth map[int]bool
+	months      map[int]bool
+	daysOfWeek  map[int]bool
+
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+// ParseCronSchedule parses a cron expression such as "30 2 * * *" or "@daily".
+// Fields support `*`, numbers, ranges (`1-5`), steps (`*/15`, `0-30/10`), lists (`1,15`)
+// and three-letter month and weekday names.
+func ParseCronSchedule(expression string) (*CronSchedule, error) {
+	expression = strings.TrimSpace(expression)
+	fieldsExpression := expression
+	if descriptor, ok := cronDescriptors[strings.ToLower(expression)]; ok {
+		fieldsExpression = descriptor
+	}
+	tokens := strings.Fields(fieldsExpression)
+	if len(tokens) != 5 {
+		return nil, fmt.Errorf("ParseCronSchedule: expected 5 fields in cron expression, got %d: %s", len(tokens), expression)
+	}
+	schedule := &CronSchedule{Expression: expression}
+	var err error
+	if schedule.minutes, err = parseCronField(tokens[0], cronMinute); err != nil {
+		return nil, err
+	}
+	if schedule.hours, err = parseCronField(tokens[1], cronHour); err != nil {
+		return nil, err
+	}
+	if schedule.daysOfMonth, err = parseCronField(tokens[2], cronDayOfMonth); err != nil {
+		return nil, err
+	}
+	if schedule.months, err = parseCronField(tokens[3], cronMonth); err != nil {
+		return nil, err
+	}
+	if schedule.daysOfWeek, err = parseCronField(tokens[4], cronDayOfWeek); err != nil {
+		return nil, err
+	}
+	if schedule.daysOfWeek[7] {
+		// Both 0 and 7 stand for Sunday
+		schedule.daysOfWeek[0] = true
+	}
+	schedule.anyDayOfMonth = strings.HasPrefix(tokens[2], "*")
+	schedule.anyDayOfWeek = strings.HasPrefix(tokens[4], "*")
+	return schedule, nil
+}
+
+func parseCronValue(token string, field cronField) (int, error) {
+	if value, ok := field.names[strings.ToLower(token)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("ParseCronSchedule: invalid %s value: %s", field.name, token)
+	}
+	if value < field.min || value > field.max {
+		return 0, fmt.Errorf("ParseCronSchedule: %s value out of range [%d-%d]: %d", field.name, field.min, field.max, value)
+	}
+	return value, nil
+}
+
+func parseCronField(token string, field cronField) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, term := range strings.Split(token, ",") {
+		rangeTerm := term
+		step := 1
+		if tokens := strings.SplitN(term, "/", 2); len(tokens) == 2 {
+			var err error
+			if step, err = strconv.Atoi(tokens[1]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("ParseCronSchedule: invalid %s step: %s", field.name, term)
+			}
+			rangeTerm = tokens[0]
+		}
+		from, to := field.min, field.max
+		switch {
+		case rangeTerm == "*":
+		case strings.Contains(rangeTerm, "-"):
+			tokens := strings.SplitN(rangeTerm, "-", 2)
+			var err error
+			if from, err = parseCronValue(tokens[0], field); err != nil {
+				return nil, err
+			}
+			if to, err = parseCronValue(tokens[1], field); err != nil {
+				return nil, err
+			}
+			if from > to {
+				return nil, fmt.Errorf("ParseCronSchedule: invalid %s range: %s", field.name, rangeTerm)
+			}
+		default:
+			value, err := parseCronValue(rangeTerm, field)
+			if err != nil {
+				return nil, err
+			}
+			from = value
+			if step == 1 {
+				to = value
+			}
+		}
+		for value := from; value <= to; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+// matchesDay follows the traditional cron rule: when both day-of-month and day-of-week are
+// restricted, a day matching either one of them is a match
+func (this *CronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonthMatch := this.daysOfMonth[t.Day()]
+	dayOfWeekMatch := this.daysOfWeek[int(t.Weekday())]
+	if this.anyDayOfMonth || this.anyDayOfWeek {
+		return dayOfMonthMatch && dayOfWeekMatch
+	}
+	return dayOfMonthMatch || dayOfWeekMatch
+}
+
+// Next returns the first time, strictly after given time, matching this schedule.
+// A zero time is returned if no such time exists within the next five years (e.g. "0 0 30 2 *")
+func (this *CronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !this.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !this.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !this.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !this.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// String returns the original cron expression
+func (this *CronSchedule) String() string {
+	return this.Expression
+}
@@ -0,0 +1,56 @@
+package agent_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/outbrain/orchestrator-agent/go/agent"
+)
+
+func TestParseCronScheduleInvalid(t *testing.T) {
+	for _, expression := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "@sometimes"} {
+		if _, err := agent.ParseCronSchedule(expression); err == nil {
+			t.Errorf("Expected error parsing %q", expression)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	base := time.Date(2015, time.March, 10, 14, 37, 12, 0, time.UTC) // a Tuesday
+	tests := []struct {
+		expression string
+		expected   time.Time
+	}{
+		{"* * * * *", time.Date(2015, time.March, 10, 14, 38, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2015, time.March, 10, 14, 45, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2015, time.March, 11, 2, 30, 0, 0, time.UTC)},
+		{"@daily", time.Date(2015, time.March, 11, 0, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2015, time.March, 10, 17, 0, 0, 0, time.UTC)},
+		{"0 0 * * sun", time.Date(2015, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2015, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2016, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// day-of-month and day-of-week both restricted: either matches
+		{"0 0 20 * fri", time.Date(2015, time.March, 13, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		schedule, err := agent.ParseCronSchedule(test.expression)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %+v", test.expression, err)
+			continue
+		}
+		if next := schedule.Next(base); !next.Equal(test.expected) {
+			t.Errorf("%q: expected %+v, got %+v", test.expression, test.expected, next)
+		}
+	}
+}
+
+func TestCronScheduleNeverFires(t *testing.T) {
+	schedule, err := agent.ParseCronSchedule("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if next := schedule.Next(time.Now()); !next.IsZero() {
+		t.Errorf("Expected zero time, got %+v", next)
+	}
+}
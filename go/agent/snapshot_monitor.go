@@ -0,0 +1,213 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agent
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/orchestrator-agent/go/config"
+	"github.com/outbrain/orchestrator-agent/go/osagent"
+)
+
+const maxSnapshotMonitorEvents = 100
+
+// SnapshotMonitorEvent is a notable occurrence observed by the snapshot monitor:
+// an automatic extension, or a failure to extend a filling snapshot
+type SnapshotMonitorEvent struct {
+	Time            time.Time
+	LVPath          string
+	SnapshotPercent float64
+	Extended        bool
+	Warning         string
+}
+
+// MonitoredSnapshot is the last known state of a single snapshot logical volume
+type MonitoredSnapshot struct {
+	LogicalVolume osagent.LogicalVolume
+	InUseBySeed   bool
+	ExtendCount   int
+	LastExtended  time.Time
+	Warning       string
+}
+
+// SnapshotMonitorStatus describes the state of the snapshot fill monitor
+type SnapshotMonitorStatus struct {
+	Enabled   bool
+	LastCheck time.Time
+	Error     string
+	Snapshots []MonitoredSnapshot
+	Events    []SnapshotMonitorEvent
+}
+
+var snapshotMonitorStatus = SnapshotMonitorStatus{}
+var snapshotExtendCounts = make(map[string]int)
+var snapshotLastExtended = make(map[string]time.Time)
+var snapshotMonitorMutex = &sync.Mutex{}
+
+// GetSnapshotMonitorStatus returns a copy of the snapshot monitor's current state
+func GetSnapshotMonitorStatus() SnapshotMonitorStatus {
+	snapshotMonitorMutex.Lock()
+	defer snapshotMonitorMutex.Unlock()
+
+	status := snapshotMonitorStatus
+	status.Snapshots = append([]MonitoredSnapshot{}, snapshotMonitorStatus.Snapshots...)
+	status.Events = append([]SnapshotMonitorEvent{}, snapshotMonitorStatus.Events...)
+	return status
+}
+
+func addSnapshotMonitorEvent(event SnapshotMonitorEvent) {
+	event.Time = time.Now()
+	snapshotMonitorStatus.Events = append(snapshotMonitorStatus.Events, event)
+	if len(snapshotMonitorStatus.Events) > maxSnapshotMonitorEvents {
+		snapshotMonitorStatus.Events = snapshotMonitorStatus.Events[len(snapshotMonitorStatus.Events)-maxSnapshotMonitorEvents:]
+	}
+}
+
+// snapshotMonitorAction is what the snapshot monitor does about a snapshot
+type snapshotMonitorAction int
+
+const (
+	snapshotNoAction snapshotMonitorAction = iota
+	snapshotInvalidWarning
+	snapshotThinPoolWarning
+	snapshotExtend
+)
+
+// decideSnapshotAction decides what to do about a snapshot, given the fill percentage beyond which snapshots
+// are extended (0 disables extension). An invalid snapshot is beyond help; a thin snapshot is not extended on
+// its own, as it is its pool that fills up.
+func decideSnapshotAction(lv *osagent.LogicalVolume, thresholdPercent float64) snapshotMonitorAction {
+	if !lv.IsSnapshotValid() {
+		return snapshotInvalidWarning
+	}
+	if thresholdPercent <= 0 || lv.SnapshotPercent < thresholdPercent {
+		return snapshotNoAction
+	}
+	if lv.IsThinSnapshot {
+		return snapshotThinPoolWarning
+	}
+	return snapshotExtend
+}
+
+// extendSnapshot attempts to extend a filling snapshot, provided its volume group has free extents
+func extendSnapshot(lv *osagent.LogicalVolume) error {
+	freeExtents, err := osagent.GetVolumeGroupFreeExtents(lv.GroupName)
+	if err == nil && freeExtents == 0 {
+		err = fmt.Errorf("volume group %s has no free extents", lv.GroupName)
+	}
+	if err == nil {
+		err = osagent.ExtendLV(lv.Path, config.Config.SnapshotAutoExtendPercent)
+	}
+	return err
+}
+
+// checkSnapshots examines fill percentage of all snapshots, extending those crossing the configured threshold.
+// LVM commands run without holding snapshotMonitorMutex, which only guards the recording of their results.
+func checkSnapshots() {
+	logicalVolumes, err := osagent.SnapshotLogicalVolumes("")
+	if err != nil {
+		snapshotMonitorMutex.Lock()
+		defer snapshotMonitorMutex.Unlock()
+		snapshotMonitorStatus.LastCheck = time.Now()
+		snapshotMonitorStatus.Error = err.Error()
+		return
+	}
+
+	seedInProgress := osagent.SeedInProgress()
+	mountedLVPath := ""
+	if seedInProgress {
+		if mount, err := osagent.FindMount(config.Config.SnapshotMountPoint); err == nil && mount != nil {
+			mountedLVPath = mount.LVPath
+		}
+	}
+
+	snapshots := []MonitoredSnapshot{}
+	events := []SnapshotMonitorEvent{}
+	for _, lv := range logicalVolumes {
+		if !lv.IsSnapshot {
+			continue
+		}
+		snapshot := MonitoredSnapshot{
+			LogicalVolume: lv,
+			InUseBySeed:   seedInProgress && lv.Path == mountedLVPath,
+		}
+		switch decideSnapshotAction(&lv, config.Config.SnapshotAutoExtendThresholdPercent) {
+		case snapshotInvalidWarning:
+			snapshot.Warning = fmt.Sprintf("snapshot %s is full and no longer usable", lv.Path)
+		case snapshotThinPoolWarning:
+			snapshot.Warning = fmt.Sprintf("thin pool %s/%s of snapshot %s is %.2f%% full", lv.GroupName, lv.ThinPool, lv.Path, lv.SnapshotPercent)
+			log.Warning(snapshot.Warning)
+		case snapshotExtend:
+			if err := extendSnapshot(&lv); err != nil {
+				snapshot.Warning = fmt.Sprintf("snapshot %s is %.2f%% full and cannot be extended: %s", lv.Path, lv.SnapshotPercent, err.Error())
+				if snapshot.InUseBySeed {
+					snapshot.Warning = fmt.Sprintf("%s; seed in progress", snapshot.Warning)
+				}
+				log.Warning(snapshot.Warning)
+				events = append(events, SnapshotMonitorEvent{LVPath: lv.Path, SnapshotPercent: lv.SnapshotPercent, Warning: snapshot.Warning})
+			} else {
+				log.Infof("Extended snapshot %s, which was %.2f%% full", lv.Path, lv.SnapshotPercent)
+				events = append(events, SnapshotMonitorEvent{LVPath: lv.Path, SnapshotPercent: lv.SnapshotPercent, Extended: true})
+			}
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	snapshotMonitorMutex.Lock()
+	defer snapshotMonitorMutex.Unlock()
+
+	snapshotMonitorStatus.LastCheck = time.Now()
+	snapshotMonitorStatus.Error = ""
+	for _, event := range events {
+		if event.Extended {
+			snapshotExtendCounts[event.LVPath]++
+			snapshotLastExtended[event.LVPath] = time.Now()
+		}
+		addSnapshotMonitorEvent(event)
+	}
+	for i := range snapshots {
+		lvPath := snapshots[i].LogicalVolume.Path
+		snapshots[i].ExtendCount = snapshotExtendCounts[lvPath]
+		snapshots[i].LastExtended = snapshotLastExtended[lvPath]
+	}
+	snapshotMonitorStatus.Snapshots = snapshots
+}
+
+// ContinuousSnapshotMonitor periodically checks how full the snapshots are, and extends those about to fill up.
+// Checks are more frequent while a seed is in progress, as seeds read from snapshots.
+func ContinuousSnapshotMonitor() {
+	if config.Config.SnapshotMonitorSeconds == 0 {
+		return
+	}
+	log.Infof("Starting snapshot monitor")
+	snapshotMonitorMutex.Lock()
+	snapshotMonitorStatus.Enabled = true
+	snapshotMonitorMutex.Unlock()
+
+	for {
+		checkSnapshots()
+
+		interval := config.Config.SnapshotMonitorSeconds
+		if osagent.SeedInProgress() && config.Config.SnapshotMonitorSeedSeconds > 0 {
+			interval = config.Config.SnapshotMonitorSeedSeconds
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+	}
+}
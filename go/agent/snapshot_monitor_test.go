@@ -0,0 +1,30 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/outbrain/orchestrator-agent/go/osagent"
+)
+
+func TestDecideSnapshotAction(t *testing.T) {
+	tests := []struct {
+		lv        osagent.LogicalVolume
+		threshold float64
+		expected  snapshotMonitorAction
+	}{
+		{osagent.LogicalVolume{IsSnapshot: true, Attributes: "swi-a-s---", SnapshotPercent: 50}, 80, snapshotNoAction},
+		{osagent.LogicalVolume{IsSnapshot: true, Attributes: "swi-a-s---", SnapshotPercent: 79.99}, 80, snapshotNoAction},
+		{osagent.LogicalVolume{IsSnapshot: true, Attributes: "swi-a-s---", SnapshotPercent: 80}, 80, snapshotExtend},
+		{osagent.LogicalVolume{IsSnapshot: true, Attributes: "swi-a-s---", SnapshotPercent: 95}, 80, snapshotExtend},
+		{osagent.LogicalVolume{IsSnapshot: true, Attributes: "swi-a-s---", SnapshotPercent: 95}, 0, snapshotNoAction},
+		{osagent.LogicalVolume{IsSnapshot: true, Attributes: "swi-I-s---", SnapshotPercent: 100}, 80, snapshotInvalidWarning},
+		{osagent.LogicalVolume{IsSnapshot: true, Attributes: "swi-I-s---", SnapshotPercent: 100}, 0, snapshotInvalidWarning},
+		{osagent.LogicalVolume{IsSnapshot: true, IsThinSnapshot: true, Attributes: "Vwi-a-tz-k", SnapshotPercent: 90}, 80, snapshotThinPoolWarning},
+		{osagent.LogicalVolume{IsSnapshot: true, IsThinSnapshot: true, Attributes: "Vwi-a-tz-k", SnapshotPercent: 45}, 80, snapshotNoAction},
+	}
+	for _, test := range tests {
+		if action := decideSnapshotAction(&test.lv, test.threshold); action != test.expected {
+			t.Errorf("Snapshot %+v, threshold %.2f: expected action %d, got %d", test.lv, test.threshold, test.expected, action)
+		}
+	}
+}
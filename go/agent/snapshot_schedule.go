@@ -0,0 +1,138 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package agent
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/orchestrator-agent/go/config"
+	"github.com/outbrain/orchestrator-agent/go/osagent"
+)
+
+// SnapshotScheduleStatus describes the state of the built-in snapshot scheduler
+type SnapshotScheduleStatus struct {
+	Enabled          bool
+	Schedule         string
+	JitterSeconds    uint
+	Error            string
+	NextRun          time.Time
+	LastRunStart     time.Time
+	LastRunEnd       time.Time
+	LastRunSucceeded bool
+	LastRunError     string
+}
+
+var snapshotScheduleStatus = SnapshotScheduleStatus{}
+var snapshotScheduleMutex = &sync.Mutex{}
+var snapshotScheduleRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// GetSnapshotScheduleStatus returns a copy of the snapshot scheduler's current state
+func GetSnapshotScheduleStatus() SnapshotScheduleStatus {
+	snapshotScheduleMutex.Lock()
+	defer snapshotScheduleMutex.Unlock()
+	return snapshotScheduleStatus
+}
+
+func updateSnapshotScheduleStatus(update func(status *SnapshotScheduleStatus)) {
+	snapshotScheduleMutex.Lock()
+	defer snapshotScheduleMutex.Unlock()
+	update(&snapshotScheduleStatus)
+}
+
+// snapshotScheduleJitter returns a random delay, so that hosts sharing the same schedule
+// do not all snapshot at the very same moment
+func snapshotScheduleJitter() time.Duration {
+	if config.Config.SnapshotScheduleJitterSeconds == 0 {
+		return 0
+	}
+	return time.Duration(snapshotScheduleRand.Int63n(int64(config.Config.SnapshotScheduleJitterSeconds))) * time.Second
+}
+
+// runScheduledSnapshot creates a snapshot and records the outcome
+func runScheduledSnapshot() {
+	updateSnapshotScheduleStatus(func(status *SnapshotScheduleStatus) {
+		status.LastRunStart = time.Now()
+		status.LastRunEnd = time.Time{}
+		status.LastRunSucceeded = false
+		status.LastRunError = ""
+	})
+	log.Infof("Creating scheduled snapshot")
+	err := osagent.CreateSnapshot("scheduled")
+	updateSnapshotScheduleStatus(func(status *SnapshotScheduleStatus) {
+		status.LastRunEnd = time.Now()
+		status.LastRunSucceeded = (err == nil)
+		if err != nil {
+			status.LastRunError = err.Error()
+		}
+	})
+	if err != nil {
+		log.Errorf("Scheduled snapshot failed: %+v", err)
+	}
+}
+
+// ContinuousSnapshotSchedule creates snapshots periodically, as per SnapshotSchedule cron expression.
+// It returns immediately if no schedule is configured.
+func ContinuousSnapshotSchedule() {
+	if config.Config.SnapshotSchedule == "" {
+		return
+	}
+	updateSnapshotScheduleStatus(func(status *SnapshotScheduleStatus) {
+		status.Schedule = config.Config.SnapshotSchedule
+		status.JitterSeconds = config.Config.SnapshotScheduleJitterSeconds
+	})
+	schedule, err := ParseCronSchedule(config.Config.SnapshotSchedule)
+	if err != nil {
+		log.Errore(err)
+		updateSnapshotScheduleStatus(func(status *SnapshotScheduleStatus) {
+			status.Error = err.Error()
+		})
+		return
+	}
+	log.Infof("Starting snapshot schedule: %s", schedule)
+	updateSnapshotScheduleStatus(func(status *SnapshotScheduleStatus) {
+		status.Enabled = true
+	})
+
+	scheduledTime := time.Now()
+	for {
+		scheduledTime = schedule.Next(scheduledTime)
+		if scheduledTime.Before(time.Now()) {
+			// Previous run took longer than the interval between scheduled runs
+			scheduledTime = schedule.Next(time.Now())
+		}
+		if scheduledTime.IsZero() {
+			log.Errorf("Snapshot schedule %s never fires; stopping snapshot scheduler", schedule)
+			updateSnapshotScheduleStatus(func(status *SnapshotScheduleStatus) {
+				status.Enabled = false
+				status.NextRun = time.Time{}
+				status.Error = "schedule never fires"
+			})
+			return
+		}
+		nextRun := scheduledTime.Add(snapshotScheduleJitter())
+		updateSnapshotScheduleStatus(func(status *SnapshotScheduleStatus) {
+			status.NextRun = nextRun
+		})
+		log.Debugf("Next scheduled snapshot at %+v", nextRun)
+		time.Sleep(time.Until(nextRun))
+
+		runScheduledSnapshot()
+	}
+}
@@ -43,7 +43,14 @@ func Http() {
 		m.Use(auth.Basic(config.Config.HTTPAuthUser, config.Config.HTTPAuthPassword))
 	}
 
-	m.Use(gzip.All())
+	gzipHandler := gzip.All()
+	m.Use(func(c martini.Context, req *nethttp.Request) {
+		if http.IsStreamingRequest(req) {
+			// Streamed responses are gzip encoded, and flushed, by their handlers
+			return
+		}
+		c.Invoke(gzipHandler)
+	})
 	// Render html templates from templates directory
 	m.Use(render.Renderer(render.Options{
 		Directory:       "resources",
@@ -56,6 +63,9 @@ func Http() {
 	}
 
 	go agent.ContinuousOperation()
+	go agent.ContinuousSnapshotSchedule()
+	go agent.ContinuousSnapshotMonitor()
+	go agent.ContinuousBinlogArchive()
 
 	log.Infof("Starting HTTP on port %d", config.Config.HTTPPort)
 
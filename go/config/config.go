@@ -26,13 +26,40 @@ import (
 // Configuration makes for orchestrator-agent configuration input, which can be provided by user via JSON formatted file.
 type Configuration struct {
 	SnapshotMountPoint                 string            // The single, agreed-upon mountpoint for logical volume snapshots
+	SnapshotMountReadOnly              bool              // If true (default), snapshots are mounted read-only unless explicitly requested otherwise
+	SnapshotMountOptions               map[string]string // Mount options per file system type (e.g. "xfs": "nouuid"), used when mounting snapshots
+	SnapshotMountFileSystemCheck       bool              // If true, a no-modify file system check (xfs_repair -n / e2fsck -n) precedes snapshot mounts
+	SnapshotValidationMySQLDCommand    string            // mysqld binary used to test-restore snapshots
+	SnapshotValidationDefaultsFile     string            // my.cnf from which InnoDB file format settings (page size, data file path, log file size...) of the test-restore mysqld are read; it otherwise runs with --no-defaults. Empty means MySQL defaults
+	SnapshotValidationOverlayDirectory string            // Directory of the sparse copy-on-write file of the writable overlay over a classic (non-thin) snapshot being validated
+	SnapshotValidationMySQLUser        string            // OS user as which the test-restore mysqld runs
+	SnapshotValidationPort             uint              // Port for the test-restore mysqld (networking is disabled; it only names the instance)
+	SnapshotValidationTimeoutSeconds   uint              // Max time to wait for test-restore mysqld recovery, or shutdown
+	SnapshotValidationQueries          []string          // Sanity queries run against the test-restore mysqld
 	ContinuousPollSeconds              uint              // Poll interval for continuous operation
 	ResubmitAgentIntervalMinutes       uint              // Poll interval for resubmitting this agent on orchestrator agents API
 	CreateSnapshotCommand              string            // Command which creates a snapshot logical volume. It's a "do it yourself" implementation
+	SnapshotMonitorSeconds             uint              // Interval at which snapshot fill percentage is checked. 0 disables snapshot monitoring
+	SnapshotMonitorSeedSeconds         uint              // Interval at which snapshot fill percentage is checked while a seed is in progress
+	SnapshotAutoExtendThresholdPercent float64           // Snapshots filled beyond this percentage are automatically extended. 0 disables automatic extension
+	SnapshotAutoExtendPercent          uint              // Percentage of its current size by which a snapshot is automatically extended
+	SnapshotMergeTimeoutSeconds        uint              // Max time a snapshot rollback waits for the snapshot merge to complete
 	AvailableLocalSnapshotHostsCommand string            // Command which returns list of hosts (one host per line) with available snapshots in local datacenter
 	AvailableSnapshotHostsCommand      string            // Command which returns list of hosts (one host per line) with available snapshots in any datacenter
-	SnapshotVolumesFilter              string            // text pattern filtering agent logical volumes that are valid snapshots
+	SnapshotVolumesFilter              string            // text pattern filtering agent logical volumes that are valid snapshots (legacy; see SnapshotVolumesTag)
+	SnapshotVolumesTag                 string            // LVM tag with which the agent marks snapshots it creates, and by which it identifies valid snapshots
+	SnapshotSchedule                   string            // Cron expression (e.g. "0 3 * * *") by which the agent periodically runs CreateSnapshotCommand. Empty disables
+	SnapshotScheduleJitterSeconds      uint              // Random delay, up to this number of seconds, added to each scheduled snapshot so that hosts do not snapshot all at once
+	MySQLConfigFile                    string            // MySQL server configuration file, from which binary log settings are read (also while MySQL is down)
+	MySQLSocket                        string            // Unix socket through which the agent connects to MySQL to read its variables. Takes precedence over MySQLHostname
+	MySQLHostname                      string            // Host through which the agent connects to MySQL, when MySQLSocket is empty. With neither, commands are used instead
+	MySQLConnectPort                   uint              // Port through which the agent connects to MySQL on MySQLHostname
+	MySQLCredentialsConfigFile         string            // my.cnf style file whose [client] section holds user and password for the agent's MySQL connection
+	MySQLConnectTimeoutSeconds         uint              // Timeout for connecting to MySQL
+	MySQLMaxConnections                uint              // Size of the agent's MySQL connection pool
+	MySQLInstances                     MySQLInstanceMap  // Additional MySQL instances on this host, by name. The top level MySQL settings make the "default" instance
 	MySQLDatadirCommand                string            // command expected to present with @@datadir
+	MySQLDiskUsageRefreshSeconds       uint              // Age beyond which the cached MySQL datadir disk usage breakdown is recomputed
 	MySQLPortCommand                   string            // command expected to present with @@port
 	MySQLDeleteDatadirContentCommand   string            // command which deletes all content from MySQL datadir (does not remvoe directory itself)
 	MySQLServiceStopCommand            string            // Command to stop mysql, e.g. /etc/init.d/mysql stop
@@ -41,6 +68,12 @@ type Configuration struct {
 	ReceiveSeedDataCommand             string            // Accepts incoming data (e.g. tarball over netcat)
 	SendSeedDataCommand                string            // Sends date to remote host (e.g. tarball via netcat)
 	PostCopyCommand                    string            // command that is executed after seed is done and before MySQL starts
+	LocalRestoreCopyMethod             string            // How local restore copies snapshot data into the datadir: "reflink" (reflink where supported, else copy), "hardlink" or "copy"
+	BackupDirectory                    string            // Directory where snapshot archives (compressed tarballs plus JSON manifests) are stored. Empty disables archiving
+	BinlogArchiveDirectory             string            // Directory holding archived binary logs, from which point-in-time recovery applies them. The agent archives rotated binary logs into it
+	BinlogArchivePollSeconds           uint              // Interval at which the binary log index is checked for rotated binary logs to archive. 0 disables archiving
+	BinlogArchiveRetentionDays         uint              // Archived binary logs whose last event is older than this are purged. 0 keeps archived binary logs forever
+	PointInTimeRecoveryMySQLCommand    string            // mysql client command (including credentials options) through which point-in-time recovery applies binary logs
 	AgentsServer                       string            // HTTP address of the orchestrator agents server
 	AgentsServerPort                   string            // HTTP port of the orchestrator agents server
 	HTTPPort                           uint              // HTTP port on which this service listens
@@ -68,13 +101,44 @@ var Config *Configuration = NewConfiguration()
 func NewConfiguration() *Configuration {
 	return &Configuration{
 		SnapshotMountPoint:                 "",
+		SnapshotMountReadOnly:              true,
+		SnapshotMountOptions:               map[string]string{"xfs": "nouuid"},
+		SnapshotMountFileSystemCheck:       false,
+		SnapshotValidationMySQLDCommand:    "mysqld",
+		SnapshotValidationDefaultsFile:     "/etc/my.cnf",
+		SnapshotValidationOverlayDirectory: "/var/tmp",
+		SnapshotValidationMySQLUser:        "mysql",
+		SnapshotValidationPort:             33306,
+		SnapshotValidationTimeoutSeconds:   1800,
+		SnapshotValidationQueries: []string{
+			"SELECT @@version",
+			"SELECT COUNT(*) FROM information_schema.SCHEMATA",
+			"SELECT COUNT(*) FROM information_schema.TABLES",
+		},
 		ContinuousPollSeconds:              60,
 		ResubmitAgentIntervalMinutes:       60,
 		CreateSnapshotCommand:              "",
+		SnapshotMonitorSeconds:             0,
+		SnapshotMonitorSeedSeconds:         5,
+		SnapshotAutoExtendThresholdPercent: 80,
+		SnapshotAutoExtendPercent:          20,
+		SnapshotMergeTimeoutSeconds:        21600,
 		AvailableLocalSnapshotHostsCommand: "",
 		AvailableSnapshotHostsCommand:      "",
 		SnapshotVolumesFilter:              "",
+		SnapshotVolumesTag:                 "orchestrator-agent",
+		SnapshotSchedule:                   "",
+		SnapshotScheduleJitterSeconds:      300,
+		MySQLConfigFile:                    "/etc/my.cnf",
+		MySQLSocket:                        "",
+		MySQLHostname:                      "",
+		MySQLConnectPort:                   3306,
+		MySQLCredentialsConfigFile:         "",
+		MySQLConnectTimeoutSeconds:         1,
+		MySQLMaxConnections:                3,
+		MySQLInstances:                     make(MySQLInstanceMap),
 		MySQLDatadirCommand:                "",
+		MySQLDiskUsageRefreshSeconds:       300,
 		MySQLPortCommand:                   "",
 		MySQLDeleteDatadirContentCommand:   "",
 		MySQLServiceStopCommand:            "",
@@ -83,6 +147,12 @@ func NewConfiguration() *Configuration {
 		ReceiveSeedDataCommand:             "",
 		SendSeedDataCommand:                "",
 		PostCopyCommand:                    "",
+		LocalRestoreCopyMethod:             "reflink",
+		BackupDirectory:                    "",
+		BinlogArchiveDirectory:             "",
+		BinlogArchivePollSeconds:           60,
+		BinlogArchiveRetentionDays:         0,
+		PointInTimeRecoveryMySQLCommand:    "mysql",
 		AgentsServer:                       "",
 		AgentsServerPort:                   "",
 		HTTPPort:                           3002,
@@ -0,0 +1,109 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// DefaultMySQLInstanceName names the instance described by the top level MySQL settings
+const DefaultMySQLInstanceName = "default"
+
+// MySQLInstanceConfiguration holds the settings of a single MySQL instance on this host. Each field has the
+// meaning of the top level Configuration field of the same name. A named instance inherits only the settings
+// in inheritedMySQLInstanceSettings; the settings identifying the instance must be its own.
+type MySQLInstanceConfiguration struct {
+	MySQLConfigFile                  string
+	MySQLSocket                      string
+	MySQLHostname                    string
+	MySQLConnectPort                 uint
+	MySQLCredentialsConfigFile       string
+	MySQLDatadirCommand              string
+	MySQLPortCommand                 string
+	MySQLDeleteDatadirContentCommand string
+	MySQLServiceStopCommand          string
+	MySQLServiceStartCommand         string
+	MySQLServiceStatusCommand        string
+	PostCopyCommand                  string
+}
+
+// MySQLInstanceMap maps instance names to their settings
+type MySQLInstanceMap map[string]MySQLInstanceConfiguration
+
+// inheritedMySQLInstanceSettings are the settings a named instance takes from the top level settings when
+// unset. Everything else identifies a particular mysqld (its service, datadir, socket) and is never inherited,
+// lest an operation on a named instance act on the default one.
+var inheritedMySQLInstanceSettings = []string{"MySQLCredentialsConfigFile"}
+
+// requiredMySQLInstanceSettings are the settings a named instance must set itself
+var requiredMySQLInstanceSettings = []string{
+	"MySQLDatadirCommand",
+	"MySQLDeleteDatadirContentCommand",
+	"MySQLServiceStopCommand",
+	"MySQLServiceStartCommand",
+}
+
+// GetMySQLInstanceConfiguration returns the settings of the named MySQL instance. An empty name, or
+// DefaultMySQLInstanceName, is the default instance, made of the top level settings. A named instance
+// inherits unset inheritedMySQLInstanceSettings, and is invalid unless it has its own service, datadir,
+// delete and connection settings.
+func (this *Configuration) GetMySQLInstanceConfiguration(name string) (*MySQLInstanceConfiguration, error) {
+	instance := MySQLInstanceConfiguration{}
+	instanceValue := reflect.ValueOf(&instance).Elem()
+	configValue := reflect.ValueOf(this).Elem()
+	if name == "" || name == DefaultMySQLInstanceName {
+		for i := 0; i < instanceValue.NumField(); i++ {
+			instanceValue.Field(i).Set(configValue.FieldByName(instanceValue.Type().Field(i).Name))
+		}
+		return &instance, nil
+	}
+	instance, ok := this.MySQLInstances[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown MySQL instance: %s", name)
+	}
+	for _, setting := range requiredMySQLInstanceSettings {
+		if instanceValue.FieldByName(setting).String() == "" {
+			return nil, fmt.Errorf("MySQL instance %s: %s must be set", name, setting)
+		}
+	}
+	if instance.MySQLSocket == "" && instance.MySQLHostname == "" {
+		return nil, fmt.Errorf("MySQL instance %s: MySQLSocket or MySQLHostname must be set", name)
+	}
+	if instance.MySQLSocket == "" && instance.MySQLConnectPort == 0 {
+		return nil, fmt.Errorf("MySQL instance %s: MySQLConnectPort must be set along with MySQLHostname", name)
+	}
+	for _, setting := range inheritedMySQLInstanceSettings {
+		if field := instanceValue.FieldByName(setting); field.String() == "" {
+			field.Set(configValue.FieldByName(setting))
+		}
+	}
+	return &instance, nil
+}
+
+// MySQLInstanceNames returns the names of the MySQL instances on this host, the default instance first
+func (this *Configuration) MySQLInstanceNames() []string {
+	names := []string{}
+	for name := range this.MySQLInstances {
+		if name != DefaultMySQLInstanceName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append([]string{DefaultMySQLInstanceName}, names...)
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetMySQLInstanceConfiguration(t *testing.T) {
+	configuration := NewConfiguration()
+	configuration.MySQLSocket = "/var/run/mysqld/mysqld.sock"
+	configuration.MySQLServiceStartCommand = "service mysql start"
+	configuration.MySQLCredentialsConfigFile = "/etc/orchestrator-agent.cnf"
+	replica2 := MySQLInstanceConfiguration{
+		MySQLSocket:                      "/var/run/mysqld/replica2.sock",
+		MySQLDatadirCommand:              "echo /data/replica2",
+		MySQLDeleteDatadirContentCommand: "rm -rf /data/replica2/*",
+		MySQLServiceStopCommand:          "service mysql@replica2 stop",
+		MySQLServiceStartCommand:         "service mysql@replica2 start",
+	}
+	configuration.MySQLInstances = MySQLInstanceMap{"replica2": replica2}
+
+	instance, err := configuration.GetMySQLInstanceConfiguration("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if instance.MySQLSocket != "/var/run/mysqld/mysqld.sock" || instance.MySQLConfigFile != configuration.MySQLConfigFile {
+		t.Errorf("Unexpected default instance: %+v", instance)
+	}
+
+	instance, err = configuration.GetMySQLInstanceConfiguration("replica2")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if instance.MySQLSocket != "/var/run/mysqld/replica2.sock" || instance.MySQLServiceStartCommand != "service mysql@replica2 start" {
+		t.Errorf("Unexpected instance settings: %+v", instance)
+	}
+	if instance.MySQLCredentialsConfigFile != "/etc/orchestrator-agent.cnf" {
+		t.Errorf("Expected inherited MySQLCredentialsConfigFile; got %+v", instance.MySQLCredentialsConfigFile)
+	}
+	if instance.MySQLConfigFile != "" || instance.MySQLServiceStatusCommand != "" {
+		t.Errorf("Unexpected inherited identity settings: %+v", instance)
+	}
+	if configuration.MySQLInstances["replica2"].MySQLCredentialsConfigFile != "" {
+		t.Errorf("Configured instance modified")
+	}
+
+	if _, err := configuration.GetMySQLInstanceConfiguration("unknown"); err == nil {
+		t.Errorf("Expected error on unknown instance")
+	}
+}
+
+func TestGetMySQLInstanceConfigurationIncomplete(t *testing.T) {
+	configuration := NewConfiguration()
+	configuration.MySQLSocket = "/var/run/mysqld/mysqld.sock"
+	configuration.MySQLServiceStopCommand = "service mysql stop"
+	complete := MySQLInstanceConfiguration{
+		MySQLHostname:                    "127.0.0.1",
+		MySQLConnectPort:                 3307,
+		MySQLDatadirCommand:              "echo /data/replica2",
+		MySQLDeleteDatadirContentCommand: "rm -rf /data/replica2/*",
+		MySQLServiceStopCommand:          "service mysql@replica2 stop",
+		MySQLServiceStartCommand:         "service mysql@replica2 start",
+	}
+	noStop := complete
+	noStop.MySQLServiceStopCommand = ""
+	noConnection := complete
+	noConnection.MySQLHostname = ""
+	noPort := complete
+	noPort.MySQLConnectPort = 0
+	configuration.MySQLInstances = MySQLInstanceMap{
+		"complete":     complete,
+		"noStop":       noStop,
+		"noConnection": noConnection,
+		"noPort":       noPort,
+	}
+
+	if instance, err := configuration.GetMySQLInstanceConfiguration("complete"); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	} else if instance.MySQLSocket != "" {
+		t.Errorf("Expected no inherited MySQLSocket; got %+v", instance.MySQLSocket)
+	}
+	for _, name := range []string{"noStop", "noConnection", "noPort"} {
+		if _, err := configuration.GetMySQLInstanceConfiguration(name); err == nil {
+			t.Errorf("Expected error on incomplete instance %s", name)
+		}
+	}
+}
+
+func TestMySQLInstanceNames(t *testing.T) {
+	configuration := NewConfiguration()
+	configuration.MySQLInstances = MySQLInstanceMap{"b": {}, "a": {}, DefaultMySQLInstanceName: {}}
+	if names := configuration.MySQLInstanceNames(); !reflect.DeepEqual(names, []string{DefaultMySQLInstanceName, "a", "b"}) {
+		t.Errorf("Unexpected names: %+v", names)
+	}
+}
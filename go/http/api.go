@@ -17,19 +17,23 @@
 package http
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
-	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/render"
+	"github.com/outbrain/golib/log"
 	"github.com/outbrain/orchestrator-agent/go/agent"
 	"github.com/outbrain/orchestrator-agent/go/config"
+	"github.com/outbrain/orchestrator-agent/go/inst"
+	"github.com/outbrain/orchestrator-agent/go/mysql"
 	"github.com/outbrain/orchestrator-agent/go/osagent"
 )
 
@@ -37,6 +41,9 @@ type HttpAPI struct{}
 
 var API HttpAPI = HttpAPI{}
 
+// defaultBinlogEventsLimit caps the number of events returned by a single binlog events request
+const defaultBinlogEventsLimit = 10000
+
 // APIResponseCode is an OK/ERROR response code
 type APIResponseCode int
 
@@ -83,6 +90,16 @@ func (this *HttpAPI) validateToken(r render.Render, req *http.Request) error {
 	return err
 }
 
+// mysqlInstance returns the MySQL instance named by the "instance" request parameter, the default instance
+// when none is given. An unknown instance is responded with an error.
+func (this *HttpAPI) mysqlInstance(r render.Render, req *http.Request) (*osagent.MySQLInstance, error) {
+	instance, err := osagent.GetMySQLInstance(req.URL.Query().Get("instance"))
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+	}
+	return instance, err
+}
+
 // Hostname provides information on this process
 func (this *HttpAPI) Hostname(params martini.Params, r render.Render) {
 	hostname, err := os.Hostname()
@@ -106,12 +123,16 @@ func (this *HttpAPI) ListLogicalVolumes(params martini.Params, r render.Render,
 	r.JSON(200, output)
 }
 
-// ListSnapshotsLogicalVolumes lists logical volumes by pattern
+// ListSnapshotsLogicalVolumes lists MySQL snapshot logical volumes, optionally filtered by tag
 func (this *HttpAPI) ListSnapshotsLogicalVolumes(params martini.Params, r render.Render, req *http.Request) {
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
-	output, err := osagent.LogicalVolumes("", config.Config.SnapshotVolumesFilter)
+	tag := req.URL.Query().Get("tag")
+	if purpose := req.URL.Query().Get("purpose"); purpose != "" {
+		tag = osagent.SnapshotTag("purpose", purpose)
+	}
+	output, err := osagent.SnapshotLogicalVolumes(tag)
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -136,12 +157,195 @@ func (this *HttpAPI) LogicalVolume(params martini.Params, r render.Render, req *
 	r.JSON(200, output)
 }
 
+// ListVolumeGroups lists volume groups, along with their capacity and physical volumes
+func (this *HttpAPI) ListVolumeGroups(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	output, err := osagent.VolumeGroups(params["vg"])
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, output)
+}
+
+// SnapshotFeasibility checks whether a snapshot of given size can be created for the datadir's logical volume
+func (this *HttpAPI) SnapshotFeasibility(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	size, err := osagent.ParseSize(req.URL.Query().Get("size"))
+	if err == nil && size == 0 {
+		err = errors.New("size must be positive")
+	}
+	if err != nil {
+		r.JSON(400, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	output, err := osagent.GetSnapshotFeasibility(size)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, output)
+}
+
+// RollbackSnapshot rolls back the MySQL datadir volume to a given snapshot, via LVM merge. Returns the rollback job.
+func (this *HttpAPI) RollbackSnapshot(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	lv := params["lv"]
+	if lv == "" {
+		lv = req.URL.Query().Get("lv")
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	job, err := instance.RollbackSnapshot(lv)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, job.State())
+}
+
+// ValidateSnapshot test-restores a snapshot with a throwaway mysqld. Returns the validation job.
+func (this *HttpAPI) ValidateSnapshot(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	lv := params["lv"]
+	if lv == "" {
+		lv = req.URL.Query().Get("lv")
+	}
+	job, err := osagent.ValidateSnapshot(lv)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, job.State())
+}
+
+// ArchiveSnapshot starts archiving a snapshot into a backup file, returning the archive job
+func (this *HttpAPI) ArchiveSnapshot(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	lv := params["lv"]
+	if lv == "" {
+		lv = req.URL.Query().Get("lv")
+	}
+	job, err := osagent.ArchiveSnapshot(lv)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, job.State())
+}
+
+// ListBackups lists the catalog of snapshot archives in the backup directory
+func (this *HttpAPI) ListBackups(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	output, err := osagent.BackupCatalog()
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, output)
+}
+
+// Backup returns the catalog entry of a single snapshot archive
+func (this *HttpAPI) Backup(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	output, err := osagent.GetBackup(params["name"])
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, output)
+}
+
+// VerifyBackup starts verifying the checksum of a snapshot archive, returning the verification job
+func (this *HttpAPI) VerifyBackup(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	job, err := osagent.VerifyBackup(params["name"])
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, job.State())
+}
+
+// RestoreBackup starts restoring a snapshot archive into the MySQL datadir, returning the restore job
+func (this *HttpAPI) RestoreBackup(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	job, err := instance.RestoreBackup(params["name"], params["seedId"])
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, job.State())
+}
+
+// ListJobs lists asynchronous agent jobs, optionally filtered by type
+func (this *HttpAPI) ListJobs(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	r.JSON(200, osagent.Jobs(req.URL.Query().Get("type")))
+}
+
+// Job returns the state of a single asynchronous agent job
+func (this *HttpAPI) Job(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	output, err := osagent.GetJob(params["jobId"])
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, output)
+}
+
 // GetMount shows the configured mount point's status
 func (this *HttpAPI) GetMount(params martini.Params, r render.Render, req *http.Request) {
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
-	output, err := osagent.GetMount(config.Config.SnapshotMountPoint)
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	output, err := instance.GetMount(config.Config.SnapshotMountPoint)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, output)
+}
+
+// ListMounts lists all mounted file systems, along with their usage
+func (this *HttpAPI) ListMounts(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	output, err := osagent.Mounts()
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -149,7 +353,8 @@ func (this *HttpAPI) GetMount(params martini.Params, r render.Render, req *http.
 	r.JSON(200, output)
 }
 
-// MountLV mounts a logical volume on config mount point
+// MountLV mounts a logical volume on config mount point. Query params `rw` and `check` override
+// the configured read-only and file system check behavior
 func (this *HttpAPI) MountLV(params martini.Params, r render.Render, req *http.Request) {
 	if err := this.validateToken(r, req); err != nil {
 		return
@@ -158,7 +363,16 @@ func (this *HttpAPI) MountLV(params martini.Params, r render.Render, req *http.R
 	if lv == "" {
 		lv = req.URL.Query().Get("lv")
 	}
-	output, err := osagent.MountLV(config.Config.SnapshotMountPoint, lv)
+	readOnly := config.Config.SnapshotMountReadOnly
+	if readWrite := req.URL.Query().Get("rw"); readWrite != "" {
+		readOnly = (readWrite != "true" && readWrite != "1")
+	}
+	checkFileSystem := config.Config.SnapshotMountFileSystemCheck
+	if check := req.URL.Query().Get("check"); check != "" {
+		checkFileSystem = (check == "true" || check == "1")
+	}
+	allowNoRecovery := req.URL.Query().Get("norecovery") == "true" || req.URL.Query().Get("norecovery") == "1"
+	output, err := osagent.MountLV(config.Config.SnapshotMountPoint, lv, readOnly, checkFileSystem, allowNoRecovery)
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -211,27 +425,43 @@ func (this *HttpAPI) DiskUsage(params martini.Params, r render.Render, req *http
 	r.JSON(200, output)
 }
 
-// MySQLDiskUsage returns the number of bytes on the MySQL datadir
+// MySQLDiskUsage returns the number of bytes on the MySQL datadir, or with breakdown=true, the datadir's
+// usage by schema, largest tables and InnoDB files
 func (this *HttpAPI) MySQLDiskUsage(params martini.Params, r render.Render, req *http.Request) {
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
-	datadir, err := osagent.GetMySQLDataDir()
-
-	output, err := osagent.DiskUsage(datadir)
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	output, err := instance.GetMySQLDiskUsage(req.URL.Query().Get("refresh") == "true")
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
 	}
-	r.JSON(200, output)
+	if req.URL.Query().Get("breakdown") == "true" {
+		r.JSON(200, output)
+		return
+	}
+	// orchestrator expects the total size alone
+	r.JSON(200, output.Total)
 }
 
-// CreateSnapshot lists dc-local available snapshots for this host
+// CreateSnapshot creates a new snapshot, tagged with the requested purpose
 func (this *HttpAPI) CreateSnapshot(params martini.Params, r render.Render, req *http.Request) {
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
-	err := osagent.CreateSnapshot()
+	purpose := req.URL.Query().Get("purpose")
+	if purpose == "" {
+		purpose = "api"
+	}
+	if !osagent.ValidSnapshotTagValue(purpose) {
+		r.JSON(400, &APIResponse{Code: ERROR, Message: fmt.Sprintf("Invalid purpose: %s. Allowed characters are A-Z a-z 0-9 _ + . - / = :", purpose)})
+		return
+	}
+	err := osagent.CreateSnapshot(purpose)
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -239,6 +469,22 @@ func (this *HttpAPI) CreateSnapshot(params martini.Params, r render.Render, req
 	r.JSON(200, err == nil)
 }
 
+// SnapshotSchedule shows the built-in snapshot scheduler's next and last runs
+func (this *HttpAPI) SnapshotSchedule(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	r.JSON(200, agent.GetSnapshotScheduleStatus())
+}
+
+// SnapshotMonitor shows fill state of monitored snapshots, along with recent extensions and warnings
+func (this *HttpAPI) SnapshotMonitor(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	r.JSON(200, agent.GetSnapshotMonitorStatus())
+}
+
 // LocalSnapshots lists dc-local available snapshots for this host
 func (this *HttpAPI) AvailableLocalSnapshots(params martini.Params, r render.Render, req *http.Request) {
 	if err := this.validateToken(r, req); err != nil {
@@ -270,7 +516,11 @@ func (this *HttpAPI) MySQLErrorLogTail(params martini.Params, r render.Render, r
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
-	output, err := osagent.MySQLErrorLogTail()
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	output, err := instance.MySQLErrorLogTail()
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -283,7 +533,28 @@ func (this *HttpAPI) MySQLPort(params martini.Params, r render.Render, req *http
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
-	output, err := osagent.GetMySQLPort()
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	output, err := instance.GetMySQLPort()
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, output)
+}
+
+// MySQLDataDir returns the MySQL data directory
+func (this *HttpAPI) MySQLDataDir(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	output, err := instance.GetMySQLDataDir()
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -291,12 +562,41 @@ func (this *HttpAPI) MySQLPort(params martini.Params, r render.Render, req *http
 	r.JSON(200, output)
 }
 
+// MySQLInstances lists the names of the MySQL instances on this host, as accepted by the instance parameter
+func (this *HttpAPI) MySQLInstances(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	r.JSON(200, config.Config.MySQLInstanceNames())
+}
+
 // MySQLRunning checks whether the MySQL service is up
 func (this *HttpAPI) MySQLRunning(params martini.Params, r render.Render, req *http.Request) {
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
-	output, err := osagent.MySQLRunning()
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	output, err := instance.MySQLRunning()
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, output)
+}
+
+// MySQLReplicationStatus returns the replication status per channel, read through the agent's MySQL connection
+func (this *HttpAPI) MySQLReplicationStatus(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	output, err := mysql.GetReplicationStatus(instance.Config)
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -309,7 +609,11 @@ func (this *HttpAPI) MySQLStop(params martini.Params, r render.Render, req *http
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
-	err := osagent.MySQLStop()
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	err = instance.MySQLStop()
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -322,7 +626,11 @@ func (this *HttpAPI) MySQLStart(params martini.Params, r render.Render, req *htt
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
-	err := osagent.MySQLStart()
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	err = instance.MySQLStart()
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -335,7 +643,11 @@ func (this *HttpAPI) DeleteMySQLDataDir(params martini.Params, r render.Render,
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
-	err := osagent.DeleteMySQLDataDir()
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	err = instance.DeleteMySQLDataDir()
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -348,7 +660,11 @@ func (this *HttpAPI) GetMySQLDataDirAvailableDiskSpace(params martini.Params, r
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
-	output, err := osagent.GetMySQLDataDirAvailableDiskSpace()
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	output, err := instance.GetMySQLDataDirAvailableDiskSpace()
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -361,7 +677,11 @@ func (this *HttpAPI) PostCopy(params martini.Params, r render.Render, req *http.
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
-	err := osagent.PostCopy()
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	err = instance.PostCopy()
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -375,7 +695,11 @@ func (this *HttpAPI) ReceiveMySQLSeedData(params martini.Params, r render.Render
 	if err = this.validateToken(r, req); err != nil {
 		return
 	}
-	go osagent.ReceiveMySQLSeedData(params["seedId"])
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	go instance.ReceiveMySQLSeedData(params["seedId"])
 	r.JSON(200, err == nil)
 }
 
@@ -384,7 +708,11 @@ func (this *HttpAPI) SendMySQLSeedData(params martini.Params, r render.Render, r
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
-	mount, err := osagent.GetMount(config.Config.SnapshotMountPoint)
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	mount, err := instance.GetMount(config.Config.SnapshotMountPoint)
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -393,6 +721,23 @@ func (this *HttpAPI) SendMySQLSeedData(params martini.Params, r render.Render, r
 	r.JSON(200, err == nil)
 }
 
+// LocalRestoreMySQLSeedData restores the MySQL datadir from the locally mounted snapshot. Returns the restore job.
+func (this *HttpAPI) LocalRestoreMySQLSeedData(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	job, err := instance.LocalRestoreMySQLSeedData(params["seedId"])
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, job.State())
+}
+
 // AbortSeed
 func (this *HttpAPI) AbortSeed(params martini.Params, r render.Render, req *http.Request) {
 	if err := this.validateToken(r, req); err != nil {
@@ -436,8 +781,12 @@ func (this *HttpAPI) RelayLogIndexFile(params martini.Params, r render.Render, r
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
 
-	output, err := osagent.GetRelayLogIndexFileName()
+	output, err := instance.GetRelayLogIndexFileName()
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -450,8 +799,12 @@ func (this *HttpAPI) RelayLogFiles(params martini.Params, r render.Render, req *
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
 
-	output, err := osagent.GetRelayLogFileNames()
+	output, err := instance.GetRelayLogFileNames()
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -464,8 +817,12 @@ func (this *HttpAPI) RelayLogEndCoordinates(params martini.Params, r render.Rend
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
 
-	coordinates, err := osagent.GetRelayLogEndCoordinates()
+	coordinates, err := instance.GetRelayLogEndCoordinates()
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -473,34 +830,130 @@ func (this *HttpAPI) RelayLogEndCoordinates(params martini.Params, r render.Rend
 	r.JSON(200, coordinates)
 }
 
-// BinlogContents returns contents of binary log entries
-func (this *HttpAPI) RelaylogContentsTail(params martini.Params, r render.Render, req *http.Request) {
+// BinlogIndexFile returns mysql binary log index file, full path
+func (this *HttpAPI) BinlogIndexFile(params martini.Params, r render.Render, req *http.Request) {
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
 
-	var err error
-	var startPosition int64
-	if startPosition, err = strconv.ParseInt(params["start"], 10, 0); err != nil {
-		err = fmt.Errorf("Cannot parse startPosition: %s", err.Error())
+	output, err := instance.GetBinlogIndexFileName()
+	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
 	}
-	firstRelaylog := params["relaylog"]
-	var parseRelaylogs []string
-	if existingRelaylogs, err := osagent.GetRelayLogFileNames(); err != nil {
+	r.JSON(200, output)
+}
+
+// BinlogFiles returns the list of binary logs, full path
+func (this *HttpAPI) BinlogFiles(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+
+	output, err := instance.GetBinlogFileNames()
+	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
-	} else {
-		for i, relaylog := range existingRelaylogs {
-			if (firstRelaylog == relaylog) || (firstRelaylog == path.Base(relaylog)) {
-				// found the relay log we want to start with
-				parseRelaylogs = existingRelaylogs[i:]
+	}
+	r.JSON(200, output)
+}
+
+// BinlogInventory lists binary logs with their size, first/last event times and end coordinates
+func (this *HttpAPI) BinlogInventory(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+
+	inventory, err := instance.BinlogInventory()
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, inventory)
+}
+
+// BinlogEndCoordinates returns the coordinates at the end of the binary logs
+func (this *HttpAPI) BinlogEndCoordinates(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+
+	coordinates, err := instance.GetBinlogEndCoordinates()
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, coordinates)
+}
+
+// binlogEventFilterParams reads the optional db, table, event-type and server-id request parameters, each of
+// which may be repeated or comma separated, into a binary log event filter
+func binlogEventFilterParams(req *http.Request) (*osagent.BinlogEventFilter, error) {
+	values := func(name string) (result []string) {
+		for _, value := range req.URL.Query()[name] {
+			for _, token := range strings.Split(value, ",") {
+				if token = strings.TrimSpace(token); token != "" {
+					result = append(result, token)
+				}
 			}
 		}
+		return result
 	}
+	serverIds := []uint32{}
+	for _, serverId := range values("server-id") {
+		parsed, err := strconv.ParseUint(serverId, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid server-id: %s", serverId)
+		}
+		serverIds = append(serverIds, uint32(parsed))
+	}
+	return osagent.NewBinlogEventFilter(values("db"), values("table"), values("event-type"), serverIds), nil
+}
 
-	output, err := osagent.MySQLBinlogContents(parseRelaylogs, startPosition, 0)
+// BinlogContents returns contents of binary log entries
+func (this *HttpAPI) RelaylogContentsTail(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+
+	var startPosition int64
+	if startPosition, err = strconv.ParseInt(params["start"], 10, 0); err != nil {
+		err = fmt.Errorf("Cannot parse startPosition: %s", err.Error())
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	parseRelaylogs, err := instance.GetRelayLogFileNamesFrom(params["relaylog"])
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	filter, err := binlogEventFilterParams(req)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+
+	output, err := instance.MySQLBinlogContents(parseRelaylogs, startPosition, 0, filter)
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -513,8 +966,284 @@ func (this *HttpAPI) BinlogContents(params martini.Params, r render.Render, req
 	if err := this.validateToken(r, req); err != nil {
 		return
 	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+
+	var startPosition, stopPosition int64
+	if start := req.URL.Query().Get("start"); start != "" {
+		if startPosition, err = strconv.ParseInt(start, 10, 0); err != nil {
+			r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+			return
+		}
+	}
+	if stop := req.URL.Query().Get("stop"); stop != "" {
+		if stopPosition, err = strconv.ParseInt(stop, 10, 0); err != nil {
+			r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+			return
+		}
+	}
+	filter, err := binlogEventFilterParams(req)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	binlogFileNames := req.URL.Query()["binlog"]
+	output, err := instance.MySQLBinlogContents(binlogFileNames, startPosition, stopPosition, filter)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, output)
+}
+
+// PointInTimeRecovery starts applying archived binary logs onto MySQL, from the start coordinates up to
+// either of stop-datetime, stop-coordinates or stop-gtid, returning the recovery job
+func (this *HttpAPI) PointInTimeRecovery(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	if instance := req.URL.Query().Get("instance"); instance != "" && instance != config.DefaultMySQLInstanceName {
+		// Binary logs are applied through PointInTimeRecoveryMySQLCommand, which connects to the default instance
+		r.JSON(400, &APIResponse{Code: ERROR, Message: fmt.Sprintf("Point in time recovery applies to the default MySQL instance only; got instance %s", instance)})
+		return
+	}
+	startCoordinates, err := inst.ParseBinlogCoordinates(req.URL.Query().Get("start"))
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	target := osagent.PointInTimeRecoveryTarget{
+		Datetime: req.URL.Query().Get("stop-datetime"),
+		GTID:     req.URL.Query().Get("stop-gtid"),
+	}
+	if stop := req.URL.Query().Get("stop-coordinates"); stop != "" {
+		if target.Coordinates, err = inst.ParseBinlogCoordinates(stop); err != nil {
+			r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+			return
+		}
+	}
+	job, err := osagent.PointInTimeRecovery(*startCoordinates, target)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, job.State())
+}
+
+// BinlogArchive returns the state of the continuous binary log archiver
+func (this *HttpAPI) BinlogArchive(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	r.JSON(200, agent.GetBinlogArchiveStatus())
+}
 
+// ArchivedBinlogs lists archived binary logs, with their first/last event times and coordinates
+func (this *HttpAPI) ArchivedBinlogs(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	output, err := osagent.ArchivedBinlogs()
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, output)
+}
+
+// BinlogEvents returns the natively parsed events of a binary log or relay log, within an optional
+// start/stop position range and up to an optional limit of events
+func (this *HttpAPI) BinlogEvents(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+
+	var startPosition, stopPosition int64
+	limit := defaultBinlogEventsLimit
+	if start := req.URL.Query().Get("start"); start != "" {
+		if startPosition, err = strconv.ParseInt(start, 10, 0); err != nil {
+			r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+			return
+		}
+	}
+	if stop := req.URL.Query().Get("stop"); stop != "" {
+		if stopPosition, err = strconv.ParseInt(stop, 10, 0); err != nil {
+			r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+			return
+		}
+	}
+	if limitParam := req.URL.Query().Get("limit"); limitParam != "" {
+		if limit, err = strconv.Atoi(limitParam); err != nil {
+			r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+			return
+		}
+	}
+	filter, err := binlogEventFilterParams(req)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	output, err := instance.MySQLBinlogEvents(req.URL.Query().Get("binlog"), startPosition, stopPosition, filter, limit)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, output)
+}
+
+// binlogTypeParam reads the "type" request parameter: binary (default) or relay
+func binlogTypeParam(req *http.Request) (inst.BinlogType, error) {
+	switch req.URL.Query().Get("type") {
+	case "", "binary":
+		return inst.BinaryLog, nil
+	case "relay":
+		return inst.RelayLog, nil
+	}
+	return inst.BinaryLog, fmt.Errorf("Unknown log type: %s; expected binary or relay", req.URL.Query().Get("type"))
+}
+
+// BinlogCoordinatesByTime returns the coordinates of the first binary log (or, with type=relay, relay log) event
+// at or after the given time, along with the event's exact timestamp
+func (this *HttpAPI) BinlogCoordinatesByTime(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+
+	atTime, err := osagent.ParseBinlogTime(req.URL.Query().Get("time"))
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	binlogType, err := binlogTypeParam(req)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	output, err := instance.FindBinlogCoordinatesByTime(binlogType, atTime)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, output)
+}
+
+// BinlogTransactions returns the complete transactions following the start coordinates (file:pos) in the
+// binary logs (or, with type=relay, relay logs), up to a count and/or a byte budget
+func (this *HttpAPI) BinlogTransactions(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+
+	startCoordinates, err := inst.ParseBinlogCoordinates(req.URL.Query().Get("start"))
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	if startCoordinates.Type, err = binlogTypeParam(req); err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	var count int
+	var bytes int64
+	if countParam := req.URL.Query().Get("count"); countParam != "" {
+		if count, err = strconv.Atoi(countParam); err != nil {
+			r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+			return
+		}
+	}
+	if bytesParam := req.URL.Query().Get("bytes"); bytesParam != "" {
+		if bytes, err = strconv.ParseInt(bytesParam, 10, 0); err != nil {
+			r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+			return
+		}
+	}
+	output, err := instance.ReadBinlogTransactions(*startCoordinates, count, bytes)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, output)
+}
+
+// IsStreamingRequest returns true for API requests whose responses are streamed. These take care of their
+// own content encoding, as a compressing middleware would hold back streamed content until its buffer fills.
+func IsStreamingRequest(req *http.Request) bool {
+	return strings.HasPrefix(req.URL.Path, "/api/") && strings.Contains(req.URL.Path, "-stream")
+}
+
+// flushingWriter flushes the HTTP response after each write, so that streamed content reaches the client.
+// With gzip content encoding, content is written through the gzip writer, which is flushed first.
+// as it is produced, in chunks
+type flushingWriter struct {
+	writer     http.ResponseWriter
+	gzipWriter *gzip.Writer
+}
+
+func (this *flushingWriter) Write(p []byte) (int, error) {
+	var n int
 	var err error
+	if this.gzipWriter != nil {
+		if n, err = this.gzipWriter.Write(p); err == nil {
+			err = this.gzipWriter.Flush()
+		}
+	} else {
+		n, err = this.writer.Write(p)
+	}
+	if flusher, ok := this.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+// streamBinlogContents streams binary log contents onto the response: mysqlbinlog decoded text by default,
+// or raw binary log events with format=raw, gzip encoded when the client accepts it. Streaming stops when
+// the client disconnects.
+func (this *HttpAPI) streamBinlogContents(instance *osagent.MySQLInstance, w http.ResponseWriter, req *http.Request, binlogFiles []string, startPosition int64, stopPosition int64, filter *osagent.BinlogEventFilter) {
+	var err error
+	writer := &flushingWriter{writer: w}
+	if strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Vary", "Accept-Encoding")
+		writer.gzipWriter = gzip.NewWriter(w)
+		defer writer.gzipWriter.Close()
+	}
+	if req.URL.Query().Get("format") == "raw" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		err = instance.StreamRawBinlogEvents(req.Context(), binlogFiles, startPosition, stopPosition, filter, writer)
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		err = instance.StreamMySQLBinlogContents(req.Context(), binlogFiles, startPosition, stopPosition, filter, writer)
+	}
+	if err != nil {
+		// Response is already underway; the client sees a truncated stream
+		log.Errorf("Streaming binlog contents of %+v failed: %+v", binlogFiles, err)
+	}
+}
+
+// BinlogContentsStream streams contents of binary log entries, as they are produced
+func (this *HttpAPI) BinlogContentsStream(params martini.Params, w http.ResponseWriter, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+
 	var startPosition, stopPosition int64
 	if start := req.URL.Query().Get("start"); start != "" {
 		if startPosition, err = strconv.ParseInt(start, 10, 0); err != nil {
@@ -529,7 +1258,77 @@ func (this *HttpAPI) BinlogContents(params martini.Params, r render.Render, req
 		}
 	}
 	binlogFileNames := req.URL.Query()["binlog"]
-	output, err := osagent.MySQLBinlogContents(binlogFileNames, startPosition, stopPosition)
+	if len(binlogFileNames) == 0 {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: "No binlog files provided"})
+		return
+	}
+	filter, err := binlogEventFilterParams(req)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	this.streamBinlogContents(instance, w, req, binlogFileNames, startPosition, stopPosition, filter)
+}
+
+// RelaylogContentsTailStream streams contents of relay logs, from given relay log and position onwards
+func (this *HttpAPI) RelaylogContentsTailStream(params martini.Params, w http.ResponseWriter, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+
+	startPosition, err := strconv.ParseInt(params["start"], 10, 0)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: fmt.Sprintf("Cannot parse startPosition: %s", err.Error())})
+		return
+	}
+	parseRelaylogs, err := instance.GetRelayLogFileNamesFrom(params["relaylog"])
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	if len(parseRelaylogs) == 0 {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: fmt.Sprintf("Relay log not found: %s", params["relaylog"])})
+		return
+	}
+	filter, err := binlogEventFilterParams(req)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	this.streamBinlogContents(instance, w, req, parseRelaylogs, startPosition, 0, filter)
+}
+
+// BinlogGTIDSets returns the GTID sets of local binary logs, optionally only of the file containing gtid
+func (this *HttpAPI) BinlogGTIDSets(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	output, err := instance.BinlogGTIDSets(req.URL.Query().Get("gtid"))
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	r.JSON(200, output)
+}
+
+// RelayLogGTIDSets returns the GTID sets of local relay logs, optionally only of the file containing gtid
+func (this *HttpAPI) RelayLogGTIDSets(params martini.Params, r render.Render, req *http.Request) {
+	if err := this.validateToken(r, req); err != nil {
+		return
+	}
+	instance, err := this.mysqlInstance(r, req)
+	if err != nil {
+		return
+	}
+	output, err := instance.RelayLogGTIDSets(req.URL.Query().Get("gtid"))
 	if err != nil {
 		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
 		return
@@ -566,18 +1365,42 @@ func (this *HttpAPI) RegisterRequests(m *martini.ClassicMartini) {
 	m.Get("/api/lvs-snapshots", this.ListSnapshotsLogicalVolumes)
 	m.Get("/api/lv", this.LogicalVolume)
 	m.Get("/api/lv/:lv", this.LogicalVolume)
+	m.Get("/api/vgs", this.ListVolumeGroups)
+	m.Get("/api/vgs/:vg", this.ListVolumeGroups)
+	m.Get("/api/snapshot-feasibility", this.SnapshotFeasibility)
+	m.Get("/api/rollback-snapshot", this.RollbackSnapshot)
+	m.Get("/api/rollback-snapshot/:lv", this.RollbackSnapshot)
+	m.Get("/api/validate-snapshot", this.ValidateSnapshot)
+	m.Get("/api/validate-snapshot/:lv", this.ValidateSnapshot)
+	m.Get("/api/archive-snapshot", this.ArchiveSnapshot)
+	m.Get("/api/archive-snapshot/:lv", this.ArchiveSnapshot)
+	m.Get("/api/backups", this.ListBackups)
+	m.Get("/api/backup/:name", this.Backup)
+	m.Get("/api/verify-backup/:name", this.VerifyBackup)
+	m.Get("/api/restore-backup/:name/:seedId", this.RestoreBackup)
+	m.Get("/api/binlog-archive", this.BinlogArchive)
+	m.Get("/api/archived-binlogs", this.ArchivedBinlogs)
+	m.Get("/api/pitr", this.PointInTimeRecovery)
+	m.Get("/api/jobs", this.ListJobs)
+	m.Get("/api/job/:jobId", this.Job)
 	m.Get("/api/mount", this.GetMount)
+	m.Get("/api/mounts", this.ListMounts)
 	m.Get("/api/mountlv", this.MountLV)
 	m.Get("/api/removelv", this.RemoveLV)
 	m.Get("/api/umount", this.Unmount)
 	m.Get("/api/du", this.DiskUsage)
 	m.Get("/api/mysql-du", this.MySQLDiskUsage)
 	m.Get("/api/create-snapshot", this.CreateSnapshot)
+	m.Get("/api/snapshot-schedule", this.SnapshotSchedule)
+	m.Get("/api/snapshot-monitor", this.SnapshotMonitor)
 	m.Get("/api/available-snapshots-local", this.AvailableLocalSnapshots)
 	m.Get("/api/available-snapshots", this.AvailableSnapshots)
 	m.Get("/api/mysql-error-log-tail", this.MySQLErrorLogTail)
+	m.Get("/api/mysql-instances", this.MySQLInstances)
 	m.Get("/api/mysql-port", this.MySQLPort)
+	m.Get("/api/mysql-datadir", this.MySQLDataDir)
 	m.Get("/api/mysql-status", this.MySQLRunning)
+	m.Get("/api/mysql-replication-status", this.MySQLReplicationStatus)
 	m.Get("/api/mysql-stop", this.MySQLStop)
 	m.Get("/api/mysql-start", this.MySQLStart)
 	m.Get("/api/delete-mysql-datadir", this.DeleteMySQLDataDir)
@@ -585,14 +1408,26 @@ func (this *HttpAPI) RegisterRequests(m *martini.ClassicMartini) {
 	m.Get("/api/post-copy", this.PostCopy)
 	m.Get("/api/receive-mysql-seed-data/:seedId", this.ReceiveMySQLSeedData)
 	m.Get("/api/send-mysql-seed-data/:targetHost/:seedId", this.SendMySQLSeedData)
+	m.Get("/api/local-restore-mysql-seed-data/:seedId", this.LocalRestoreMySQLSeedData)
 	m.Get("/api/abort-seed/:seedId", this.AbortSeed)
 	m.Get("/api/seed-command-completed/:seedId", this.SeedCommandCompleted)
 	m.Get("/api/seed-command-succeeded/:seedId", this.SeedCommandSucceeded)
 	m.Get("/api/mysql-relay-log-index-file", this.RelayLogIndexFile)
 	m.Get("/api/mysql-relay-log-files", this.RelayLogFiles)
 	m.Get("/api/mysql-relay-log-end-coordinates", this.RelayLogEndCoordinates)
+	m.Get("/api/mysql-binlog-index-file", this.BinlogIndexFile)
+	m.Get("/api/mysql-binlog-files", this.BinlogFiles)
+	m.Get("/api/mysql-binlog-inventory", this.BinlogInventory)
+	m.Get("/api/mysql-binlog-end-coordinates", this.BinlogEndCoordinates)
 	m.Get("/api/mysql-binlog-contents", this.BinlogContents)
+	m.Get("/api/mysql-binlog-events", this.BinlogEvents)
+	m.Get("/api/mysql-binlog-coordinates-by-time", this.BinlogCoordinatesByTime)
+	m.Get("/api/mysql-binlog-transactions", this.BinlogTransactions)
+	m.Get("/api/mysql-binlog-gtid-sets", this.BinlogGTIDSets)
+	m.Get("/api/mysql-relaylog-gtid-sets", this.RelayLogGTIDSets)
 	m.Get("/api/mysql-relaylog-contents-tail/:relaylog/:start", this.RelaylogContentsTail)
+	m.Get("/api/mysql-binlog-contents-stream", this.BinlogContentsStream)
+	m.Get("/api/mysql-relaylog-contents-tail-stream/:relaylog/:start", this.RelaylogContentsTailStream)
 	m.Get("/api/custom-commands/:cmd", this.RunCommand)
 	m.Get(config.Config.StatusEndpoint, this.Status)
 }
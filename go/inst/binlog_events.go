@@ -0,0 +1,535 @@
+/*
+   Copyright 2015 Shlomi Noach, courtesy Booking.com
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package inst
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BinlogMagic is the header of every binary log and relay log file
+var BinlogMagic = []byte{0xfe, 'b', 'i', 'n'}
+
+const (
+	binlogFileHeaderSize    = 4
+	binlogEventHeaderSize   = 19
+	binlogChecksumSize      = 4
+	binlogMaxEventSize      = 1024 * 1024 * 1024
+	binlogServerVersionSize = 50
+	binlogChecksumAlgCRC32  = 1
+	binlogChecksumAlgOff    = 0
+)
+
+type BinlogEventType byte
+
+const (
+	UnknownEvent            BinlogEventType = 0
+	StartEventV3            BinlogEventType = 1
+	QueryEvent              BinlogEventType = 2
+	StopEvent               BinlogEventType = 3
+	RotateEvent             BinlogEventType = 4
+	IntvarEvent             BinlogEventType = 5
+	SlaveEvent              BinlogEventType = 7
+	AppendBlockEvent        BinlogEventType = 9
+	DeleteFileEvent         BinlogEventType = 11
+	RandEvent               BinlogEventType = 13
+	UserVarEvent            BinlogEventType = 14
+	FormatDescriptionEvent  BinlogEventType = 15
+	XidEvent                BinlogEventType = 16
+	BeginLoadQueryEvent     BinlogEventType = 17
+	ExecuteLoadQueryEvent   BinlogEventType = 18
+	TableMapEvent           BinlogEventType = 19
+	WriteRowsEventV0        BinlogEventType = 20
+	UpdateRowsEventV0       BinlogEventType = 21
+	DeleteRowsEventV0       BinlogEventType = 22
+	WriteRowsEventV1        BinlogEventType = 23
+	UpdateRowsEventV1       BinlogEventType = 24
+	DeleteRowsEventV1       BinlogEventType = 25
+	IncidentEvent           BinlogEventType = 26
+	HeartbeatEvent          BinlogEventType = 27
+	IgnorableEvent          BinlogEventType = 28
+	RowsQueryEvent          BinlogEventType = 29
+	WriteRowsEventV2        BinlogEventType = 30
+	UpdateRowsEventV2       BinlogEventType = 31
+	DeleteRowsEventV2       BinlogEventType = 32
+	GTIDEvent               BinlogEventType = 33
+	AnonymousGTIDEvent      BinlogEventType = 34
+	PreviousGTIDsEvent      BinlogEventType = 35
+	TransactionContextEvent BinlogEventType = 36
+	ViewChangeEvent         BinlogEventType = 37
+	XAPrepareEvent          BinlogEventType = 38
+	PartialUpdateRowsEvent  BinlogEventType = 39
+	TransactionPayloadEvent BinlogEventType = 40
+)
+
+var binlogEventTypeNames = map[BinlogEventType]string{
+	UnknownEvent:            "Unknown",
+	StartEventV3:            "Start_v3",
+	QueryEvent:              "Query",
+	StopEvent:               "Stop",
+	RotateEvent:             "Rotate",
+	IntvarEvent:             "Intvar",
+	SlaveEvent:              "Slave",
+	AppendBlockEvent:        "Append_block",
+	DeleteFileEvent:         "Delete_file",
+	RandEvent:               "RAND",
+	UserVarEvent:            "User_var",
+	FormatDescriptionEvent:  "Format_desc",
+	XidEvent:                "Xid",
+	BeginLoadQueryEvent:     "Begin_load_query",
+	ExecuteLoadQueryEvent:   "Execute_load_query",
+	TableMapEvent:           "Table_map",
+	WriteRowsEventV0:        "Write_rows_v0",
+	UpdateRowsEventV0:       "Update_rows_v0",
+	DeleteRowsEventV0:       "Delete_rows_v0",
+	WriteRowsEventV1:        "Write_rows_v1",
+	UpdateRowsEventV1:       "Update_rows_v1",
+	DeleteRowsEventV1:       "Delete_rows_v1",
+	IncidentEvent:           "Incident",
+	HeartbeatEvent:          "Heartbeat",
+	IgnorableEvent:          "Ignorable",
+	RowsQueryEvent:          "Rows_query",
+	WriteRowsEventV2:        "Write_rows",
+	UpdateRowsEventV2:       "Update_rows",
+	DeleteRowsEventV2:       "Delete_rows",
+	GTIDEvent:               "Gtid",
+	AnonymousGTIDEvent:      "Anonymous_Gtid",
+	PreviousGTIDsEvent:      "Previous_gtids",
+	TransactionContextEvent: "Transaction_context",
+	ViewChangeEvent:         "View_change",
+	XAPrepareEvent:          "XA_prepare",
+	PartialUpdateRowsEvent:  "Update_rows_partial",
+	TransactionPayloadEvent: "Transaction_payload",
+}
+
+// String returns the event type name, as presented by SHOW BINLOG EVENTS
+func (this BinlogEventType) String() string {
+	if name, ok := binlogEventTypeNames[this]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown_%d", byte(this))
+}
+
+// IsRowsEvent returns true for write/update/delete rows events, of any version
+func (this BinlogEventType) IsRowsEvent() bool {
+	switch this {
+	case WriteRowsEventV0, UpdateRowsEventV0, DeleteRowsEventV0,
+		WriteRowsEventV1, UpdateRowsEventV1, DeleteRowsEventV1,
+		WriteRowsEventV2, UpdateRowsEventV2, DeleteRowsEventV2,
+		PartialUpdateRowsEvent:
+		return true
+	}
+	return false
+}
+
+// BinlogEvent is a binary log event, decoded at header level plus the type specific fields
+// this package understands. Positions are offsets within the file the event was read from.
+type BinlogEvent struct {
+	Type          BinlogEventType
+	TypeName      string
+	Timestamp     time.Time
+	ServerId      uint32
+	StartPosition int64
+	EndPosition   int64
+	Flags         uint16
+	ServerVersion string `json:",omitempty"`
+	Schema        string `json:",omitempty"`
+	Table         string `json:",omitempty"`
+	TableId       uint64 `json:",omitempty"`
+	SQL           string `json:",omitempty"`
+	ErrorCode     uint16 `json:",omitempty"`
+	NextLogFile   string `json:",omitempty"`
+	NextPosition  int64  `json:",omitempty"`
+	Xid           uint64 `json:",omitempty"`
+	GTID          string `json:",omitempty"`
+	PreviousGTIDs string `json:",omitempty"`
+}
+
+// binlogFormat is what the format description event tells about the events that follow it
+type binlogFormat struct {
+	binlogVersion     uint16
+	serverVersion     string
+	headerLength      int
+	postHeaderLengths []byte
+	checksumAlgorithm byte
+}
+
+func (this *binlogFormat) postHeaderLength(eventType BinlogEventType, defaultLength int) int {
+	if index := int(eventType) - 1; index >= 0 && index < len(this.postHeaderLengths) {
+		return int(this.postHeaderLengths[index])
+	}
+	return defaultLength
+}
+
+type binlogTable struct {
+	schema string
+	table  string
+}
+
+// BinlogEventReader reads binary log (v4) or relay log events off a stream, starting at the file header
+type BinlogEventReader struct {
+	source   io.Reader
+	reader   *bufio.Reader
+	position int64
+	format   *binlogFormat
+	tables   map[uint64]binlogTable
+}
+
+// NewBinlogEventReader verifies the binary log file header and returns a reader positioned at the first event
+func NewBinlogEventReader(source io.Reader) (*BinlogEventReader, error) {
+	this := &BinlogEventReader{
+		source: source,
+		reader: bufio.NewReaderSize(source, 64*1024),
+		tables: make(map[uint64]binlogTable),
+	}
+	magic := make([]byte, binlogFileHeaderSize)
+	if _, err := io.ReadFull(this.reader, magic); err != nil {
+		return nil, fmt.Errorf("Cannot read binary log header: %+v", err)
+	}
+	if !bytes.Equal(magic, BinlogMagic) {
+		return nil, errors.New("Not a binary log: bad magic number")
+	}
+	this.position = binlogFileHeaderSize
+	return this, nil
+}
+
+// Position returns the offset at which the next event starts
+func (this *BinlogEventReader) Position() int64 {
+	return this.position
+}
+
+// SeekPosition moves the reader forward to given offset, which is expected to be the start of an event,
+// without reading the events on the way. The format description event must have been read beforehand,
+// and none of the skipped events may affect decoding: use SkipToPosition unless resuming a binary log
+// (which has a single format description event) at an event boundary known from an earlier read.
+func (this *BinlogEventReader) SeekPosition(position int64) error {
+	if this.format == nil {
+		return errors.New("Cannot seek before reading the format description event")
+	}
+	if position < this.position {
+		return fmt.Errorf("Cannot seek backwards from %d to %d", this.position, position)
+	}
+	if seeker, ok := this.source.(io.Seeker); ok {
+		if _, err := seeker.Seek(position, io.SeekStart); err != nil {
+			return err
+		}
+		this.reader.Reset(this.source)
+	} else if _, err := this.reader.Discard(int(position - this.position)); err != nil {
+		return err
+	}
+	this.position = position
+	return nil
+}
+
+// SkipToPosition moves the reader forward to given offset, which is expected to be the start of an event.
+// Events on the way are not returned, but those that affect the decoding of later events are applied:
+// format description events (a relay log holds the master's besides its own, possibly with a different
+// checksum algorithm), table map events and stop events. The bodies of other events are skipped unread.
+func (this *BinlogEventReader) SkipToPosition(position int64) error {
+	for this.position < position {
+		header, err := this.reader.Peek(binlogEventHeaderSize)
+		if err != nil {
+			if len(header) == 0 && err == io.EOF {
+				return io.EOF
+			}
+			return io.ErrUnexpectedEOF
+		}
+		eventType := BinlogEventType(header[4])
+		eventSize := int64(binary.LittleEndian.Uint32(header[9:13]))
+		if eventSize < binlogEventHeaderSize || eventSize > binlogMaxEventSize {
+			return fmt.Errorf("Invalid event size %d at position %d", eventSize, this.position)
+		}
+		if this.position+eventSize > position {
+			return fmt.Errorf("Position %d is not at an event boundary: event at %d ends at %d", position, this.position, this.position+eventSize)
+		}
+		switch eventType {
+		case FormatDescriptionEvent, TableMapEvent, StopEvent:
+			if _, err := this.ReadEvent(); err != nil {
+				return err
+			}
+		default:
+			if this.format == nil {
+				return fmt.Errorf("Expected format description event at position %d, found %s; only binary log v4 is supported", this.position, eventType.String())
+			}
+			if _, err := this.reader.Discard(int(eventSize)); err != nil {
+				return io.ErrUnexpectedEOF
+			}
+			this.position += eventSize
+		}
+	}
+	return nil
+}
+
+// ReadEvent reads the next event. It returns io.EOF at the end of the stream, and io.ErrUnexpectedEOF
+// when the stream ends with a partial event, as is the case with an active binary log.
+func (this *BinlogEventReader) ReadEvent() (*BinlogEvent, error) {
+	header := make([]byte, binlogEventHeaderSize)
+	if n, err := io.ReadFull(this.reader, header); err != nil {
+		if n == 0 && err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	event := &BinlogEvent{
+		Timestamp:     time.Unix(int64(binary.LittleEndian.Uint32(header[0:4])), 0),
+		Type:          BinlogEventType(header[4]),
+		ServerId:      binary.LittleEndian.Uint32(header[5:9]),
+		Flags:         binary.LittleEndian.Uint16(header[17:19]),
+		StartPosition: this.position,
+	}
+	event.TypeName = event.Type.String()
+	eventSize := int64(binary.LittleEndian.Uint32(header[9:13]))
+	if eventSize < binlogEventHeaderSize || eventSize > binlogMaxEventSize {
+		return nil, fmt.Errorf("Invalid event size %d at position %d", eventSize, this.position)
+	}
+	event.EndPosition = this.position + eventSize
+
+	data := make([]byte, eventSize)
+	copy(data, header)
+	if _, err := io.ReadFull(this.reader, data[binlogEventHeaderSize:]); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	this.position = event.EndPosition
+
+	if this.format == nil && event.Type != FormatDescriptionEvent {
+		return nil, fmt.Errorf("Expected format description event at position %d, found %s; only binary log v4 is supported", event.StartPosition, event.TypeName)
+	}
+	if event.Type == FormatDescriptionEvent {
+		format, err := parseFormatDescription(data)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse format description event at position %d: %+v", event.StartPosition, err)
+		}
+		this.format = format
+		event.ServerVersion = format.serverVersion
+	}
+	if this.format.checksumAlgorithm == binlogChecksumAlgCRC32 {
+		if len(data) < binlogEventHeaderSize+binlogChecksumSize {
+			return nil, fmt.Errorf("Event at position %d too short for its checksum", event.StartPosition)
+		}
+		checksumOffset := len(data) - binlogChecksumSize
+		if crc32.ChecksumIEEE(data[:checksumOffset]) != binary.LittleEndian.Uint32(data[checksumOffset:]) {
+			return nil, fmt.Errorf("Checksum mismatch on %s event at position %d", event.TypeName, event.StartPosition)
+		}
+		data = data[:checksumOffset]
+	}
+	if event.Type == FormatDescriptionEvent {
+		return event, nil
+	}
+	headerLength := this.format.headerLength
+	if headerLength < binlogEventHeaderSize || headerLength > len(data) {
+		headerLength = binlogEventHeaderSize
+	}
+	if err := this.decodeEventBody(event, data[headerLength:]); err != nil {
+		return nil, fmt.Errorf("Cannot decode %s event at position %d: %+v", event.TypeName, event.StartPosition, err)
+	}
+	return event, nil
+}
+
+// parseFormatDescription decodes a format description event, including its header
+func parseFormatDescription(data []byte) (*binlogFormat, error) {
+	body := data[binlogEventHeaderSize:]
+	if len(body) < 2+binlogServerVersionSize+4+1 {
+		return nil, errors.New("event too short")
+	}
+	format := &binlogFormat{
+		binlogVersion:     binary.LittleEndian.Uint16(body[0:2]),
+		serverVersion:     strings.TrimRight(string(body[2:2+binlogServerVersionSize]), "\x00"),
+		headerLength:      int(body[2+binlogServerVersionSize+4]),
+		checksumAlgorithm: binlogChecksumAlgOff,
+	}
+	if format.binlogVersion != 4 {
+		return nil, fmt.Errorf("unsupported binary log version %d", format.binlogVersion)
+	}
+	postHeaderLengths := body[2+binlogServerVersionSize+4+1:]
+	if serverVersionSupportsChecksum(format.serverVersion) {
+		// The event ends with the checksum algorithm and the checksum itself
+		if len(postHeaderLengths) < 1+binlogChecksumSize {
+			return nil, errors.New("event too short for checksum algorithm")
+		}
+		format.checksumAlgorithm = postHeaderLengths[len(postHeaderLengths)-1-binlogChecksumSize]
+		postHeaderLengths = postHeaderLengths[:len(postHeaderLengths)-1-binlogChecksumSize]
+	}
+	if format.checksumAlgorithm != binlogChecksumAlgOff && format.checksumAlgorithm != binlogChecksumAlgCRC32 {
+		return nil, fmt.Errorf("unsupported checksum algorithm %d", format.checksumAlgorithm)
+	}
+	format.postHeaderLengths = postHeaderLengths
+	return format, nil
+}
+
+// serverVersionSupportsChecksum returns true for servers (5.6.1 and above) whose format description
+// events state a checksum algorithm
+func serverVersionSupportsChecksum(serverVersion string) bool {
+	tokens := strings.SplitN(strings.SplitN(serverVersion, "-", 2)[0], ".", 3)
+	versions := []int{0, 0, 0}
+	for i, token := range tokens {
+		digits := strings.TrimRightFunc(token, func(r rune) bool { return r < '0' || r > '9' })
+		versions[i], _ = strconv.Atoi(digits)
+	}
+	return versions[0]*10000+versions[1]*100+versions[2] >= 50601
+}
+
+// formatUUID formats 16 bytes as a server UUID
+func formatUUID(data []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", data[0:4], data[4:6], data[6:8], data[8:10], data[10:16])
+}
+
+// readTableId reads a 6 (or, with old post headers, 4) byte table id
+func readTableId(data []byte, postHeaderLength int) (uint64, int) {
+	if postHeaderLength == 6 {
+		return uint64(binary.LittleEndian.Uint32(data[0:4])), 4
+	}
+	tableIdBytes := make([]byte, 8)
+	copy(tableIdBytes, data[0:6])
+	return binary.LittleEndian.Uint64(tableIdBytes), 6
+}
+
+// decodeEventBody decodes the type specific parts of an event, given its post header and body
+func (this *BinlogEventReader) decodeEventBody(event *BinlogEvent, body []byte) (err error) {
+	defer func() {
+		// Truncated or malformed bodies surface as slice bounds errors
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed event body: %+v", r)
+		}
+	}()
+	switch event.Type {
+	case QueryEvent:
+		postHeaderLength := this.format.postHeaderLength(event.Type, 13)
+		schemaLength := int(body[8])
+		event.ErrorCode = binary.LittleEndian.Uint16(body[9:11])
+		statusVarsLength := 0
+		if postHeaderLength >= 13 {
+			statusVarsLength = int(binary.LittleEndian.Uint16(body[11:13]))
+		}
+		offset := postHeaderLength + statusVarsLength
+		event.Schema = string(body[offset : offset+schemaLength])
+		event.SQL = string(body[offset+schemaLength+1:])
+	case RotateEvent:
+		postHeaderLength := this.format.postHeaderLength(event.Type, 8)
+		event.NextPosition = int64(binary.LittleEndian.Uint64(body[0:8]))
+		event.NextLogFile = string(body[postHeaderLength:])
+	case XidEvent:
+		event.Xid = binary.LittleEndian.Uint64(body[0:8])
+	case GTIDEvent, AnonymousGTIDEvent:
+		gno := int64(binary.LittleEndian.Uint64(body[17:25]))
+		event.GTID = fmt.Sprintf("%s:%d", formatUUID(body[1:17]), gno)
+	case PreviousGTIDsEvent:
+		event.PreviousGTIDs = parsePreviousGTIDs(body)
+	case TableMapEvent:
+		postHeaderLength := this.format.postHeaderLength(event.Type, 8)
+		tableId, _ := readTableId(body, postHeaderLength)
+		offset := postHeaderLength
+		schemaLength := int(body[offset])
+		event.Schema = string(body[offset+1 : offset+1+schemaLength])
+		offset += 1 + schemaLength + 1
+		tableLength := int(body[offset])
+		event.Table = string(body[offset+1 : offset+1+tableLength])
+		event.TableId = tableId
+		this.tables[tableId] = binlogTable{schema: event.Schema, table: event.Table}
+	case RowsQueryEvent:
+		// The length byte is unreliable for long statements; the statement spans the remainder of the event
+		event.SQL = string(body[1:])
+	case StopEvent:
+		this.tables = make(map[uint64]binlogTable)
+	default:
+		if event.Type.IsRowsEvent() {
+			postHeaderLength := this.format.postHeaderLength(event.Type, 8)
+			event.TableId, _ = readTableId(body, postHeaderLength)
+			if table, ok := this.tables[event.TableId]; ok {
+				event.Schema = table.schema
+				event.Table = table.table
+			}
+		}
+	}
+	return nil
+}
+
+// parsePreviousGTIDs decodes the body of a previous GTIDs event into MySQL's GTID set notation
+func parsePreviousGTIDs(body []byte) string {
+	gtidSet := NewGTIDSet()
+	sidCount := int(binary.LittleEndian.Uint64(body[0:8]))
+	offset := 8
+	for i := 0; i < sidCount; i++ {
+		sid := formatUUID(body[offset : offset+16])
+		intervalCount := int(binary.LittleEndian.Uint64(body[offset+16 : offset+24]))
+		offset += 24
+		for j := 0; j < intervalCount; j++ {
+			gtidSet.AddInterval(sid, GTIDInterval{
+				Start: int64(binary.LittleEndian.Uint64(body[offset : offset+8])),
+				// interval end is exclusive
+				End: int64(binary.LittleEndian.Uint64(body[offset+8:offset+16])) - 1,
+			})
+			offset += 16
+		}
+	}
+	return gtidSet.String()
+}
+
+// ReadBinlogEvents reads the events of a binary log or relay log file, which start at or after the start
+// position and before the stop position (0 meaning the end of file), up to limit events (0 meaning
+// no limit). A partial event at the end of the file, as with an active binary log, ends the read.
+func ReadBinlogEvents(fileName string, startPosition int64, stopPosition int64, limit int) ([]BinlogEvent, error) {
+	events := []BinlogEvent{}
+	err := ScanBinlogEvents(fileName, startPosition, stopPosition, func(event *BinlogEvent) bool {
+		events = append(events, *event)
+		return limit <= 0 || len(events) < limit
+	})
+	return events, err
+}
+
+// ScanBinlogEvents reads the events of a binary log or relay log file, as ReadBinlogEvents, passing each
+// event to given function, which returns false to stop scanning
+func ScanBinlogEvents(fileName string, startPosition int64, stopPosition int64, onEvent func(event *BinlogEvent) bool) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := NewBinlogEventReader(file)
+	if err != nil {
+		return fmt.Errorf("%s: %+v", fileName, err)
+	}
+	if err := reader.SkipToPosition(startPosition); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("%s: %+v", fileName, err)
+	}
+	for {
+		event, err := reader.ReadEvent()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %+v", fileName, err)
+		}
+		if stopPosition > 0 && event.StartPosition >= stopPosition {
+			return nil
+		}
+		if !onEvent(event) {
+			return nil
+		}
+	}
+}
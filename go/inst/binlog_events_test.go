@@ -0,0 +1,293 @@
+package inst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+var testServerUUID = []byte{0x3e, 0x11, 0xfa, 0x47, 0x71, 0xca, 0x11, 0xe1, 0x9e, 0x33, 0xc8, 0x0a, 0xa9, 0x42, 0x95, 0x62}
+
+// testBinlogBuilder crafts binary log v4 files with CRC32 checksums (or none, after a format description
+// event without checksums), for tests
+type testBinlogBuilder struct {
+	buffer      bytes.Buffer
+	checksumOff bool
+}
+
+func newTestBinlogBuilder() *testBinlogBuilder {
+	builder := &testBinlogBuilder{}
+	builder.buffer.Write(BinlogMagic)
+	return builder
+}
+
+func (this *testBinlogBuilder) addEvent(eventType BinlogEventType, timestamp uint32, body []byte) {
+	// The format description event always ends with a checksum field
+	withChecksum := !this.checksumOff || eventType == FormatDescriptionEvent
+	eventSize := binlogEventHeaderSize + len(body)
+	if withChecksum {
+		eventSize += binlogChecksumSize
+	}
+	header := make([]byte, binlogEventHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:4], timestamp)
+	header[4] = byte(eventType)
+	binary.LittleEndian.PutUint32(header[5:9], 1)
+	binary.LittleEndian.PutUint32(header[9:13], uint32(eventSize))
+	binary.LittleEndian.PutUint32(header[13:17], uint32(this.buffer.Len()+eventSize))
+	data := append(header, body...)
+	checksum := make([]byte, binlogChecksumSize)
+	binary.LittleEndian.PutUint32(checksum, crc32.ChecksumIEEE(data))
+	this.buffer.Write(data)
+	if withChecksum {
+		this.buffer.Write(checksum)
+	}
+}
+
+func (this *testBinlogBuilder) addFormatDescription() {
+	this.addFormatDescriptionOf("8.0.20-log", binlogChecksumAlgCRC32)
+}
+
+func (this *testBinlogBuilder) addFormatDescriptionOf(serverVersion string, checksumAlgorithm byte) {
+	body := make([]byte, 2+binlogServerVersionSize+4+1)
+	binary.LittleEndian.PutUint16(body[0:2], 4)
+	copy(body[2:], serverVersion)
+	body[2+binlogServerVersionSize+4] = binlogEventHeaderSize
+	postHeaderLengths := make([]byte, 40)
+	postHeaderLengths[QueryEvent-1] = 13
+	postHeaderLengths[RotateEvent-1] = 8
+	postHeaderLengths[TableMapEvent-1] = 8
+	postHeaderLengths[WriteRowsEventV2-1] = 10
+	postHeaderLengths[GTIDEvent-1] = 42
+	body = append(body, postHeaderLengths...)
+	body = append(body, checksumAlgorithm)
+	// The checksum of the format description event is appended by addEvent
+	this.addEvent(FormatDescriptionEvent, 1577869200, body)
+	this.checksumOff = (checksumAlgorithm == binlogChecksumAlgOff)
+}
+
+func (this *testBinlogBuilder) addPreviousGTIDs() {
+	body := make([]byte, 8+16+8+16)
+	binary.LittleEndian.PutUint64(body[0:8], 1)
+	copy(body[8:24], testServerUUID)
+	binary.LittleEndian.PutUint64(body[24:32], 1)
+	binary.LittleEndian.PutUint64(body[32:40], 1)
+	binary.LittleEndian.PutUint64(body[40:48], 6)
+	this.addEvent(PreviousGTIDsEvent, 1577869200, body)
+}
+
+func (this *testBinlogBuilder) addGTID(timestamp uint32, gno uint64) {
+	body := make([]byte, 42)
+	copy(body[1:17], testServerUUID)
+	binary.LittleEndian.PutUint64(body[17:25], gno)
+	this.addEvent(GTIDEvent, timestamp, body)
+}
+
+func (this *testBinlogBuilder) addQuery(timestamp uint32, schema string, query string) {
+	body := make([]byte, 13)
+	body[8] = byte(len(schema))
+	binary.LittleEndian.PutUint16(body[11:13], 2)
+	body = append(body, 0, 0)
+	body = append(body, []byte(schema)...)
+	body = append(body, 0)
+	body = append(body, []byte(query)...)
+	this.addEvent(QueryEvent, timestamp, body)
+}
+
+func (this *testBinlogBuilder) addTableMap(timestamp uint32, tableId uint64, schema string, table string) {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint32(body[0:4], uint32(tableId))
+	body = append(body, byte(len(schema)))
+	body = append(body, []byte(schema)...)
+	body = append(body, 0, byte(len(table)))
+	body = append(body, []byte(table)...)
+	body = append(body, 0, 1, 3, 0)
+	this.addEvent(TableMapEvent, timestamp, body)
+}
+
+func (this *testBinlogBuilder) addWriteRows(timestamp uint32, tableId uint64) {
+	body := make([]byte, 10)
+	binary.LittleEndian.PutUint32(body[0:4], uint32(tableId))
+	body = append(body, 1, 0xff, 0, 1, 0, 0, 0)
+	this.addEvent(WriteRowsEventV2, timestamp, body)
+}
+
+func (this *testBinlogBuilder) addXid(timestamp uint32, xid uint64) {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint64(body, xid)
+	this.addEvent(XidEvent, timestamp, body)
+}
+
+func (this *testBinlogBuilder) addRotate(timestamp uint32, nextLogFile string) {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint64(body, 4)
+	body = append(body, []byte(nextLogFile)...)
+	this.addEvent(RotateEvent, timestamp, body)
+}
+
+// testBinlog returns a binary log with a DDL transaction and a row based transaction
+func testBinlog() []byte {
+	builder := newTestBinlogBuilder()
+	builder.addFormatDescription()
+	builder.addPreviousGTIDs()
+	builder.addGTID(1577869201, 6)
+	builder.addQuery(1577869201, "shop", "CREATE TABLE orders (id int)")
+	builder.addGTID(1577869202, 7)
+	builder.addQuery(1577869202, "shop", "BEGIN")
+	builder.addTableMap(1577869202, 108, "shop", "orders")
+	builder.addWriteRows(1577869202, 108)
+	builder.addXid(1577869202, 42)
+	builder.addRotate(1577869203, "mysql-bin.000002")
+	return builder.buffer.Bytes()
+}
+
+func TestBinlogEventReader(t *testing.T) {
+	reader, err := NewBinlogEventReader(bytes.NewReader(testBinlog()))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	events := []*BinlogEvent{}
+	for {
+		event, err := reader.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 10 {
+		t.Fatalf("Expected 10 events, got %d", len(events))
+	}
+	if events[0].Type != FormatDescriptionEvent || events[0].ServerVersion != "8.0.20-log" || events[0].StartPosition != 4 {
+		t.Errorf("Unexpected format description event: %+v", events[0])
+	}
+	if events[1].PreviousGTIDs != "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5" {
+		t.Errorf("Unexpected previous GTIDs: %s", events[1].PreviousGTIDs)
+	}
+	if events[2].GTID != "3e11fa47-71ca-11e1-9e33-c80aa9429562:6" || events[2].TypeName != "Gtid" {
+		t.Errorf("Unexpected GTID event: %+v", events[2])
+	}
+	if events[3].Schema != "shop" || events[3].SQL != "CREATE TABLE orders (id int)" || events[3].Timestamp.Unix() != 1577869201 {
+		t.Errorf("Unexpected query event: %+v", events[3])
+	}
+	if events[6].Table != "orders" || events[6].TableId != 108 {
+		t.Errorf("Unexpected table map event: %+v", events[6])
+	}
+	if events[7].Schema != "shop" || events[7].Table != "orders" || !events[7].Type.IsRowsEvent() {
+		t.Errorf("Unexpected rows event: %+v", events[7])
+	}
+	if events[8].Xid != 42 {
+		t.Errorf("Unexpected xid event: %+v", events[8])
+	}
+	if events[9].NextLogFile != "mysql-bin.000002" || events[9].NextPosition != 4 {
+		t.Errorf("Unexpected rotate event: %+v", events[9])
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].StartPosition != events[i-1].EndPosition {
+			t.Errorf("Event %d does not start where event %d ends", i, i-1)
+		}
+	}
+}
+
+func TestBinlogEventReaderChecksumMismatch(t *testing.T) {
+	binlog := testBinlog()
+	binlog[len(binlog)-5] ^= 0xff
+	reader, _ := NewBinlogEventReader(bytes.NewReader(binlog))
+	for {
+		_, err := reader.ReadEvent()
+		if err == io.EOF {
+			t.Fatalf("Expected checksum mismatch")
+		}
+		if err != nil {
+			break
+		}
+	}
+}
+
+func TestReadBinlogEvents(t *testing.T) {
+	binlog := testBinlog()
+	file, err := ioutil.TempFile("", "orchestrator-agent-test-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	defer os.Remove(file.Name())
+	// A partial trailing event, as with an active binary log, ends the read
+	file.Write(binlog)
+	file.Write(binlog[4:20])
+	file.Close()
+
+	events, err := ReadBinlogEvents(file.Name(), 0, 0, 0)
+	if err != nil || len(events) != 10 {
+		t.Fatalf("Unexpected result: %d events, %+v", len(events), err)
+	}
+	fromGTID := events[4].StartPosition
+	stopAt := events[8].StartPosition
+	events, err = ReadBinlogEvents(file.Name(), fromGTID, stopAt, 0)
+	if err != nil || len(events) != 4 || events[0].GTID != "3e11fa47-71ca-11e1-9e33-c80aa9429562:7" {
+		t.Fatalf("Unexpected result: %+v, %+v", events, err)
+	}
+	events, err = ReadBinlogEvents(file.Name(), 0, 0, 3)
+	if err != nil || len(events) != 3 {
+		t.Fatalf("Unexpected result: %d events, %+v", len(events), err)
+	}
+}
+
+func TestReadBinlogEventsFromRelayLog(t *testing.T) {
+	// A relay log: the replica's own format description event, with checksums, then the master's, without
+	builder := newTestBinlogBuilder()
+	builder.addFormatDescription()
+	builder.addFormatDescriptionOf("5.7.30-log", binlogChecksumAlgOff)
+	builder.addGTID(1577869202, 7)
+	builder.addQuery(1577869202, "shop", "BEGIN")
+	builder.addTableMap(1577869202, 108, "shop", "orders")
+	builder.addWriteRows(1577869202, 108)
+	builder.addXid(1577869202, 42)
+
+	file, err := ioutil.TempFile("", "orchestrator-agent-test-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(builder.buffer.Bytes())
+	file.Close()
+
+	events, err := ReadBinlogEvents(file.Name(), 0, 0, 0)
+	if err != nil || len(events) != 7 {
+		t.Fatalf("Unexpected result: %d events, %+v", len(events), err)
+	}
+	rowsPosition := events[5].StartPosition
+	events, err = ReadBinlogEvents(file.Name(), rowsPosition, 0, 0)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("Unexpected result: %+v, %+v", events, err)
+	}
+	if events[0].Schema != "shop" || events[0].Table != "orders" || !events[0].Type.IsRowsEvent() {
+		t.Errorf("Expected rows event to be resolved by the table map event before the start position: %+v", events[0])
+	}
+	if events[1].Xid != 42 {
+		t.Errorf("Unexpected xid event: %+v", events[1])
+	}
+	if _, err := ReadBinlogEvents(file.Name(), rowsPosition+1, 0, 0); err == nil {
+		t.Errorf("Expected error on start position within an event")
+	}
+}
+
+func TestServerVersionSupportsChecksum(t *testing.T) {
+	tests := map[string]bool{
+		"5.5.40-log":          false,
+		"5.6.0":               false,
+		"5.6.1":               true,
+		"5.7.30-33-log":       true,
+		"8.0.20":              true,
+		"10.3.22-MariaDB-log": true,
+	}
+	for version, expected := range tests {
+		if serverVersionSupportsChecksum(version) != expected {
+			t.Errorf("Unexpected result for %s", version)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+/*
+   Copyright 2015 Shlomi Noach, courtesy Booking.com
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package inst
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// GTIDInterval is an inclusive range of transaction numbers
+type GTIDInterval struct {
+	Start int64
+	End   int64
+}
+
+// GTIDSet is a set of global transaction identifiers, such as @@gtid_executed:
+// per server UUID, a normalized (sorted, non overlapping, non adjacent) list of intervals.
+// The zero value is not usable; use NewGTIDSet or ParseGTIDSet.
+type GTIDSet struct {
+	intervals map[string][]GTIDInterval
+}
+
+// NewGTIDSet returns an empty GTID set
+func NewGTIDSet() *GTIDSet {
+	return &GTIDSet{intervals: make(map[string][]GTIDInterval)}
+}
+
+// ParseGTIDSet parses MySQL's GTID set notation, e.g. "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5:11-18,...".
+// Whitespace and newlines (as in @@gtid_executed output) are ignored. An empty text is an empty set.
+func ParseGTIDSet(gtidSetText string) (*GTIDSet, error) {
+	gtidSet := NewGTIDSet()
+	gtidSetText = strings.Join(strings.Fields(gtidSetText), "")
+	if gtidSetText == "" {
+		return gtidSet, nil
+	}
+	for _, uuidSetText := range strings.Split(gtidSetText, ",") {
+		tokens := strings.Split(uuidSetText, ":")
+		uuid := strings.ToLower(tokens[0])
+		if !uuidPattern.MatchString(uuid) {
+			return nil, fmt.Errorf("ParseGTIDSet: invalid server UUID in %s", uuidSetText)
+		}
+		if len(tokens) < 2 {
+			return nil, fmt.Errorf("ParseGTIDSet: no transaction numbers in %s", uuidSetText)
+		}
+		for _, intervalText := range tokens[1:] {
+			interval, err := parseGTIDInterval(intervalText)
+			if err != nil {
+				return nil, fmt.Errorf("ParseGTIDSet: %s in %s", err.Error(), uuidSetText)
+			}
+			gtidSet.AddInterval(uuid, interval)
+		}
+	}
+	return gtidSet, nil
+}
+
+func parseGTIDInterval(intervalText string) (interval GTIDInterval, err error) {
+	bounds := strings.SplitN(intervalText, "-", 2)
+	if interval.Start, err = strconv.ParseInt(bounds[0], 10, 64); err != nil {
+		return interval, fmt.Errorf("invalid interval %s", intervalText)
+	}
+	interval.End = interval.Start
+	if len(bounds) == 2 {
+		if interval.End, err = strconv.ParseInt(bounds[1], 10, 64); err != nil {
+			return interval, fmt.Errorf("invalid interval %s", intervalText)
+		}
+	}
+	if interval.Start < 1 || interval.End < interval.Start {
+		return interval, fmt.Errorf("invalid interval %s", intervalText)
+	}
+	return interval, nil
+}
+
+// ParseGTID parses a single GTID, e.g. "3e11fa47-71ca-11e1-9e33-c80aa9429562:23", into a set of one
+func ParseGTID(gtid string) (*GTIDSet, error) {
+	gtidSet, err := ParseGTIDSet(gtid)
+	if err != nil {
+		return nil, err
+	}
+	if gtidSet.Count() != 1 {
+		return nil, fmt.Errorf("ParseGTID: not a single GTID: %s", gtid)
+	}
+	return gtidSet, nil
+}
+
+// normalizeGTIDIntervals sorts intervals and merges overlapping or adjacent ones
+func normalizeGTIDIntervals(intervals []GTIDInterval) []GTIDInterval {
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].Start < intervals[j].Start })
+	normalized := []GTIDInterval{}
+	for _, interval := range intervals {
+		if last := len(normalized) - 1; last >= 0 && interval.Start <= normalized[last].End+1 {
+			if interval.End > normalized[last].End {
+				normalized[last].End = interval.End
+			}
+			continue
+		}
+		normalized = append(normalized, interval)
+	}
+	return normalized
+}
+
+// AddInterval adds a range of transactions of given server UUID to this set
+func (this *GTIDSet) AddInterval(uuid string, interval GTIDInterval) {
+	uuid = strings.ToLower(uuid)
+	this.intervals[uuid] = normalizeGTIDIntervals(append(this.intervals[uuid], interval))
+}
+
+// AddGTID adds a single transaction to this set
+func (this *GTIDSet) AddGTID(uuid string, transactionNumber int64) {
+	this.AddInterval(uuid, GTIDInterval{Start: transactionNumber, End: transactionNumber})
+}
+
+// Clone returns a copy of this set
+func (this *GTIDSet) Clone() *GTIDSet {
+	clone := NewGTIDSet()
+	for uuid, intervals := range this.intervals {
+		clone.intervals[uuid] = append([]GTIDInterval{}, intervals...)
+	}
+	return clone
+}
+
+// Union returns a set of all transactions in this set or in the other
+func (this *GTIDSet) Union(other *GTIDSet) *GTIDSet {
+	union := this.Clone()
+	for uuid, intervals := range other.intervals {
+		union.intervals[uuid] = normalizeGTIDIntervals(append(union.intervals[uuid], intervals...))
+	}
+	return union
+}
+
+// Subtract returns a set of the transactions in this set which are not in the other
+func (this *GTIDSet) Subtract(other *GTIDSet) *GTIDSet {
+	difference := NewGTIDSet()
+	for uuid, intervals := range this.intervals {
+		remaining := append([]GTIDInterval{}, intervals...)
+		for _, subtracted := range other.intervals[uuid] {
+			next := []GTIDInterval{}
+			for _, interval := range remaining {
+				if subtracted.End < interval.Start || subtracted.Start > interval.End {
+					next = append(next, interval)
+					continue
+				}
+				if interval.Start < subtracted.Start {
+					next = append(next, GTIDInterval{Start: interval.Start, End: subtracted.Start - 1})
+				}
+				if interval.End > subtracted.End {
+					next = append(next, GTIDInterval{Start: subtracted.End + 1, End: interval.End})
+				}
+			}
+			remaining = next
+		}
+		if len(remaining) > 0 {
+			difference.intervals[uuid] = remaining
+		}
+	}
+	return difference
+}
+
+// Contains returns true when all transactions of the other set are in this set
+func (this *GTIDSet) Contains(other *GTIDSet) bool {
+	return other.Subtract(this).IsEmpty()
+}
+
+// ContainsGTID returns true when the given single GTID is in this set
+func (this *GTIDSet) ContainsGTID(gtid string) (bool, error) {
+	gtidSet, err := ParseGTID(gtid)
+	if err != nil {
+		return false, err
+	}
+	return this.Contains(gtidSet), nil
+}
+
+// Equals returns true when both sets hold the same transactions
+func (this *GTIDSet) Equals(other *GTIDSet) bool {
+	return this.Contains(other) && other.Contains(this)
+}
+
+// IsEmpty returns true when this set holds no transactions
+func (this *GTIDSet) IsEmpty() bool {
+	return len(this.intervals) == 0
+}
+
+// Count returns the number of transactions in this set
+func (this *GTIDSet) Count() int64 {
+	var count int64
+	for _, intervals := range this.intervals {
+		for _, interval := range intervals {
+			count += interval.End - interval.Start + 1
+		}
+	}
+	return count
+}
+
+// String returns MySQL's normalized notation of this set, server UUIDs sorted
+func (this *GTIDSet) String() string {
+	uuids := []string{}
+	for uuid := range this.intervals {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+	uuidSets := []string{}
+	for _, uuid := range uuids {
+		tokens := []string{uuid}
+		for _, interval := range this.intervals[uuid] {
+			if interval.Start == interval.End {
+				tokens = append(tokens, fmt.Sprintf("%d", interval.Start))
+			} else {
+				tokens = append(tokens, fmt.Sprintf("%d-%d", interval.Start, interval.End))
+			}
+		}
+		uuidSets = append(uuidSets, strings.Join(tokens, ":"))
+	}
+	return strings.Join(uuidSets, ",")
+}
+
+// MarshalJSON presents this set in MySQL's notation
+func (this *GTIDSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(this.String())
+}
+
+// UnmarshalJSON parses a set from MySQL's notation
+func (this *GTIDSet) UnmarshalJSON(data []byte) error {
+	var gtidSetText string
+	if err := json.Unmarshal(data, &gtidSetText); err != nil {
+		return err
+	}
+	gtidSet, err := ParseGTIDSet(gtidSetText)
+	if err != nil {
+		return err
+	}
+	*this = *gtidSet
+	return nil
+}
@@ -0,0 +1,80 @@
+package inst
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const (
+	testUUID1 = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	testUUID2 = "a1b2c3d4-0000-11e1-9e33-c80aa9429562"
+)
+
+func TestParseGTIDSet(t *testing.T) {
+	gtidSet, err := ParseGTIDSet(testUUID2 + ":3,\n" + "3E11FA47-71CA-11E1-9E33-C80AA9429562:11-18:1-5:6:20")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if gtidSet.String() != testUUID1+":1-6:11-18:20,"+testUUID2+":3" {
+		t.Errorf("Unexpected normalization: %s", gtidSet.String())
+	}
+	if gtidSet.Count() != 16 {
+		t.Errorf("Unexpected count: %d", gtidSet.Count())
+	}
+	if empty, err := ParseGTIDSet(""); err != nil || !empty.IsEmpty() || empty.String() != "" {
+		t.Errorf("Unexpected empty set: %+v, %+v", empty, err)
+	}
+	for _, invalid := range []string{"nonsense:1", testUUID1, testUUID1 + ":5-3", testUUID1 + ":0", testUUID1 + ":a"} {
+		if _, err := ParseGTIDSet(invalid); err == nil {
+			t.Errorf("Expected error parsing %s", invalid)
+		}
+	}
+}
+
+func TestGTIDSetOperations(t *testing.T) {
+	gtidSet1, _ := ParseGTIDSet(testUUID1 + ":1-10," + testUUID2 + ":1-3")
+	gtidSet2, _ := ParseGTIDSet(testUUID1 + ":4-6:12")
+
+	if union := gtidSet1.Union(gtidSet2); union.String() != testUUID1+":1-10:12,"+testUUID2+":1-3" {
+		t.Errorf("Unexpected union: %s", union.String())
+	}
+	if difference := gtidSet1.Subtract(gtidSet2); difference.String() != testUUID1+":1-3:7-10,"+testUUID2+":1-3" {
+		t.Errorf("Unexpected difference: %s", difference.String())
+	}
+	if difference := gtidSet2.Subtract(gtidSet1); difference.String() != testUUID1+":12" {
+		t.Errorf("Unexpected difference: %s", difference.String())
+	}
+	if !gtidSet1.Subtract(gtidSet1).IsEmpty() {
+		t.Errorf("Expected empty difference")
+	}
+	if gtidSet1.Contains(gtidSet2) {
+		t.Errorf("Unexpected containment")
+	}
+	if !gtidSet1.Union(gtidSet2).Contains(gtidSet2) {
+		t.Errorf("Expected containment")
+	}
+	if contains, _ := gtidSet1.ContainsGTID(testUUID1 + ":7"); !contains {
+		t.Errorf("Expected GTID to be contained")
+	}
+	if contains, _ := gtidSet1.ContainsGTID(testUUID1 + ":11"); contains {
+		t.Errorf("Unexpected GTID containment")
+	}
+	if _, err := gtidSet1.ContainsGTID(testUUID1 + ":1-2"); err == nil {
+		t.Errorf("Expected error on GTID range")
+	}
+	if gtidSet1.String() != testUUID1+":1-10,"+testUUID2+":1-3" {
+		t.Errorf("Operations modified their operand: %s", gtidSet1.String())
+	}
+}
+
+func TestGTIDSetJSON(t *testing.T) {
+	gtidSet, _ := ParseGTIDSet(testUUID1 + ":1-5")
+	data, err := json.Marshal(gtidSet)
+	if err != nil || string(data) != `"`+testUUID1+`:1-5"` {
+		t.Fatalf("Unexpected JSON: %s, %+v", data, err)
+	}
+	decoded := NewGTIDSet()
+	if err := json.Unmarshal(data, decoded); err != nil || !decoded.Equals(gtidSet) {
+		t.Errorf("Unexpected decoded set: %+v, %+v", decoded, err)
+	}
+}
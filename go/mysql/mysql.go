@@ -0,0 +1,146 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package mysql holds the agent's own (optional) connection to the local MySQL server, through which
+// it reads server variables and status rather than running user supplied commands.
+package mysql
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/outbrain/golib/sqlutils"
+	"github.com/outbrain/orchestrator-agent/go/config"
+)
+
+var variableNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// IsConfigured returns true when a connection (socket or hostname) is configured for given MySQL instance
+func IsConfigured(instance *config.MySQLInstanceConfiguration) bool {
+	return instance.MySQLSocket != "" || instance.MySQLHostname != ""
+}
+
+// parseClientCredentials reads user and password from the [client] section of my.cnf formatted content
+func parseClientCredentials(reader io.Reader) (user string, password string, err error) {
+	section := ""
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+		if section != "client" {
+			continue
+		}
+		tokens := strings.SplitN(line, "=", 2)
+		if len(tokens) != 2 {
+			continue
+		}
+		value := strings.Trim(strings.TrimSpace(tokens[1]), `"'`)
+		switch strings.TrimSpace(tokens[0]) {
+		case "user":
+			user = value
+		case "password":
+			password = value
+		}
+	}
+	return user, password, scanner.Err()
+}
+
+// readClientCredentials reads user and password from a my.cnf style credentials file
+func readClientCredentials(credentialsFile string) (user string, password string, err error) {
+	file, err := os.Open(credentialsFile)
+	if err != nil {
+		return user, password, err
+	}
+	defer file.Close()
+	return parseClientCredentials(file)
+}
+
+// getDSN returns the data source name of the connection configured for given MySQL instance
+func getDSN(instance *config.MySQLInstanceConfiguration) (string, error) {
+	dsnConfig := gomysql.NewConfig()
+	if instance.MySQLSocket != "" {
+		dsnConfig.Net = "unix"
+		dsnConfig.Addr = instance.MySQLSocket
+	} else {
+		dsnConfig.Net = "tcp"
+		dsnConfig.Addr = fmt.Sprintf("%s:%d", instance.MySQLHostname, instance.MySQLConnectPort)
+	}
+	if instance.MySQLCredentialsConfigFile != "" {
+		user, password, err := readClientCredentials(instance.MySQLCredentialsConfigFile)
+		if err != nil {
+			return "", err
+		}
+		dsnConfig.User = user
+		dsnConfig.Passwd = password
+	}
+	timeout := time.Duration(config.Config.MySQLConnectTimeoutSeconds) * time.Second
+	dsnConfig.Timeout = timeout
+	dsnConfig.ReadTimeout = 10 * timeout
+	dsnConfig.WriteTimeout = 10 * timeout
+	dsnConfig.InterpolateParams = true
+	return dsnConfig.FormatDSN(), nil
+}
+
+// GetDB returns the connection pool of given MySQL instance
+func GetDB(instance *config.MySQLInstanceConfiguration) (*sql.DB, error) {
+	if !IsConfigured(instance) {
+		return nil, fmt.Errorf("No MySQL connection configured; set MySQLSocket or MySQLHostname")
+	}
+	dsn, err := getDSN(instance)
+	if err != nil {
+		return nil, err
+	}
+	db, fromCache, err := sqlutils.GetDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if !fromCache {
+		db.SetMaxOpenConns(int(config.Config.MySQLMaxConnections))
+		db.SetMaxIdleConns(int(config.Config.MySQLMaxConnections))
+		db.SetConnMaxLifetime(time.Minute)
+	}
+	return db, nil
+}
+
+// GetVariable returns the value of a global server variable of given MySQL instance, e.g. "datadir" for @@global.datadir.
+// A NULL value is returned as an empty string.
+func GetVariable(instance *config.MySQLInstanceConfiguration, name string) (string, error) {
+	if !variableNamePattern.MatchString(name) {
+		return "", fmt.Errorf("Invalid variable name: %s", name)
+	}
+	db, err := GetDB(instance)
+	if err != nil {
+		return "", err
+	}
+	var value sql.NullString
+	if err := db.QueryRow(fmt.Sprintf("select @@global.%s", name)).Scan(&value); err != nil {
+		return "", err
+	}
+	return value.String, nil
+}
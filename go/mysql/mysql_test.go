@@ -0,0 +1,28 @@
+package mysql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseClientCredentials(t *testing.T) {
+	contents := `
+[mysqld]
+user = mysql
+
+[client]
+# agent credentials
+user = orchestrator
+password = "s3cr=t"
+`
+	user, password, err := parseClientCredentials(strings.NewReader(contents))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if user != "orchestrator" {
+		t.Errorf("Expected user orchestrator, got %s", user)
+	}
+	if password != "s3cr=t" {
+		t.Errorf("Expected password s3cr=t, got %s", password)
+	}
+}
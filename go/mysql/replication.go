@@ -0,0 +1,153 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mysql
+
+import (
+	"strconv"
+
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/golib/sqlutils"
+	"github.com/outbrain/orchestrator-agent/go/config"
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+// ReplicationStatus is the replication state of a single channel, as presented by SHOW SLAVE STATUS
+// (SHOW REPLICA STATUS on MySQL 8.0.22 and above)
+type ReplicationStatus struct {
+	ChannelName              string
+	MasterHost               string
+	MasterPort               int
+	MasterUUID               string
+	MasterServerId           int64
+	SlaveIORunning           string
+	SlaveSQLRunning          string
+	SlaveSQLRunningState     string
+	ReadMasterLogCoordinates inst.BinlogCoordinates
+	ExecMasterLogCoordinates inst.BinlogCoordinates
+	RelayLogCoordinates      inst.BinlogCoordinates
+	SecondsBehindMaster      *int64
+	SQLDelay                 int64
+	AutoPosition             bool
+	RetrievedGTIDSet         *inst.GTIDSet
+	ExecutedGTIDSet          *inst.GTIDSet
+	LastErrno                int
+	LastError                string
+	LastIOErrno              int
+	LastIOError              string
+	LastIOErrorTimestamp     string
+	LastSQLErrno             int
+	LastSQLError             string
+	LastSQLErrorTimestamp    string
+}
+
+// rowColumn returns the value of the first of given columns present in the row; SHOW REPLICA STATUS
+// renames the Master/Slave columns of SHOW SLAVE STATUS to Source/Replica
+func rowColumn(m sqlutils.RowMap, columns ...string) sqlutils.CellData {
+	for _, column := range columns {
+		if cell, ok := m[column]; ok {
+			return cell
+		}
+	}
+	return sqlutils.CellData{}
+}
+
+func rowInt64(m sqlutils.RowMap, columns ...string) int64 {
+	value, _ := strconv.ParseInt(rowColumn(m, columns...).String, 10, 64)
+	return value
+}
+
+func rowGTIDSet(m sqlutils.RowMap, column string) *inst.GTIDSet {
+	gtidSet, err := inst.ParseGTIDSet(m.GetString(column))
+	if err != nil {
+		// e.g. MariaDB, whose GTIDs differ in format and are presented by other columns
+		log.Debugf("Cannot parse %s: %+v", column, err)
+		return nil
+	}
+	return gtidSet
+}
+
+// replicationStatusFromRow maps a row of SHOW SLAVE STATUS or SHOW REPLICA STATUS
+func replicationStatusFromRow(m sqlutils.RowMap) *ReplicationStatus {
+	status := &ReplicationStatus{
+		ChannelName:          m.GetString("Channel_Name"),
+		MasterHost:           rowColumn(m, "Master_Host", "Source_Host").String,
+		MasterPort:           int(rowInt64(m, "Master_Port", "Source_Port")),
+		MasterUUID:           rowColumn(m, "Master_UUID", "Source_UUID").String,
+		MasterServerId:       rowInt64(m, "Master_Server_Id", "Source_Server_Id"),
+		SlaveIORunning:       rowColumn(m, "Slave_IO_Running", "Replica_IO_Running").String,
+		SlaveSQLRunning:      rowColumn(m, "Slave_SQL_Running", "Replica_SQL_Running").String,
+		SlaveSQLRunningState: rowColumn(m, "Slave_SQL_Running_State", "Replica_SQL_Running_State").String,
+		ReadMasterLogCoordinates: inst.BinlogCoordinates{
+			LogFile: rowColumn(m, "Master_Log_File", "Source_Log_File").String,
+			LogPos:  rowInt64(m, "Read_Master_Log_Pos", "Read_Source_Log_Pos"),
+			Type:    inst.BinaryLog,
+		},
+		ExecMasterLogCoordinates: inst.BinlogCoordinates{
+			LogFile: rowColumn(m, "Relay_Master_Log_File", "Relay_Source_Log_File").String,
+			LogPos:  rowInt64(m, "Exec_Master_Log_Pos", "Exec_Source_Log_Pos"),
+			Type:    inst.BinaryLog,
+		},
+		RelayLogCoordinates: inst.BinlogCoordinates{
+			LogFile: m.GetString("Relay_Log_File"),
+			LogPos:  m.GetInt64("Relay_Log_Pos"),
+			Type:    inst.RelayLog,
+		},
+		SQLDelay:              m.GetInt64("SQL_Delay"),
+		AutoPosition:          m.GetBool("Auto_Position"),
+		RetrievedGTIDSet:      rowGTIDSet(m, "Retrieved_Gtid_Set"),
+		ExecutedGTIDSet:       rowGTIDSet(m, "Executed_Gtid_Set"),
+		LastErrno:             m.GetInt("Last_Errno"),
+		LastError:             m.GetString("Last_Error"),
+		LastIOErrno:           m.GetInt("Last_IO_Errno"),
+		LastIOError:           m.GetString("Last_IO_Error"),
+		LastIOErrorTimestamp:  m.GetString("Last_IO_Error_Timestamp"),
+		LastSQLErrno:          m.GetInt("Last_SQL_Errno"),
+		LastSQLError:          m.GetString("Last_SQL_Error"),
+		LastSQLErrorTimestamp: m.GetString("Last_SQL_Error_Timestamp"),
+	}
+	if secondsBehind := rowColumn(m, "Seconds_Behind_Master", "Seconds_Behind_Source"); secondsBehind.Valid {
+		if seconds, err := strconv.ParseInt(secondsBehind.String, 10, 64); err == nil {
+			status.SecondsBehindMaster = &seconds
+		}
+	}
+	return status
+}
+
+// GetReplicationStatus returns the replication status of each replication channel of given MySQL instance;
+// it is empty when the instance is not a replica
+func GetReplicationStatus(instance *config.MySQLInstanceConfiguration) ([]ReplicationStatus, error) {
+	db, err := GetDB(instance)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("show replica status")
+	if err != nil {
+		// Prior to MySQL 8.0.22
+		rows, err = db.Query("show slave status")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := []ReplicationStatus{}
+	err = sqlutils.ScanRowsToMaps(rows, func(m sqlutils.RowMap) error {
+		statuses = append(statuses, *replicationStatusFromRow(m))
+		return nil
+	})
+	return statuses, err
+}
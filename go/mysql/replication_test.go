@@ -0,0 +1,70 @@
+package mysql
+
+import (
+	"testing"
+
+	"github.com/outbrain/golib/sqlutils"
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+func testRowMap(values map[string]string, nulls ...string) sqlutils.RowMap {
+	m := sqlutils.RowMap{}
+	for column, value := range values {
+		m[column] = sqlutils.CellData{String: value, Valid: true}
+	}
+	for _, column := range nulls {
+		m[column] = sqlutils.CellData{}
+	}
+	return m
+}
+
+func TestReplicationStatusFromRow(t *testing.T) {
+	for _, m := range []sqlutils.RowMap{
+		testRowMap(map[string]string{
+			"Master_Host": "db1", "Master_Port": "3306", "Slave_IO_Running": "Yes", "Slave_SQL_Running": "No",
+			"Master_Log_File": "mysql-bin.000012", "Read_Master_Log_Pos": "1200",
+			"Relay_Master_Log_File": "mysql-bin.000011", "Exec_Master_Log_Pos": "800",
+			"Relay_Log_File": "relay-bin.000003", "Relay_Log_Pos": "400",
+			"Seconds_Behind_Master": "7", "Auto_Position": "1",
+			"Executed_Gtid_Set": "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5,\n3e11fa47-71ca-11e1-9e33-c80aa9429563:1-2",
+			"Last_SQL_Errno":    "1062", "Last_SQL_Error": "Duplicate entry",
+		}),
+		testRowMap(map[string]string{
+			"Source_Host": "db1", "Source_Port": "3306", "Replica_IO_Running": "Yes", "Replica_SQL_Running": "No",
+			"Source_Log_File": "mysql-bin.000012", "Read_Source_Log_Pos": "1200",
+			"Relay_Source_Log_File": "mysql-bin.000011", "Exec_Source_Log_Pos": "800",
+			"Relay_Log_File": "relay-bin.000003", "Relay_Log_Pos": "400",
+			"Seconds_Behind_Source": "7", "Auto_Position": "1",
+			"Executed_Gtid_Set": "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5,\n3e11fa47-71ca-11e1-9e33-c80aa9429563:1-2",
+			"Last_SQL_Errno":    "1062", "Last_SQL_Error": "Duplicate entry",
+		}),
+	} {
+		status := replicationStatusFromRow(m)
+		if status.MasterHost != "db1" || status.MasterPort != 3306 || status.SlaveIORunning != "Yes" || status.SlaveSQLRunning != "No" {
+			t.Errorf("Unexpected master/threads: %+v", status)
+		}
+		if expected := (inst.BinlogCoordinates{LogFile: "mysql-bin.000012", LogPos: 1200}); !status.ReadMasterLogCoordinates.Equals(&expected) {
+			t.Errorf("Unexpected read coordinates: %+v", status.ReadMasterLogCoordinates)
+		}
+		if expected := (inst.BinlogCoordinates{LogFile: "mysql-bin.000011", LogPos: 800}); !status.ExecMasterLogCoordinates.Equals(&expected) {
+			t.Errorf("Unexpected exec coordinates: %+v", status.ExecMasterLogCoordinates)
+		}
+		if expected := (inst.BinlogCoordinates{LogFile: "relay-bin.000003", LogPos: 400, Type: inst.RelayLog}); !status.RelayLogCoordinates.Equals(&expected) {
+			t.Errorf("Unexpected relay log coordinates: %+v", status.RelayLogCoordinates)
+		}
+		if status.SecondsBehindMaster == nil || *status.SecondsBehindMaster != 7 {
+			t.Errorf("Unexpected seconds behind master: %+v", status.SecondsBehindMaster)
+		}
+		if !status.AutoPosition || status.ExecutedGTIDSet == nil || status.ExecutedGTIDSet.Count() != 7 {
+			t.Errorf("Unexpected GTID info: %+v", status)
+		}
+		if status.LastSQLErrno != 1062 || status.LastSQLError != "Duplicate entry" {
+			t.Errorf("Unexpected SQL error: %+v", status)
+		}
+	}
+
+	status := replicationStatusFromRow(testRowMap(map[string]string{"Slave_IO_Running": "Connecting"}, "Seconds_Behind_Master"))
+	if status.SecondsBehindMaster != nil {
+		t.Errorf("Expected NULL seconds behind master, got %d", *status.SecondsBehindMaster)
+	}
+}
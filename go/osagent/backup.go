@@ -0,0 +1,581 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/orchestrator-agent/go/config"
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+const (
+	backupArchiveSuffix  = ".tar.gz"
+	backupManifestSuffix = ".manifest.json"
+)
+
+// BackupManifest describes a backup archive: its source snapshot, content and checksum, and the result of
+// the latest checksum verification. It is stored as a JSON file next to the archive.
+type BackupManifest struct {
+	Name                 string
+	ArchiveFile          string
+	ArchiveSize          int64
+	Checksum             string
+	ChecksumAlgorithm    string
+	SourceLVPath         string
+	SourceGroup          string
+	SourceOrigin         string
+	SourceTags           []string
+	SnapshotCreationTime time.Time
+	BinlogCoordinates    *inst.BinlogCoordinates
+	DataFiles            int64
+	DataBytes            int64
+	StartTime            time.Time
+	EndTime              time.Time
+	ChecksumStatus       string
+	ChecksumVerifiedAt   time.Time
+}
+
+// countingWriter counts bytes written through it
+type countingWriter struct {
+	count int64
+}
+
+func (this *countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(&this.count, int64(len(p)))
+	return len(p), nil
+}
+
+func (this *countingWriter) Count() int64 {
+	return atomic.LoadInt64(&this.count)
+}
+
+// heuristicBinlogEndCoordinates guesses the binary log coordinates of MySQL data at given path, as the end of the
+// last binary log listed in its index file. Returns nil if no binary logs are found.
+func heuristicBinlogEndCoordinates(dataPath string) *inst.BinlogCoordinates {
+	indexFiles, _ := filepath.Glob(path.Join(dataPath, "*.index"))
+	for _, indexFile := range indexFiles {
+		if strings.Contains(path.Base(indexFile), "relay") {
+			continue
+		}
+		contents, err := ioutil.ReadFile(indexFile)
+		if err != nil {
+			continue
+		}
+		lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+		lastLogFile := path.Base(strings.TrimSpace(lines[len(lines)-1]))
+		if lastLogFile == "." {
+			continue
+		}
+		if fileInfo, err := os.Stat(path.Join(dataPath, lastLogFile)); err == nil {
+			return &inst.BinlogCoordinates{LogFile: lastLogFile, LogPos: fileInfo.Size(), Type: inst.BinaryLog}
+		}
+	}
+	return nil
+}
+
+// readBackupManifest reads a manifest file
+func readBackupManifest(manifestFile string) (*BackupManifest, error) {
+	contents, err := ioutil.ReadFile(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &BackupManifest{}
+	if err := json.Unmarshal(contents, manifest); err != nil {
+		return nil, fmt.Errorf("Cannot parse backup manifest %s: %+v", manifestFile, err)
+	}
+	return manifest, nil
+}
+
+// writeBackupManifest writes a manifest next to its archive. The manifest is replaced atomically.
+func writeBackupManifest(manifest *BackupManifest) error {
+	contents, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifestFile := path.Join(config.Config.BackupDirectory, manifest.Name+backupManifestSuffix)
+	if err := ioutil.WriteFile(manifestFile+".tmp", contents, 0644); err != nil {
+		return err
+	}
+	return os.Rename(manifestFile+".tmp", manifestFile)
+}
+
+// BackupArchives lists the archives in BackupDirectory, by their manifests, oldest first
+func BackupArchives() ([]BackupManifest, error) {
+	if config.Config.BackupDirectory == "" {
+		return nil, errors.New("BackupDirectory is not configured")
+	}
+	manifestFiles, err := filepath.Glob(path.Join(config.Config.BackupDirectory, "*"+backupManifestSuffix))
+	if err != nil {
+		return nil, err
+	}
+	manifests := []BackupManifest{}
+	for _, manifestFile := range manifestFiles {
+		manifest, err := readBackupManifest(manifestFile)
+		if err != nil {
+			log.Errore(err)
+			continue
+		}
+		manifests = append(manifests, *manifest)
+	}
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].StartTime.Before(manifests[j].StartTime) })
+	return manifests, nil
+}
+
+// writeBackupArchive streams a tar of given directory through gzip into the archive file, computing its checksum.
+// Progress is reported by comparing tar stream bytes with expected data size.
+func writeBackupArchive(job *Job, manifest *BackupManifest, dataPath string) error {
+	archivePath := path.Join(config.Config.BackupDirectory, manifest.ArchiveFile)
+	tmpArchivePath := archivePath + ".tmp"
+	archiveFile, err := os.Create(tmpArchivePath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpArchivePath)
+	defer archiveFile.Close()
+
+	cmd, tmpFileName, err := execCmd(sudoCmd(fmt.Sprintf("tar -C %s -cf - .", dataPath)))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFileName)
+	tarStream, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	hasher := sha256.New()
+	archiveCounter := &countingWriter{}
+	gzipWriter := gzip.NewWriter(io.MultiWriter(archiveFile, hasher, archiveCounter))
+	tarCounter := &countingWriter{}
+
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(restoreProgressInterval):
+			}
+			if manifest.DataBytes > 0 {
+				progress := 100 * float64(tarCounter.Count()) / float64(manifest.DataBytes)
+				if progress > 99 {
+					progress = 99
+				}
+				job.SetProgress(progress)
+			}
+		}
+	}()
+
+	if _, err := io.Copy(io.MultiWriter(gzipWriter, tarCounter), tarStream); err != nil {
+		// Nobody reads tar's output anymore; closing the pipe makes it exit rather than block Wait forever
+		tarStream.Close()
+		cmd.Wait()
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("tar failed: %+v", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	if err := archiveFile.Sync(); err != nil {
+		return err
+	}
+	manifest.ArchiveSize = archiveCounter.Count()
+	manifest.Checksum = hex.EncodeToString(hasher.Sum(nil))
+	manifest.ChecksumAlgorithm = "sha256"
+	return os.Rename(tmpArchivePath, archivePath)
+}
+
+// ArchiveSnapshot turns a snapshot into a compressed, checksummed tar archive in BackupDirectory, along with
+// a JSON manifest. The snapshot is mounted read-only on SnapshotMountPoint for the duration of the archive,
+// unless it is already mounted there. The archive runs as a job.
+func ArchiveSnapshot(snapshotName string) (*Job, error) {
+	if config.Config.BackupDirectory == "" {
+		return nil, errors.New("BackupDirectory is not configured")
+	}
+	if snapshotName == "" {
+		return nil, errors.New("Empty snapshot name in ArchiveSnapshot")
+	}
+	logicalVolumes, err := LogicalVolumes(snapshotName, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(logicalVolumes) == 0 || !logicalVolumes[0].IsSnapshot {
+		return nil, fmt.Errorf("Not a snapshot: %s", snapshotName)
+	}
+	snapshot := logicalVolumes[0]
+	if !snapshot.IsSnapshotValid() {
+		return nil, fmt.Errorf("Snapshot %s is invalid", snapshot.Path)
+	}
+	mountPoint := config.Config.SnapshotMountPoint
+	mount, err := FindMount(mountPoint)
+	if err != nil {
+		return nil, err
+	}
+	alreadyMounted := (mount != nil && mount.LVPath == snapshot.Path)
+	if mount != nil && !alreadyMounted {
+		return nil, fmt.Errorf("%s is mounted with another volume; unmount before archiving", mountPoint)
+	}
+	if JobInProgress("archive") {
+		return nil, errors.New("A snapshot archive is already in progress")
+	}
+	if err := os.MkdirAll(config.Config.BackupDirectory, 0755); err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("%s-%s", snapshot.Name, time.Now().Format("20060102150405"))
+	manifest := &BackupManifest{
+		Name:                 name,
+		ArchiveFile:          name + backupArchiveSuffix,
+		SourceLVPath:         snapshot.Path,
+		SourceGroup:          snapshot.GroupName,
+		SourceOrigin:         snapshot.Origin,
+		SourceTags:           snapshot.Tags,
+		SnapshotCreationTime: snapshot.CreationTime,
+		StartTime:            time.Now(),
+	}
+	job := NewJob("archive", snapshot.Path)
+	job.Run(func(job *Job) error {
+		defer func() { job.SetDetails(*manifest) }()
+
+		job.SetPhase("mount")
+		if !alreadyMounted {
+			if _, err := MountLV(mountPoint, snapshot.Path, true, false, false); err != nil {
+				return err
+			}
+			defer func() {
+				if _, err := Unmount(mountPoint); err != nil {
+					log.Errorf("Cannot unmount %s after archive: %+v", mountPoint, err)
+				}
+			}()
+		}
+		dataPath, err := HeuristicMySQLDataPath(mountPoint)
+		if err != nil {
+			return err
+		}
+		manifest.BinlogCoordinates = heuristicBinlogEndCoordinates(dataPath)
+
+		job.SetPhase("inspect")
+		if manifest.DataFiles, manifest.DataBytes, err = DirectoryFileStats(dataPath); err != nil {
+			return err
+		}
+		job.SetDetails(*manifest)
+
+		job.SetPhase("archive")
+		if err := writeBackupArchive(job, manifest, dataPath); err != nil {
+			return err
+		}
+		manifest.EndTime = time.Now()
+
+		job.SetPhase("manifest")
+		if err := writeBackupManifest(manifest); err != nil {
+			return err
+		}
+		job.SetPhase("done")
+		return nil
+	})
+	return job, nil
+}
+
+// Checksum status of backup archives, as known to the catalog
+const (
+	BackupChecksumUnverified = "unverified"
+	BackupChecksumValid      = "valid"
+	BackupChecksumInvalid    = "invalid"
+	BackupChecksumMissing    = "missing"
+)
+
+// BackupCatalogEntry describes a backup archive along with its checksum status: the result of its latest
+// checksum verification, unverified, or missing when the archive file is gone
+type BackupCatalogEntry struct {
+	BackupManifest
+}
+
+// BackupRestoreDetails describes a restore of a backup archive into the MySQL datadir
+type BackupRestoreDetails struct {
+	SeedId           string
+	BackupName       string
+	DataDir          string
+	AvailableBytes   int64
+	ComputedChecksum string
+	RestoredFiles    int64
+	RestoredBytes    int64
+	PostCopyPassed   bool
+}
+
+var backupManifestMutex = &sync.Mutex{}
+
+// recordBackupChecksumStatus stores the result of a checksum verification in the backup's manifest
+func recordBackupChecksumStatus(name string, status string) error {
+	backupManifestMutex.Lock()
+	defer backupManifestMutex.Unlock()
+	manifest, err := readBackupManifest(path.Join(config.Config.BackupDirectory, name+backupManifestSuffix))
+	if err != nil {
+		return err
+	}
+	manifest.ChecksumStatus = status
+	manifest.ChecksumVerifiedAt = time.Now()
+	return writeBackupManifest(manifest)
+}
+
+// catalogEntry returns the catalog entry for given manifest
+func catalogEntry(manifest BackupManifest) BackupCatalogEntry {
+	entry := BackupCatalogEntry{BackupManifest: manifest}
+	if _, err := os.Stat(path.Join(config.Config.BackupDirectory, manifest.ArchiveFile)); err != nil {
+		entry.ChecksumStatus = BackupChecksumMissing
+		return entry
+	}
+	if entry.ChecksumStatus == "" {
+		entry.ChecksumStatus = BackupChecksumUnverified
+	}
+	return entry
+}
+
+// BackupCatalog lists the archives in BackupDirectory along with their checksum status, oldest first
+func BackupCatalog() ([]BackupCatalogEntry, error) {
+	manifests, err := BackupArchives()
+	if err != nil {
+		return nil, err
+	}
+	catalog := []BackupCatalogEntry{}
+	for _, manifest := range manifests {
+		catalog = append(catalog, catalogEntry(manifest))
+	}
+	return catalog, nil
+}
+
+// GetBackup returns the catalog entry of a single archive, by name
+func GetBackup(name string) (*BackupCatalogEntry, error) {
+	if config.Config.BackupDirectory == "" {
+		return nil, errors.New("BackupDirectory is not configured")
+	}
+	if name == "" || path.Base(name) != name {
+		return nil, fmt.Errorf("Invalid backup name: %s", name)
+	}
+	manifest, err := readBackupManifest(path.Join(config.Config.BackupDirectory, name+backupManifestSuffix))
+	if err != nil {
+		return nil, err
+	}
+	entry := catalogEntry(*manifest)
+	return &entry, nil
+}
+
+// countingReader counts bytes read through it
+type countingReader struct {
+	reader io.Reader
+	countingWriter
+}
+
+func (this *countingReader) Read(p []byte) (int, error) {
+	n, err := this.reader.Read(p)
+	this.Write(p[:n])
+	return n, err
+}
+
+// checksumAndRecord compares a computed checksum with the manifest's and records the result in the manifest
+func checksumAndRecord(entry *BackupCatalogEntry, checksum string) error {
+	status := BackupChecksumValid
+	if checksum != entry.Checksum {
+		status = BackupChecksumInvalid
+	}
+	if err := recordBackupChecksumStatus(entry.Name, status); err != nil {
+		log.Errorf("Cannot record checksum status of %s: %+v", entry.Name, err)
+	}
+	if status == BackupChecksumInvalid {
+		return fmt.Errorf("Checksum mismatch on %s: expected %s, got %s", entry.ArchiveFile, entry.Checksum, checksum)
+	}
+	return nil
+}
+
+// monitorReadProgress reports progress of reading an archive, until done is closed
+func monitorReadProgress(job *Job, reader *countingReader, totalBytes int64, done chan bool) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(restoreProgressInterval):
+		}
+		if totalBytes > 0 {
+			progress := 100 * float64(reader.Count()) / float64(totalBytes)
+			if progress > 99 {
+				progress = 99
+			}
+			job.SetProgress(progress)
+		}
+	}
+}
+
+// VerifyBackup recomputes the checksum of a backup archive and compares it with its manifest.
+// The verification runs as a job; its result is stored in the manifest.
+func VerifyBackup(name string) (*Job, error) {
+	entry, err := GetBackup(name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.ChecksumStatus == BackupChecksumMissing {
+		return nil, fmt.Errorf("Archive file %s is missing", entry.ArchiveFile)
+	}
+	job := NewJob("verify-backup", entry.Name)
+	job.Run(func(job *Job) error {
+		archiveFile, err := os.Open(path.Join(config.Config.BackupDirectory, entry.ArchiveFile))
+		if err != nil {
+			return err
+		}
+		defer archiveFile.Close()
+
+		job.SetPhase("checksum")
+		reader := &countingReader{reader: archiveFile}
+		done := make(chan bool)
+		go monitorReadProgress(job, reader, entry.ArchiveSize, done)
+		hasher := sha256.New()
+		_, err = io.Copy(hasher, reader)
+		close(done)
+		if err != nil {
+			return err
+		}
+		if err := checksumAndRecord(entry, hex.EncodeToString(hasher.Sum(nil))); err != nil {
+			return err
+		}
+		job.SetPhase("done")
+		return nil
+	})
+	return job, nil
+}
+
+// RestoreBackup extracts a backup archive into the MySQL datadir. MySQL must not be running, the datadir must
+// be empty and have enough free space for the archived data. The archive checksum is computed while
+// extracting, then the restored files are compared with the manifest, and post-copy runs.
+// The extraction command is tracked by seedId, like seeds; the complete restore runs as a job.
+func (this *MySQLInstance) RestoreBackup(name string, seedId string) (*Job, error) {
+	if seedId == "" {
+		return nil, errors.New("Empty seedId in RestoreBackup")
+	}
+	entry, err := GetBackup(name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.ChecksumStatus == BackupChecksumMissing {
+		return nil, fmt.Errorf("Archive file %s is missing", entry.ArchiveFile)
+	}
+	if entry.ChecksumStatus == BackupChecksumInvalid {
+		return nil, fmt.Errorf("Archive %s failed checksum verification; refusing to restore", entry.ArchiveFile)
+	}
+	if running, _ := this.MySQLRunning(); running {
+		return nil, errors.New("MySQL is running; refusing to restore")
+	}
+	directory, err := this.GetMySQLDataDir()
+	if err != nil {
+		return nil, err
+	}
+	if empty, err := isEmptyDirectory(directory); err != nil {
+		return nil, err
+	} else if !empty {
+		return nil, fmt.Errorf("MySQL datadir %s is not empty; refusing to restore", directory)
+	}
+	availableBytes, err := this.GetMySQLDataDirAvailableDiskSpace()
+	if err != nil {
+		return nil, err
+	}
+	if availableBytes < entry.DataBytes {
+		return nil, fmt.Errorf("Not enough free space on %s: %d bytes available, %d bytes required", directory, availableBytes, entry.DataBytes)
+	}
+
+	details := &BackupRestoreDetails{
+		SeedId:         seedId,
+		BackupName:     entry.Name,
+		DataDir:        directory,
+		AvailableBytes: availableBytes,
+	}
+	job := NewJob("restore-backup", entry.Name)
+	job.Run(func(job *Job) error {
+		defer func() { job.SetDetails(*details) }()
+		job.SetDetails(*details)
+
+		archiveFile, err := os.Open(path.Join(config.Config.BackupDirectory, entry.ArchiveFile))
+		if err != nil {
+			return err
+		}
+		defer archiveFile.Close()
+
+		job.SetPhase("extract")
+		hasher := sha256.New()
+		reader := &countingReader{reader: io.TeeReader(archiveFile, hasher)}
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		done := make(chan bool)
+		go monitorReadProgress(job, reader, entry.ArchiveSize, done)
+		err = commandRun(sudoCmd(fmt.Sprintf("tar -C %s -xf -", details.DataDir)), func(cmd *exec.Cmd) {
+			cmd.Stdin = gzipReader
+			setActiveCommand(seedId, cmd)
+		})
+		close(done)
+		if err != nil {
+			return err
+		}
+
+		job.SetPhase("verify")
+		// tar may stop reading before the end of the archive; the remainder still counts for the checksum
+		if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+			return err
+		}
+		details.ComputedChecksum = hex.EncodeToString(hasher.Sum(nil))
+		if err := checksumAndRecord(entry, details.ComputedChecksum); err != nil {
+			return err
+		}
+		if details.RestoredFiles, details.RestoredBytes, err = DirectoryFileStats(details.DataDir); err != nil {
+			return err
+		}
+		if details.RestoredFiles != entry.DataFiles || details.RestoredBytes != entry.DataBytes {
+			return fmt.Errorf("Restored data mismatch: archive has %d files, %d bytes; datadir has %d files, %d bytes",
+				entry.DataFiles, entry.DataBytes, details.RestoredFiles, details.RestoredBytes)
+		}
+
+		job.SetPhase("post-copy")
+		if err := this.PostCopy(); err != nil {
+			return err
+		}
+		details.PostCopyPassed = true
+		job.SetPhase("done")
+		return nil
+	})
+	return job, nil
+}
@@ -0,0 +1,48 @@
+package osagent
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/outbrain/orchestrator-agent/go/config"
+)
+
+func TestBackupChecksumStatus(t *testing.T) {
+	defer func(directory string) { config.Config.BackupDirectory = directory }(config.Config.BackupDirectory)
+	backupDirectory, err := ioutil.TempDir("", "orchestrator-agent-test-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(backupDirectory)
+	config.Config.BackupDirectory = backupDirectory
+
+	manifest := &BackupManifest{Name: "daily", ArchiveFile: "daily" + backupArchiveSuffix, Checksum: "abc"}
+	if err := writeBackupManifest(manifest); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if entry, err := GetBackup("daily"); err != nil || entry.ChecksumStatus != BackupChecksumMissing {
+		t.Errorf("Expected missing archive: %+v, %+v", entry, err)
+	}
+	ioutil.WriteFile(path.Join(backupDirectory, manifest.ArchiveFile), []byte("archive"), 0644)
+	entry, err := GetBackup("daily")
+	if err != nil || entry.ChecksumStatus != BackupChecksumUnverified {
+		t.Fatalf("Expected unverified archive: %+v, %+v", entry, err)
+	}
+
+	if err := checksumAndRecord(entry, "def"); err == nil {
+		t.Errorf("Expected error on checksum mismatch")
+	}
+	if entry, _ := GetBackup("daily"); entry.ChecksumStatus != BackupChecksumInvalid || entry.ChecksumVerifiedAt.IsZero() {
+		t.Errorf("Expected invalid archive: %+v", entry)
+	}
+	if err := checksumAndRecord(entry, "abc"); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+	// The result survives in the manifest on disk
+	stored, err := readBackupManifest(path.Join(backupDirectory, "daily"+backupManifestSuffix))
+	if err != nil || stored.ChecksumStatus != BackupChecksumValid || stored.Checksum != "abc" {
+		t.Errorf("Unexpected stored manifest: %+v, %+v", stored, err)
+	}
+}
@@ -0,0 +1,260 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/orchestrator-agent/go/config"
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+const archivedBinlogMetadataSuffix = ".json"
+
+var mysqlbinlogEventTimePattern = regexp.MustCompile(`^#([0-9]{6})[ ]+([0-9]{1,2}:[0-9]{2}:[0-9]{2}) server id`)
+
+// ArchivedBinlog describes a binary log copied into BinlogArchiveDirectory.
+// It is stored as a JSON file next to the archived binary log.
+type ArchivedBinlog struct {
+	LogFile          string
+	SourcePath       string
+	Size             int64
+	Checksum         string
+	FirstEventTime   time.Time
+	LastEventTime    time.Time
+	StartCoordinates inst.BinlogCoordinates
+	EndCoordinates   inst.BinlogCoordinates
+	ArchivedAt       time.Time
+}
+
+// parseMySQLBinlogEventTime parses the timestamp of an event header line in mysqlbinlog output,
+// e.g. "#200101  9:00:00 server id 1  end_log_pos 125 ..."
+func parseMySQLBinlogEventTime(line string) (time.Time, error) {
+	submatch := mysqlbinlogEventTimePattern.FindStringSubmatch(line)
+	if submatch == nil {
+		return time.Time{}, fmt.Errorf("Not a mysqlbinlog event header: %s", line)
+	}
+	clock := submatch[2]
+	if len(clock) == len("9:00:00") {
+		clock = "0" + clock
+	}
+	// mysqlbinlog prints event times in the local time zone
+	return time.ParseInLocation("060102 15:04:05", fmt.Sprintf("%s %s", submatch[1], clock), time.Local)
+}
+
+// binlogEventTimeRange returns the timestamps of the first and last events in given binary log
+func binlogEventTimeRange(binlogPath string) (first time.Time, last time.Time, err error) {
+	output, err := commandOutput(sudoCmd(fmt.Sprintf(`mysqlbinlog %s | grep -E '^#[0-9]{6} +[0-9]+:[0-9]+:[0-9]+ server id' | sed -n '1p;$p'`, binlogPath)))
+	lines, err := outputLines(output, err)
+	if err != nil {
+		return first, last, err
+	}
+	if first, err = parseMySQLBinlogEventTime(lines[0]); err != nil {
+		return first, last, err
+	}
+	if last, err = parseMySQLBinlogEventTime(lines[len(lines)-1]); err != nil {
+		return first, last, err
+	}
+	return first, last, nil
+}
+
+// fileChecksum returns the sha256 checksum of a file
+func fileChecksum(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// archiveBinlog copies a single binary log into BinlogArchiveDirectory, verifies the copy's checksum
+// and writes its metadata
+func archiveBinlog(binlogPath string) (*ArchivedBinlog, error) {
+	logFile := path.Base(binlogPath)
+	archivePath := path.Join(config.Config.BinlogArchiveDirectory, logFile)
+	tmpArchivePath := archivePath + ".tmp"
+	archiveFile, err := os.Create(tmpArchivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpArchivePath)
+	defer archiveFile.Close()
+
+	cmd, tmpFileName, err := execCmd(sudoCmd(fmt.Sprintf("cat %s", binlogPath)))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpFileName)
+	source, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(archiveFile, hasher), source)
+	if err != nil {
+		// Nobody reads cat's output anymore; closing the pipe makes it exit rather than block Wait forever
+		source.Close()
+		cmd.Wait()
+		return nil, err
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("Cannot read %s: %+v", binlogPath, err)
+	}
+	if err := archiveFile.Sync(); err != nil {
+		return nil, err
+	}
+	archivedBinlog := &ArchivedBinlog{
+		LogFile:          logFile,
+		SourcePath:       binlogPath,
+		Size:             size,
+		Checksum:         hex.EncodeToString(hasher.Sum(nil)),
+		StartCoordinates: inst.BinlogCoordinates{LogFile: logFile, LogPos: 4, Type: inst.BinaryLog},
+		EndCoordinates:   inst.BinlogCoordinates{LogFile: logFile, LogPos: size, Type: inst.BinaryLog},
+	}
+	if checksum, err := fileChecksum(tmpArchivePath); err != nil {
+		return nil, err
+	} else if checksum != archivedBinlog.Checksum {
+		return nil, fmt.Errorf("Checksum mismatch on archived copy of %s: expected %s, got %s", binlogPath, archivedBinlog.Checksum, checksum)
+	}
+	if archivedBinlog.FirstEventTime, archivedBinlog.LastEventTime, err = binlogEventTimeRange(tmpArchivePath); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpArchivePath, archivePath); err != nil {
+		return nil, err
+	}
+	archivedBinlog.ArchivedAt = time.Now()
+
+	contents, err := json.MarshalIndent(archivedBinlog, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(archivePath+archivedBinlogMetadataSuffix, contents, 0644); err != nil {
+		return nil, err
+	}
+	log.Infof("Archived binary log %s: %d bytes, sha256 %s", binlogPath, size, archivedBinlog.Checksum)
+	return archivedBinlog, nil
+}
+
+// ArchivedBinlogs lists the binary logs in BinlogArchiveDirectory, by their metadata, ordered by file
+func ArchivedBinlogs() ([]ArchivedBinlog, error) {
+	if config.Config.BinlogArchiveDirectory == "" {
+		return nil, errors.New("BinlogArchiveDirectory is not configured")
+	}
+	metadataFiles, err := filepath.Glob(path.Join(config.Config.BinlogArchiveDirectory, "*"+archivedBinlogMetadataSuffix))
+	if err != nil {
+		return nil, err
+	}
+	archivedBinlogs := []ArchivedBinlog{}
+	for _, metadataFile := range metadataFiles {
+		contents, err := ioutil.ReadFile(metadataFile)
+		if err != nil {
+			log.Errore(err)
+			continue
+		}
+		archivedBinlog := ArchivedBinlog{}
+		if err := json.Unmarshal(contents, &archivedBinlog); err != nil {
+			log.Errorf("Cannot parse archived binary log metadata %s: %+v", metadataFile, err)
+			continue
+		}
+		archivedBinlogs = append(archivedBinlogs, archivedBinlog)
+	}
+	sort.Slice(archivedBinlogs, func(i, j int) bool {
+		return archivedBinlogs[i].StartCoordinates.SmallerThan(&archivedBinlogs[j].StartCoordinates)
+	})
+	return archivedBinlogs, nil
+}
+
+// ArchiveRotatedBinlogs copies binary logs which MySQL has rotated away from, and which are not yet archived,
+// into BinlogArchiveDirectory. The active (last) binary log in the index is never archived.
+func ArchiveRotatedBinlogs() (archived []ArchivedBinlog, err error) {
+	if config.Config.BinlogArchiveDirectory == "" {
+		return archived, errors.New("BinlogArchiveDirectory is not configured")
+	}
+	if err := os.MkdirAll(config.Config.BinlogArchiveDirectory, 0755); err != nil {
+		return archived, err
+	}
+	binlogPaths, err := GetBinlogFileNames()
+	if err != nil {
+		return archived, err
+	}
+	if len(binlogPaths) < 2 {
+		return archived, nil
+	}
+	for _, binlogPath := range binlogPaths[:len(binlogPaths)-1] {
+		metadataFile := path.Join(config.Config.BinlogArchiveDirectory, path.Base(binlogPath)+archivedBinlogMetadataSuffix)
+		if _, err := os.Stat(metadataFile); err == nil {
+			continue
+		}
+		archivedBinlog, err := archiveBinlog(binlogPath)
+		if err != nil {
+			return archived, err
+		}
+		archived = append(archived, *archivedBinlog)
+	}
+	return archived, nil
+}
+
+// PurgeArchivedBinlogs removes archived binary logs whose last event is older than given retention.
+// The most recent archived binary log is always kept.
+func PurgeArchivedBinlogs(retention time.Duration) (purged []string, err error) {
+	archivedBinlogs, err := ArchivedBinlogs()
+	if err != nil {
+		return purged, err
+	}
+	if len(archivedBinlogs) < 2 {
+		return purged, nil
+	}
+	for _, archivedBinlog := range archivedBinlogs[:len(archivedBinlogs)-1] {
+		if time.Since(archivedBinlog.LastEventTime) < retention {
+			continue
+		}
+		archivePath := path.Join(config.Config.BinlogArchiveDirectory, archivedBinlog.LogFile)
+		if err := os.Remove(archivePath); err != nil && !os.IsNotExist(err) {
+			return purged, err
+		}
+		if err := os.Remove(archivePath + archivedBinlogMetadataSuffix); err != nil {
+			return purged, err
+		}
+		purged = append(purged, archivedBinlog.LogFile)
+	}
+	if len(purged) > 0 {
+		log.Infof("Purged archived binary logs: %s", strings.Join(purged, ", "))
+	}
+	return purged, nil
+}
@@ -0,0 +1,18 @@
+package osagent
+
+import (
+	"testing"
+)
+
+func TestParseMySQLBinlogEventTime(t *testing.T) {
+	eventTime, err := parseMySQLBinlogEventTime("#200101  9:05:07 server id 1  end_log_pos 125 CRC32 0x1a2b3c4d 	Start: binlog v 4")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if eventTime.Format("2006-01-02 15:04:05") != "2020-01-01 09:05:07" {
+		t.Errorf("Unexpected time: %+v", eventTime)
+	}
+	if _, err := parseMySQLBinlogEventTime("# at 125"); err == nil {
+		t.Errorf("Expected error on non-header line")
+	}
+}
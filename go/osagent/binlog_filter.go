@@ -0,0 +1,307 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+// BinlogEventFilter selects binary log events. Each non-empty criterion must match; within a criterion,
+// any of the values may match. Filters are built by NewBinlogEventFilter.
+type BinlogEventFilter struct {
+	Databases  []string // Schema of table map and rows events; default database of statements
+	Tables     []string // "table" or "schema.table"; statements match when their SQL references the table
+	EventTypes []string // As in SHOW BINLOG EVENTS, e.g. Query, Table_map, Write_rows; case insensitive
+	ServerIds  []uint32
+
+	tablePatterns []binlogTablePattern
+}
+
+// binlogTablePattern is a table filter along with the patterns matching references to it in SQL
+type binlogTablePattern struct {
+	schema      string
+	table       string
+	referenced  *regexp.Regexp // the table as given: qualified by schema if the filter has one
+	unqualified *regexp.Regexp // the bare table name, for statements whose default database is the schema
+}
+
+// NewBinlogEventFilter returns a filter of given criteria, with its table patterns compiled once
+func NewBinlogEventFilter(databases []string, tables []string, eventTypes []string, serverIds []uint32) *BinlogEventFilter {
+	this := &BinlogEventFilter{
+		Databases:  databases,
+		Tables:     tables,
+		EventTypes: eventTypes,
+		ServerIds:  serverIds,
+	}
+	for _, table := range tables {
+		pattern := binlogTablePattern{table: table}
+		if tokens := strings.SplitN(table, ".", 2); len(tokens) == 2 {
+			pattern.schema, pattern.table = tokens[0], tokens[1]
+		}
+		pattern.referenced = sqlNamePattern(pattern.schema, pattern.table)
+		pattern.unqualified = sqlNamePattern("", pattern.table)
+		this.tablePatterns = append(this.tablePatterns, pattern)
+	}
+	return this
+}
+
+// IsEmpty returns true when this filter matches all events
+func (this *BinlogEventFilter) IsEmpty() bool {
+	return this == nil || (len(this.Databases) == 0 && len(this.Tables) == 0 && len(this.EventTypes) == 0 && len(this.ServerIds) == 0)
+}
+
+// sqlNamePattern returns a pattern matching SQL which references a (possibly schema qualified, possibly quoted) name
+func sqlNamePattern(schema string, name string) *regexp.Regexp {
+	pattern := "`?" + regexp.QuoteMeta(name) + "`?"
+	if schema != "" {
+		pattern = "`?" + regexp.QuoteMeta(schema) + "`?\\s*\\.\\s*" + pattern
+	}
+	return regexp.MustCompile("(?i)(^|[^\\w$.])" + pattern + "($|[^\\w$])")
+}
+
+func (this *BinlogEventFilter) matchesTable(event *inst.BinlogEvent) bool {
+	for _, pattern := range this.tablePatterns {
+		if event.Type == inst.QueryEvent || event.Type == inst.RowsQueryEvent {
+			if pattern.referenced.MatchString(event.SQL) {
+				return true
+			}
+			if pattern.schema != "" && pattern.schema == event.Schema && pattern.unqualified.MatchString(event.SQL) {
+				return true
+			}
+			continue
+		}
+		if event.Table == pattern.table && (pattern.schema == "" || pattern.schema == event.Schema) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *BinlogEventFilter) matchesEventType(event *inst.BinlogEvent) bool {
+	typeName := strings.ToLower(event.TypeName)
+	for _, eventType := range this.EventTypes {
+		eventType = strings.ToLower(eventType)
+		// "write_rows" also matches the older Write_rows_v1 and Write_rows_v0
+		if typeName == eventType || strings.HasPrefix(typeName, eventType+"_v") {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *BinlogEventFilter) matchesDatabase(event *inst.BinlogEvent) bool {
+	for _, database := range this.Databases {
+		if event.Schema == database {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *BinlogEventFilter) matchesServerId(event *inst.BinlogEvent) bool {
+	for _, serverId := range this.ServerIds {
+		if event.ServerId == serverId {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches returns true when given event passes this filter
+func (this *BinlogEventFilter) Matches(event *inst.BinlogEvent) bool {
+	if this.IsEmpty() {
+		return true
+	}
+	if len(this.ServerIds) > 0 && !this.matchesServerId(event) {
+		return false
+	}
+	if len(this.EventTypes) > 0 && !this.matchesEventType(event) {
+		return false
+	}
+	if len(this.Databases) > 0 && !this.matchesDatabase(event) {
+		return false
+	}
+	if len(this.Tables) > 0 && !this.matchesTable(event) {
+		return false
+	}
+	return true
+}
+
+// matchingBinlogEventPositions returns the start positions of the events in a binary log, within
+// given position range, which pass given filter
+func matchingBinlogEventPositions(ctx context.Context, binlogPath string, startPosition int64, stopPosition int64, filter *BinlogEventFilter) (map[int64]bool, error) {
+	positions := make(map[int64]bool)
+	err := inst.ScanBinlogEvents(binlogPath, startPosition, stopPosition, func(event *inst.BinlogEvent) bool {
+		if filter.Matches(event) {
+			positions[event.StartPosition] = true
+		}
+		return ctx.Err() == nil
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	return positions, err
+}
+
+// binlogContentsFilterWriter passes through the mysqlbinlog output of selected events. mysqlbinlog
+// precedes each event with a "# at <position>" line; output preceding the first event and following
+// the last one (session settings, delimiters) is always passed through.
+type binlogContentsFilterWriter struct {
+	writer  io.Writer
+	keep    func(position int64) bool
+	keeping bool
+	pending []byte
+}
+
+func newBinlogContentsFilterWriter(writer io.Writer, keep func(position int64) bool) *binlogContentsFilterWriter {
+	return &binlogContentsFilterWriter{writer: writer, keep: keep, keeping: true}
+}
+
+func (this *binlogContentsFilterWriter) writeLine(line []byte) error {
+	if bytes.HasPrefix(line, []byte("# at ")) {
+		position, err := strconv.ParseInt(string(bytes.TrimSpace(line[len("# at "):])), 10, 64)
+		this.keeping = err != nil || this.keep(position)
+	} else if bytes.HasPrefix(line, []byte("# End of log file")) {
+		this.keeping = true
+	}
+	if !this.keeping {
+		return nil
+	}
+	_, err := this.writer.Write(line)
+	return err
+}
+
+func (this *binlogContentsFilterWriter) Write(p []byte) (int, error) {
+	this.pending = append(this.pending, p...)
+	for {
+		i := bytes.IndexByte(this.pending, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if err := this.writeLine(this.pending[:i+1]); err != nil {
+			return 0, err
+		}
+		this.pending = this.pending[i+1:]
+	}
+}
+
+// Flush writes a last line which is not terminated by a newline
+func (this *binlogContentsFilterWriter) Flush() error {
+	if len(this.pending) == 0 {
+		return nil
+	}
+	err := this.writeLine(this.pending)
+	this.pending = nil
+	return err
+}
+
+// streamFilteredMySQLBinlogContents writes the mysqlbinlog decoded contents of those events in given binary logs
+// which pass given filter. Events are matched natively, and mysqlbinlog runs per binary log.
+func (this *MySQLInstance) streamFilteredMySQLBinlogContents(ctx context.Context, binlogFiles []string, startPosition int64, stopPosition int64, filter *BinlogEventFilter, writer io.Writer) error {
+	for i, binlogFile := range binlogFiles {
+		binlogPath, err := this.resolveMySQLLogPath(binlogFile)
+		if err != nil {
+			return err
+		}
+		var fileStartPosition, fileStopPosition int64
+		if i == 0 {
+			fileStartPosition = startPosition
+		}
+		if i == len(binlogFiles)-1 {
+			fileStopPosition = stopPosition
+		}
+		positions, err := matchingBinlogEventPositions(ctx, binlogPath, fileStartPosition, fileStopPosition, filter)
+		if err != nil {
+			return err
+		}
+		filterWriter := newBinlogContentsFilterWriter(writer, func(position int64) bool {
+			// The format description event (at 4), which mysqlbinlog needs for decoding, is always kept
+			return position <= 4 || positions[position]
+		})
+		if err := this.StreamMySQLBinlogContents(ctx, []string{binlogPath}, fileStartPosition, fileStopPosition, nil, filterWriter); err != nil {
+			return err
+		}
+		if err := filterWriter.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filteredMySQLBinlogContents returns the mysqlbinlog decoded contents of those events in given binary logs
+// which pass given filter, gzipped and base64 encoded as MySQLBinlogContents
+func (this *MySQLInstance) filteredMySQLBinlogContents(binlogFiles []string, startPosition int64, stopPosition int64, filter *BinlogEventFilter) (string, error) {
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	if err := this.streamFilteredMySQLBinlogContents(context.Background(), binlogFiles, startPosition, stopPosition, filter, gzipWriter); err != nil {
+		return "", err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
+}
+
+// streamFilteredRawBinlogFile writes the format description event and those raw events of a single binary log,
+// within given position range, which pass given filter
+func streamFilteredRawBinlogFile(ctx context.Context, binlogPath string, startPosition int64, stopPosition int64, filter *BinlogEventFilter, writer io.Writer) error {
+	file, err := os.Open(binlogPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := inst.NewBinlogEventReader(file)
+	if err != nil {
+		return fmt.Errorf("%s: %+v", binlogPath, err)
+	}
+	formatDescription, err := reader.ReadEvent()
+	if err != nil {
+		return fmt.Errorf("%s: %+v", binlogPath, err)
+	}
+	if _, err := io.Copy(writer, io.NewSectionReader(file, formatDescription.StartPosition, formatDescription.EndPosition-formatDescription.StartPosition)); err != nil {
+		return err
+	}
+	if startPosition < formatDescription.EndPosition {
+		startPosition = formatDescription.EndPosition
+	}
+	var writeErr error
+	err = inst.ScanBinlogEvents(binlogPath, startPosition, stopPosition, func(event *inst.BinlogEvent) bool {
+		if filter.Matches(event) {
+			_, writeErr = io.Copy(writer, io.NewSectionReader(file, event.StartPosition, event.EndPosition-event.StartPosition))
+		}
+		return writeErr == nil && ctx.Err() == nil
+	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	return ctx.Err()
+}
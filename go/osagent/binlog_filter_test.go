@@ -0,0 +1,119 @@
+package osagent
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+func TestBinlogEventFilterMatches(t *testing.T) {
+	insert := &inst.BinlogEvent{Type: inst.QueryEvent, TypeName: "Query", ServerId: 1, Schema: "shop", SQL: "INSERT INTO `orders` VALUES (1)"}
+	crossSchema := &inst.BinlogEvent{Type: inst.QueryEvent, TypeName: "Query", ServerId: 1, Schema: "test", SQL: "UPDATE shop.orders SET x=1"}
+	tableMap := &inst.BinlogEvent{Type: inst.TableMapEvent, TypeName: "Table_map", ServerId: 2, Schema: "shop", Table: "items"}
+	writeRows := &inst.BinlogEvent{Type: inst.WriteRowsEventV1, TypeName: "Write_rows_v1", ServerId: 2, Schema: "shop", Table: "items"}
+	xid := &inst.BinlogEvent{Type: inst.XidEvent, TypeName: "Xid", ServerId: 1}
+
+	tests := []struct {
+		filter   *BinlogEventFilter
+		event    *inst.BinlogEvent
+		expected bool
+	}{
+		{nil, xid, true},
+		{NewBinlogEventFilter(nil, nil, nil, nil), xid, true},
+		{NewBinlogEventFilter(nil, nil, nil, []uint32{2}), insert, false},
+		{NewBinlogEventFilter(nil, nil, nil, []uint32{1, 2}), tableMap, true},
+		{NewBinlogEventFilter([]string{"shop"}, nil, nil, nil), insert, true},
+		{NewBinlogEventFilter([]string{"shop"}, nil, nil, nil), xid, false},
+		{NewBinlogEventFilter([]string{"shop"}, nil, nil, nil), crossSchema, false},
+		{NewBinlogEventFilter(nil, []string{"orders"}, nil, nil), insert, true},
+		{NewBinlogEventFilter(nil, []string{"order"}, nil, nil), insert, false},
+		{NewBinlogEventFilter(nil, []string{"shop.orders"}, nil, nil), insert, true},
+		{NewBinlogEventFilter(nil, []string{"shop.orders"}, nil, nil), crossSchema, true},
+		{NewBinlogEventFilter(nil, []string{"test.orders"}, nil, nil), crossSchema, false},
+		{NewBinlogEventFilter(nil, []string{"items"}, nil, nil), writeRows, true},
+		{NewBinlogEventFilter(nil, []string{"other.items"}, nil, nil), writeRows, false},
+		{NewBinlogEventFilter(nil, nil, []string{"write_rows"}, nil), writeRows, true},
+		{NewBinlogEventFilter(nil, nil, []string{"Write_rows", "Table_map"}, nil), tableMap, true},
+		{NewBinlogEventFilter(nil, nil, []string{"Write_rows"}, nil), insert, false},
+		{NewBinlogEventFilter([]string{"shop"}, nil, []string{"Query"}, nil), tableMap, false},
+	}
+	for i, test := range tests {
+		if matches := test.filter.Matches(test.event); matches != test.expected {
+			t.Errorf("Test %d: expected %t for %+v with %+v", i, test.expected, test.event, test.filter)
+		}
+	}
+}
+
+func TestBinlogContentsFilterWriter(t *testing.T) {
+	contents := `/*!50530 SET @@SESSION.PSEUDO_SLAVE_MODE=1*/;
+DELIMITER /*!*/;
+# at 4
+#150601 14:03:27 server id 1  end_log_pos 120 	Start: binlog v 4
+# at 120
+#150601 14:03:28 server id 1  end_log_pos 199 	Query	thread_id=3
+BEGIN
+/*!*/;
+# at 199
+#150601 14:03:28 server id 1  end_log_pos 300 	Query	thread_id=3
+INSERT INTO orders VALUES (1)
+/*!*/;
+# at 300
+#150601 14:03:28 server id 1  end_log_pos 327 	Xid = 7
+COMMIT/*!*/;
+DELIMITER ;
+# End of log file
+ROLLBACK /* added by mysqlbinlog */;`
+	expected := `/*!50530 SET @@SESSION.PSEUDO_SLAVE_MODE=1*/;
+DELIMITER /*!*/;
+# at 4
+#150601 14:03:27 server id 1  end_log_pos 120 	Start: binlog v 4
+# at 199
+#150601 14:03:28 server id 1  end_log_pos 300 	Query	thread_id=3
+INSERT INTO orders VALUES (1)
+/*!*/;
+# End of log file
+ROLLBACK /* added by mysqlbinlog */;`
+
+	var output bytes.Buffer
+	writer := newBinlogContentsFilterWriter(&output, func(position int64) bool { return position == 4 || position == 199 })
+	// Written in chunks which split lines
+	for i := 0; i < len(contents); i += 7 {
+		end := i + 7
+		if end > len(contents) {
+			end = len(contents)
+		}
+		writer.Write([]byte(contents[i:end]))
+	}
+	writer.Flush()
+	if output.String() != expected {
+		t.Errorf("Unexpected filtered contents:\n%s", output.String())
+	}
+}
+
+func TestStreamFilteredRawBinlogEvents(t *testing.T) {
+	binlogDir, err := ioutil.TempDir("", "orchestrator-agent-test-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(binlogDir)
+
+	binlogPath := path.Join(binlogDir, "mysql-bin.000001")
+	insert := testQueryEvent("INSERT INTO orders VALUES (1)")
+	positions := testBinlogFileOf(t, binlogPath, testQueryEvent("BEGIN"), insert, testQueryEvent("COMMIT"))
+	binlog, _ := ioutil.ReadFile(binlogPath)
+
+	var output bytes.Buffer
+	filter := NewBinlogEventFilter(nil, []string{"orders"}, nil, nil)
+	if err := DefaultMySQLInstance().StreamRawBinlogEvents(context.Background(), []string{binlogPath}, 0, 0, filter, &output); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	expected := append(append([]byte{}, binlog[:positions[0]]...), insert...)
+	if !bytes.Equal(output.Bytes(), expected) {
+		t.Errorf("Unexpected filtered stream: %x", output.Bytes())
+	}
+}
@@ -0,0 +1,146 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"path"
+
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+// previousGTIDsScanEvents is how many events into a file its Previous_gtids event is looked for.
+// A relay log begins with its own format description, then Previous_gtids, then the master's events.
+const previousGTIDsScanEvents = 5
+
+// BinlogFileGTIDSets describes the GTIDs of a binary log or relay log: the transactions preceding the file,
+// as stated by its Previous_gtids event, and the transactions in the file itself
+type BinlogFileGTIDSets struct {
+	LogFile       string
+	PreviousGTIDs *inst.GTIDSet
+	GTIDs         *inst.GTIDSet
+}
+
+// readBinlogPreviousGTIDs returns the Previous_gtids set of a binary log; an empty set if it has none
+func readBinlogPreviousGTIDs(binlogPath string) (previousGTIDs *inst.GTIDSet, err error) {
+	previousGTIDs = inst.NewGTIDSet()
+	scannedEvents := 0
+	scanErr := inst.ScanBinlogEvents(binlogPath, 0, 0, func(event *inst.BinlogEvent) bool {
+		scannedEvents++
+		if event.Type == inst.PreviousGTIDsEvent {
+			previousGTIDs, err = inst.ParseGTIDSet(event.PreviousGTIDs)
+			return false
+		}
+		return scannedEvents < previousGTIDsScanEvents
+	})
+	if scanErr != nil {
+		return previousGTIDs, scanErr
+	}
+	return previousGTIDs, err
+}
+
+// scanBinlogGTIDs returns the set of transactions in a binary log, by its GTID events
+func scanBinlogGTIDs(binlogPath string) (gtids *inst.GTIDSet, err error) {
+	gtids = inst.NewGTIDSet()
+	scanErr := inst.ScanBinlogEvents(binlogPath, 0, 0, func(event *inst.BinlogEvent) bool {
+		if event.Type != inst.GTIDEvent {
+			return true
+		}
+		var gtid *inst.GTIDSet
+		if gtid, err = inst.ParseGTID(event.GTID); err != nil {
+			return false
+		}
+		gtids = gtids.Union(gtid)
+		return true
+	})
+	if scanErr != nil {
+		return gtids, scanErr
+	}
+	return gtids, err
+}
+
+// binlogFilesGTIDSets returns the GTID sets of consecutive binary logs. Transactions in each file are
+// the difference between the next file's Previous_gtids and its own; those of the last file, possibly
+// still being written, are read from its GTID events.
+func binlogFilesGTIDSets(binlogPaths []string) ([]BinlogFileGTIDSets, error) {
+	result := []BinlogFileGTIDSets{}
+	for _, binlogPath := range binlogPaths {
+		previousGTIDs, err := readBinlogPreviousGTIDs(binlogPath)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, BinlogFileGTIDSets{LogFile: path.Base(binlogPath), PreviousGTIDs: previousGTIDs})
+	}
+	for i := range result {
+		if i < len(result)-1 {
+			result[i].GTIDs = result[i+1].PreviousGTIDs.Subtract(result[i].PreviousGTIDs)
+			continue
+		}
+		gtids, err := scanBinlogGTIDs(binlogPaths[i])
+		if err != nil {
+			return result, err
+		}
+		result[i].GTIDs = gtids
+	}
+	return result, nil
+}
+
+// filterGTIDSetsContaining returns only the files whose transactions include given GTID.
+// An empty GTID filters nothing.
+func filterGTIDSetsContaining(gtidSets []BinlogFileGTIDSets, gtid string) ([]BinlogFileGTIDSets, error) {
+	if gtid == "" {
+		return gtidSets, nil
+	}
+	filtered := []BinlogFileGTIDSets{}
+	for _, fileGTIDSets := range gtidSets {
+		contains, err := fileGTIDSets.GTIDs.ContainsGTID(gtid)
+		if err != nil {
+			return nil, err
+		}
+		if contains {
+			filtered = append(filtered, fileGTIDSets)
+		}
+	}
+	return filtered, nil
+}
+
+// BinlogGTIDSets returns the GTID sets of the local binary logs, optionally only of the file containing
+// given GTID
+func (this *MySQLInstance) BinlogGTIDSets(gtid string) ([]BinlogFileGTIDSets, error) {
+	binlogPaths, err := this.GetBinlogFileNames()
+	if err != nil {
+		return nil, err
+	}
+	gtidSets, err := binlogFilesGTIDSets(binlogPaths)
+	if err != nil {
+		return nil, err
+	}
+	return filterGTIDSetsContaining(gtidSets, gtid)
+}
+
+// RelayLogGTIDSets returns the GTID sets of the local relay logs, optionally only of the file containing
+// given GTID
+func (this *MySQLInstance) RelayLogGTIDSets(gtid string) ([]BinlogFileGTIDSets, error) {
+	relaylogPaths, err := this.GetRelayLogFileNames()
+	if err != nil {
+		return nil, err
+	}
+	gtidSets, err := binlogFilesGTIDSets(relaylogPaths)
+	if err != nil {
+		return nil, err
+	}
+	return filterGTIDSetsContaining(gtidSets, gtid)
+}
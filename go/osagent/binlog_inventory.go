@@ -0,0 +1,221 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+// BinlogFileInfo describes a binary log or relay log file
+type BinlogFileInfo struct {
+	LogFile        string
+	Path           string
+	Size           int64
+	FirstEventTime time.Time
+	LastEventTime  time.Time
+	EndCoordinates inst.BinlogCoordinates
+	modTime        time.Time
+	createTime     time.Time // timestamp of the format description event
+}
+
+// binlogFileInfoCaches hold file info by MySQL instance name and log type, then by path. An entry is valid as
+// long as the file's size and modification time are unchanged, which is forever for rotated files; a file
+// which only grew is read on from its cached end. Entries of files no longer listed in the index are dropped.
+var binlogFileInfoCaches = make(map[string]map[string]BinlogFileInfo)
+var binlogFileInfoCacheMutex = &sync.Mutex{}
+
+// binlogIndexFileFromOptions returns the binary log index file implied by server options, or an empty string
+// if binary logging is not configured with an explicit name
+func binlogIndexFileFromOptions(options map[string]string, dataDir string) string {
+	resolve := func(fileName string) string {
+		if path.IsAbs(fileName) {
+			return fileName
+		}
+		return path.Join(dataDir, fileName)
+	}
+	if indexFile := options["log-bin-index"]; indexFile != "" {
+		return resolve(indexFile)
+	}
+	if baseName := options["log-bin"]; baseName != "" {
+		// mysqld ignores any extension of the base name
+		if extension := path.Ext(baseName); extension != "" {
+			baseName = strings.TrimSuffix(baseName, extension)
+		}
+		return resolve(baseName + ".index")
+	}
+	return ""
+}
+
+// scanBinlogFileInfo reads the events of a binary log or relay log into given file info, from the info's end
+// coordinates on. Only a binary log is resumed past its start: it has a single format description event,
+// while a relay log may hold the master's too, and is rescanned from its start.
+func scanBinlogFileInfo(info *BinlogFileInfo) error {
+	file, err := os.Open(info.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := inst.NewBinlogEventReader(file)
+	if err != nil {
+		return fmt.Errorf("%s: %+v", info.Path, err)
+	}
+	firstEventPosition := reader.Position()
+	if info.EndCoordinates.Type != inst.BinaryLog || info.EndCoordinates.LogPos <= firstEventPosition {
+		info.FirstEventTime, info.LastEventTime = time.Time{}, time.Time{}
+		info.EndCoordinates.LogPos = firstEventPosition
+	}
+	resumePosition := info.EndCoordinates.LogPos
+	for {
+		event, err := reader.ReadEvent()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// An active binary log may end with a partial event
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %+v", info.Path, err)
+		}
+		if event.StartPosition < resumePosition {
+			// The format description event, read for the sake of decoding what follows. Its timestamp, the
+			// file's creation time, tells whether this is still the file read before.
+			if event.Timestamp.Equal(info.createTime) {
+				if err := reader.SeekPosition(resumePosition); err != nil {
+					return err
+				}
+				continue
+			}
+			info.FirstEventTime, info.LastEventTime = time.Time{}, time.Time{}
+			resumePosition = event.StartPosition
+		}
+		if event.Type == inst.FormatDescriptionEvent && event.StartPosition == firstEventPosition {
+			info.createTime = event.Timestamp
+		}
+		if event.Timestamp.Unix() != 0 {
+			if info.FirstEventTime.IsZero() {
+				info.FirstEventTime = event.Timestamp
+			}
+			info.LastEventTime = event.Timestamp
+		}
+		// End of the last complete event
+		info.EndCoordinates.LogPos = event.EndPosition
+	}
+}
+
+// binlogFileInfoCache returns the file info cache of given MySQL instance and log type, first dropping the
+// entries of files not in given list. Expects binlogFileInfoCacheMutex to be held.
+func binlogFileInfoCache(instanceName string, binlogType inst.BinlogType, binlogPaths []string) map[string]BinlogFileInfo {
+	cacheKey := fmt.Sprintf("%s:%+v", instanceName, binlogType)
+	cache, ok := binlogFileInfoCaches[cacheKey]
+	if !ok {
+		cache = make(map[string]BinlogFileInfo)
+		binlogFileInfoCaches[cacheKey] = cache
+	}
+	listed := make(map[string]bool)
+	for _, binlogPath := range binlogPaths {
+		listed[binlogPath] = true
+	}
+	for binlogPath := range cache {
+		if !listed[binlogPath] {
+			delete(cache, binlogPath)
+		}
+	}
+	return cache
+}
+
+// readBinlogFileInfo reads size, first/last event times and end coordinates of a binary log or relay log,
+// using and updating given cache
+func readBinlogFileInfo(cache map[string]BinlogFileInfo, binlogPath string, binlogType inst.BinlogType) (BinlogFileInfo, error) {
+	fileInfo, err := os.Stat(binlogPath)
+	if err != nil {
+		return BinlogFileInfo{}, err
+	}
+	info, ok := cache[binlogPath]
+	if ok && info.Size == fileInfo.Size() && info.modTime.Equal(fileInfo.ModTime()) {
+		return info, nil
+	}
+	if !ok || fileInfo.Size() < info.Size {
+		// Not cached, or not the same file
+		info = BinlogFileInfo{
+			LogFile:        path.Base(binlogPath),
+			Path:           binlogPath,
+			EndCoordinates: inst.BinlogCoordinates{LogFile: path.Base(binlogPath), Type: binlogType},
+		}
+	}
+	info.Size = fileInfo.Size()
+	info.modTime = fileInfo.ModTime()
+	if err := scanBinlogFileInfo(&info); err != nil {
+		return info, err
+	}
+	cache[binlogPath] = info
+	return info, nil
+}
+
+// binlogFilesInfo reads file info of given binary logs or relay logs, which make the complete list of such
+// logs of given MySQL instance
+func binlogFilesInfo(instanceName string, binlogPaths []string, binlogType inst.BinlogType) ([]BinlogFileInfo, error) {
+	binlogFileInfoCacheMutex.Lock()
+	defer binlogFileInfoCacheMutex.Unlock()
+
+	cache := binlogFileInfoCache(instanceName, binlogType, binlogPaths)
+	result := []BinlogFileInfo{}
+	for _, binlogPath := range binlogPaths {
+		info, err := readBinlogFileInfo(cache, binlogPath, binlogType)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+// BinlogInventory lists the server's binary logs with their size, first/last event times and end coordinates.
+// It reads files only, and so works while MySQL is down.
+func (this *MySQLInstance) BinlogInventory() ([]BinlogFileInfo, error) {
+	binlogPaths, err := this.GetBinlogFileNames()
+	if err != nil {
+		return nil, err
+	}
+	return binlogFilesInfo(this.Name, binlogPaths, inst.BinaryLog)
+}
+
+// GetBinlogEndCoordinates returns the coordinates at the end of the binary logs
+func (this *MySQLInstance) GetBinlogEndCoordinates() (*inst.BinlogCoordinates, error) {
+	binlogPaths, err := this.GetBinlogFileNames()
+	if err != nil {
+		return nil, err
+	}
+	if len(binlogPaths) == 0 {
+		return nil, log.Errorf("No binary logs found")
+	}
+	binlogFileInfoCacheMutex.Lock()
+	defer binlogFileInfoCacheMutex.Unlock()
+	cache := binlogFileInfoCache(this.Name, inst.BinaryLog, binlogPaths)
+	info, err := readBinlogFileInfo(cache, binlogPaths[len(binlogPaths)-1], inst.BinaryLog)
+	if err != nil {
+		return nil, err
+	}
+	return &info.EndCoordinates, nil
+}
@@ -0,0 +1,78 @@
+package osagent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+func TestBinlogFilesInfo(t *testing.T) {
+	binlogDir, err := ioutil.TempDir("", "orchestrator-agent-test-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(binlogDir)
+	testCacheKey := fmt.Sprintf("%s:%+v", "test", inst.BinaryLog)
+	defer func() {
+		binlogFileInfoCacheMutex.Lock()
+		delete(binlogFileInfoCaches, testCacheKey)
+		binlogFileInfoCacheMutex.Unlock()
+	}()
+
+	binlog1 := path.Join(binlogDir, "mysql-bin.000001")
+	binlog2 := path.Join(binlogDir, "mysql-bin.000002")
+	testBinlogFile(t, binlog1, 100, 110)
+	testBinlogFile(t, binlog2, 200, 210)
+
+	infos, err := binlogFilesInfo("test", []string{binlog1, binlog2}, inst.BinaryLog)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(infos) != 2 || infos[1].FirstEventTime.Unix() != 200 || infos[1].LastEventTime.Unix() != 210 || infos[1].EndCoordinates.LogPos != infos[1].Size {
+		t.Fatalf("Unexpected file info: %+v", infos)
+	}
+
+	// The active binary log grows. Its earlier events are not read again: the changed first event goes unnoticed.
+	testBinlogFile(t, binlog2, 205, 210, 220)
+	infos, err = binlogFilesInfo("test", []string{binlog1, binlog2}, inst.BinaryLog)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if infos[1].FirstEventTime.Unix() != 200 || infos[1].LastEventTime.Unix() != 220 || infos[1].EndCoordinates.LogPos != infos[1].Size {
+		t.Errorf("Unexpected file info of grown binary log: %+v", infos[1])
+	}
+
+	// A file of the same name, but a different format description event, is read from its start
+	testBinlogFile(t, binlog2, 205, 210, 220, 230)
+	binlog, err := ioutil.ReadFile(binlog2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	binary.LittleEndian.PutUint32(binlog[4:8], 190)
+	if err := ioutil.WriteFile(binlog2, binlog, 0644); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	infos, err = binlogFilesInfo("test", []string{binlog1, binlog2}, inst.BinaryLog)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if infos[1].FirstEventTime.Unix() != 190 || infos[1].LastEventTime.Unix() != 230 || infos[1].EndCoordinates.LogPos != infos[1].Size {
+		t.Errorf("Unexpected file info of replaced binary log: %+v", infos[1])
+	}
+
+	// Purged files are dropped from the cache
+	if _, err := binlogFilesInfo("test", []string{binlog2}, inst.BinaryLog); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	binlogFileInfoCacheMutex.Lock()
+	_, cached := binlogFileInfoCaches[testCacheKey][binlog1]
+	binlogFileInfoCacheMutex.Unlock()
+	if cached {
+		t.Errorf("Unexpected cache entry of purged binary log %s", binlog1)
+	}
+}
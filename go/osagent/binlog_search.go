@@ -0,0 +1,136 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+// BinlogTimeCoordinates are the coordinates of the first event at or after a requested time
+type BinlogTimeCoordinates struct {
+	RequestedTime time.Time
+	Coordinates   inst.BinlogCoordinates
+	EventTime     time.Time
+	EventType     string
+}
+
+// isBinlogFileHeaderEvent returns true for events describing the log file rather than a change. Their
+// timestamps are those of the file's (or, in relay logs, the master's file's) creation.
+func isBinlogFileHeaderEvent(eventType inst.BinlogEventType) bool {
+	switch eventType {
+	case inst.FormatDescriptionEvent, inst.RotateEvent, inst.PreviousGTIDsEvent, inst.StopEvent, inst.HeartbeatEvent:
+		return true
+	}
+	return false
+}
+
+// findBinlogEventAtOrAfter scans a binary log or relay log for the first change event whose timestamp
+// is at or after given time; it returns nil when there is none
+func findBinlogEventAtOrAfter(binlogPath string, atTime time.Time) (found *inst.BinlogEvent, err error) {
+	err = inst.ScanBinlogEvents(binlogPath, 0, 0, func(event *inst.BinlogEvent) bool {
+		if isBinlogFileHeaderEvent(event.Type) || event.Timestamp.Before(atTime) {
+			return true
+		}
+		found = event
+		return false
+	})
+	return found, err
+}
+
+// firstBinlogEventTime returns the timestamp of the first change event in a binary log or relay log,
+// or false when the file has none
+func firstBinlogEventTime(binlogPath string) (eventTime time.Time, ok bool, err error) {
+	event, err := findBinlogEventAtOrAfter(binlogPath, time.Time{})
+	if err != nil || event == nil {
+		return eventTime, false, err
+	}
+	return event.Timestamp, true, nil
+}
+
+// findBinlogCoordinatesByTime finds the first event at or after given time in given (oldest first) log files.
+// Files are binary searched by their first event's time, then the candidate file is scanned.
+func findBinlogCoordinatesByTime(binlogPaths []string, binlogType inst.BinlogType, atTime time.Time) (*BinlogTimeCoordinates, error) {
+	var searchErr error
+	// The first file beginning after the requested time; files with no events yet sort last
+	index := sort.Search(len(binlogPaths), func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+		eventTime, ok, err := firstBinlogEventTime(binlogPaths[i])
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return !ok || eventTime.After(atTime)
+	})
+	if searchErr != nil {
+		return nil, searchErr
+	}
+	if index > 0 {
+		// The requested time may be within the previous file
+		index--
+	}
+	for _, binlogPath := range binlogPaths[index:] {
+		event, err := findBinlogEventAtOrAfter(binlogPath, atTime)
+		if err != nil {
+			return nil, err
+		}
+		if event != nil {
+			return &BinlogTimeCoordinates{
+				RequestedTime: atTime,
+				Coordinates:   inst.BinlogCoordinates{LogFile: path.Base(binlogPath), LogPos: event.StartPosition, Type: binlogType},
+				EventTime:     event.Timestamp,
+				EventType:     event.TypeName,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("No events found at or after %s", atTime.Format(pitrDatetimeFormat))
+}
+
+// FindBinlogCoordinatesByTime returns the coordinates of the first event, in the local binary logs or relay logs,
+// whose timestamp is at or after given time, along with the event's exact timestamp. Event timestamps are
+// those of statement start, so the search is by file order and not strictly by time.
+func (this *MySQLInstance) FindBinlogCoordinatesByTime(binlogType inst.BinlogType, atTime time.Time) (*BinlogTimeCoordinates, error) {
+	var binlogPaths []string
+	var err error
+	if binlogType == inst.RelayLog {
+		binlogPaths, err = this.GetRelayLogFileNames()
+	} else {
+		binlogPaths, err = this.GetBinlogFileNames()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return findBinlogCoordinatesByTime(binlogPaths, binlogType, atTime)
+}
+
+// ParseBinlogTime parses a time as given to mysqlbinlog's --start-datetime, "2006-01-02 15:04:05" in
+// local time, or in RFC 3339 format
+func ParseBinlogTime(timeText string) (time.Time, error) {
+	if parsed, err := time.ParseInLocation(pitrDatetimeFormat, timeText, time.Local); err == nil {
+		return parsed, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, timeText); err == nil {
+		return parsed, nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid time %s; expected format is %s or RFC 3339", timeText, pitrDatetimeFormat)
+}
@@ -0,0 +1,96 @@
+package osagent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+// testBinlogFile writes a binary log with a format description event followed by xid events of given timestamps
+func testBinlogFile(t *testing.T, binlogPath string, timestamps ...int64) {
+	formatDescriptionBody := make([]byte, 2+50+4+1+27)
+	binary.LittleEndian.PutUint16(formatDescriptionBody[0:2], 4)
+	copy(formatDescriptionBody[2:], "5.5.40-log")
+	formatDescriptionBody[56] = 19
+	binlog := append(append([]byte{}, inst.BinlogMagic...), testBinlogEvent(inst.FormatDescriptionEvent, formatDescriptionBody)...)
+	for _, timestamp := range timestamps {
+		event := testBinlogEvent(inst.XidEvent, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+		binary.LittleEndian.PutUint32(event[0:4], uint32(timestamp))
+		binlog = append(binlog, event...)
+	}
+	if err := ioutil.WriteFile(binlogPath, binlog, 0644); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+}
+
+func TestFindBinlogCoordinatesByTime(t *testing.T) {
+	binlogDir, err := ioutil.TempDir("", "orchestrator-agent-test-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(binlogDir)
+
+	binlogPaths := []string{}
+	for i, timestamps := range [][]int64{{100, 110, 120}, {200, 210}, {300, 310, 320}, {}} {
+		binlogPath := path.Join(binlogDir, fmt.Sprintf("mysql-bin.%06d", i+1))
+		testBinlogFile(t, binlogPath, timestamps...)
+		binlogPaths = append(binlogPaths, binlogPath)
+	}
+	eventSize := int64(19 + 8)
+	firstEventPosition := int64(4 + 19 + 2 + 50 + 4 + 1 + 27)
+
+	tests := []struct {
+		atTime            int64
+		expectedFile      string
+		expectedPosition  int64
+		expectedEventTime int64
+	}{
+		{50, "mysql-bin.000001", firstEventPosition, 100},
+		{110, "mysql-bin.000001", firstEventPosition + eventSize, 110},
+		{115, "mysql-bin.000001", firstEventPosition + 2*eventSize, 120},
+		{150, "mysql-bin.000002", firstEventPosition, 200},
+		{305, "mysql-bin.000003", firstEventPosition + eventSize, 310},
+		{320, "mysql-bin.000003", firstEventPosition + 2*eventSize, 320},
+	}
+	for _, test := range tests {
+		result, err := findBinlogCoordinatesByTime(binlogPaths, inst.BinaryLog, time.Unix(test.atTime, 0))
+		if err != nil {
+			t.Fatalf("At %d: unexpected error: %+v", test.atTime, err)
+		}
+		if result.Coordinates.LogFile != test.expectedFile || result.Coordinates.LogPos != test.expectedPosition {
+			t.Errorf("At %d: expected %s:%d, got %+v", test.atTime, test.expectedFile, test.expectedPosition, result.Coordinates)
+		}
+		if result.EventTime.Unix() != test.expectedEventTime {
+			t.Errorf("At %d: expected event time %d, got %d", test.atTime, test.expectedEventTime, result.EventTime.Unix())
+		}
+	}
+	if _, err := findBinlogCoordinatesByTime(binlogPaths, inst.BinaryLog, time.Unix(400, 0)); err == nil {
+		t.Errorf("Expected error for time beyond last event")
+	}
+}
+
+func TestParseBinlogTime(t *testing.T) {
+	parsed, err := ParseBinlogTime("2015-06-01 14:03:27")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if expected := time.Date(2015, 6, 1, 14, 3, 27, 0, time.Local); !parsed.Equal(expected) {
+		t.Errorf("Expected %s, got %s", expected, parsed)
+	}
+	parsed, err = ParseBinlogTime("2015-06-01T14:03:27Z")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if expected := time.Date(2015, 6, 1, 14, 3, 27, 0, time.UTC); !parsed.Equal(expected) {
+		t.Errorf("Expected %s, got %s", expected, parsed)
+	}
+	if _, err := ParseBinlogTime("yesterday"); err == nil {
+		t.Errorf("Expected error")
+	}
+}
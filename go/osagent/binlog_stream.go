@@ -0,0 +1,171 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+// contextReader fails reads once its context is done
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (this *contextReader) Read(p []byte) (int, error) {
+	if err := this.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return this.reader.Read(p)
+}
+
+// StreamMySQLBinlogContents writes the mysqlbinlog decoded contents of given binary logs to writer as
+// they are produced, rather than collecting them in memory. mysqlbinlog is killed when ctx is done,
+// e.g. when the requesting client disconnects. Only events passing the (optional) filter are written.
+func (this *MySQLInstance) StreamMySQLBinlogContents(ctx context.Context, binlogFiles []string, startPosition int64, stopPosition int64, filter *BinlogEventFilter, writer io.Writer) error {
+	if len(binlogFiles) == 0 {
+		return log.Errorf("No binlog files provided in StreamMySQLBinlogContents")
+	}
+	if !filter.IsEmpty() {
+		return this.streamFilteredMySQLBinlogContents(ctx, binlogFiles, startPosition, stopPosition, filter, writer)
+	}
+	command := `mysqlbinlog`
+	for _, binlogFile := range binlogFiles {
+		command = fmt.Sprintf("%s %s", command, binlogFile)
+	}
+	if startPosition != 0 {
+		command = fmt.Sprintf("%s --start-position=%d", command, startPosition)
+	}
+	if stopPosition != 0 {
+		command = fmt.Sprintf("%s --stop-position=%d", command, stopPosition)
+	}
+	cmd, tmpFileName, err := execCmd(command)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFileName)
+	cmd.Stdout = writer
+	// A process group of its own, so that cancellation kills mysqlbinlog and not just its shell
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			log.Debugf("Binlog contents stream canceled; killing mysqlbinlog")
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+	}()
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// StreamRawBinlogEvents writes the raw events of given binary logs to writer, from the start position in the
+// first file to the stop position (0 meaning the end of file) in the last file. The output begins with the
+// binary log magic number and the first file's format description event, so it can be decoded as a binary log.
+// Given an (optional) filter, only the events passing it follow each file's format description event.
+func (this *MySQLInstance) StreamRawBinlogEvents(ctx context.Context, binlogFiles []string, startPosition int64, stopPosition int64, filter *BinlogEventFilter, writer io.Writer) error {
+	if len(binlogFiles) == 0 {
+		return errors.New("No binlog files provided in StreamRawBinlogEvents")
+	}
+	if _, err := writer.Write(inst.BinlogMagic); err != nil {
+		return err
+	}
+	for i, binlogFile := range binlogFiles {
+		binlogPath, err := this.resolveMySQLLogPath(binlogFile)
+		if err != nil {
+			return err
+		}
+		if !filter.IsEmpty() {
+			var fileStartPosition, fileStopPosition int64
+			if i == 0 {
+				fileStartPosition = startPosition
+			}
+			if i == len(binlogFiles)-1 {
+				fileStopPosition = stopPosition
+			}
+			if err := streamFilteredRawBinlogFile(ctx, binlogPath, fileStartPosition, fileStopPosition, filter, writer); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := streamRawBinlogFile(ctx, binlogPath, i == 0, startPosition, i == len(binlogFiles)-1, stopPosition, writer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamRawBinlogFile writes the raw events of a single binary log to writer
+func streamRawBinlogFile(ctx context.Context, binlogPath string, isFirst bool, startPosition int64, isLast bool, stopPosition int64, writer io.Writer) error {
+	file, err := os.Open(binlogPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := inst.NewBinlogEventReader(file)
+	if err != nil {
+		return fmt.Errorf("%s: %+v", binlogPath, err)
+	}
+	formatDescription, err := reader.ReadEvent()
+	if err != nil {
+		return fmt.Errorf("%s: %+v", binlogPath, err)
+	}
+	from := formatDescription.StartPosition
+	if isFirst && startPosition > formatDescription.EndPosition {
+		if _, err := file.Seek(formatDescription.StartPosition, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(writer, file, formatDescription.EndPosition-formatDescription.StartPosition); err != nil {
+			return err
+		}
+		from = startPosition
+	}
+	if _, err := file.Seek(from, io.SeekStart); err != nil {
+		return err
+	}
+	source := &contextReader{ctx: ctx, reader: file}
+	if isLast && stopPosition > 0 {
+		if stopPosition < from {
+			return fmt.Errorf("Stop position %d precedes start position %d", stopPosition, from)
+		}
+		_, err = io.CopyN(writer, source, stopPosition-from)
+		if err == io.EOF {
+			err = nil
+		}
+		return err
+	}
+	_, err = io.Copy(writer, source)
+	return err
+}
@@ -0,0 +1,63 @@
+package osagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+// testBinlogEvent returns a binary log event without checksum
+func testBinlogEvent(eventType inst.BinlogEventType, body []byte) []byte {
+	header := make([]byte, 19)
+	header[4] = byte(eventType)
+	binary.LittleEndian.PutUint32(header[9:13], uint32(len(header)+len(body)))
+	return append(header, body...)
+}
+
+func TestStreamRawBinlogEvents(t *testing.T) {
+	formatDescriptionBody := make([]byte, 2+50+4+1+27)
+	binary.LittleEndian.PutUint16(formatDescriptionBody[0:2], 4)
+	copy(formatDescriptionBody[2:], "5.5.40-log")
+	formatDescriptionBody[56] = 19
+	formatDescription := testBinlogEvent(inst.FormatDescriptionEvent, formatDescriptionBody)
+	xid1 := testBinlogEvent(inst.XidEvent, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+	xid2 := testBinlogEvent(inst.XidEvent, []byte{2, 0, 0, 0, 0, 0, 0, 0})
+
+	binlog := append(append(append(append([]byte{}, inst.BinlogMagic...), formatDescription...), xid1...), xid2...)
+	file, err := ioutil.TempFile("", "orchestrator-agent-test-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(binlog)
+	file.Close()
+
+	xid2Position := int64(4 + len(formatDescription) + len(xid1))
+	var output bytes.Buffer
+	if err := DefaultMySQLInstance().StreamRawBinlogEvents(context.Background(), []string{file.Name()}, xid2Position, 0, nil, &output); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	expected := append(append(append([]byte{}, inst.BinlogMagic...), formatDescription...), xid2...)
+	if !bytes.Equal(output.Bytes(), expected) {
+		t.Errorf("Unexpected stream from start position: %x", output.Bytes())
+	}
+
+	output.Reset()
+	if err := DefaultMySQLInstance().StreamRawBinlogEvents(context.Background(), []string{file.Name()}, 0, xid2Position, nil, &output); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if !bytes.Equal(output.Bytes(), binlog[:xid2Position]) {
+		t.Errorf("Unexpected stream up to stop position: %x", output.Bytes())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := DefaultMySQLInstance().StreamRawBinlogEvents(ctx, []string{file.Name()}, 0, 0, nil, &output); err == nil {
+		t.Errorf("Expected canceled stream to fail")
+	}
+}
@@ -0,0 +1,201 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+// defaultBinlogTransactionsCount applies when neither a count nor a byte budget is given
+const defaultBinlogTransactionsCount = 100
+
+// BinlogTransaction is a complete transaction in a binary log or relay log. Its coordinates may be
+// in different relay logs, as the I/O thread may rotate a relay log mid-transaction.
+type BinlogTransaction struct {
+	GTID             string `json:",omitempty"`
+	StartCoordinates inst.BinlogCoordinates
+	EndCoordinates   inst.BinlogCoordinates
+	Timestamp        time.Time
+	Events           int
+	Size             int64
+}
+
+// BinlogTransactions lists the transactions read from a binary log or relay log range. NextCoordinates
+// are where reading should resume: the end of the last transaction, or the start coordinates if none were read.
+type BinlogTransactions struct {
+	Transactions    []BinlogTransaction
+	NextCoordinates inst.BinlogCoordinates
+}
+
+// binlogTransactionsReader groups binary log events into complete transactions
+type binlogTransactionsReader struct {
+	binlogType      inst.BinlogType
+	maxCount        int
+	maxBytes        int64
+	synced          bool
+	current         *BinlogTransaction
+	currentHasBegin bool
+	result          BinlogTransactions
+	totalBytes      int64
+}
+
+func isBinlogCommitQuery(sql string) bool {
+	sql = strings.ToUpper(strings.TrimSpace(sql))
+	return sql == "COMMIT" || sql == "ROLLBACK" || strings.HasPrefix(sql, "XA COMMIT") || strings.HasPrefix(sql, "XA ROLLBACK")
+}
+
+func (this *binlogTransactionsReader) begin(logFile string, event *inst.BinlogEvent) {
+	this.synced = true
+	this.current = &BinlogTransaction{
+		StartCoordinates: inst.BinlogCoordinates{LogFile: logFile, LogPos: event.StartPosition, Type: this.binlogType},
+		Timestamp:        event.Timestamp,
+	}
+	this.currentHasBegin = false
+	if event.Type == inst.GTIDEvent {
+		this.current.GTID = event.GTID
+	}
+}
+
+// end completes the current transaction, and returns false when no more transactions are wanted
+func (this *binlogTransactionsReader) end(logFile string, event *inst.BinlogEvent) bool {
+	transaction := this.current
+	this.current = nil
+	transaction.EndCoordinates = inst.BinlogCoordinates{LogFile: logFile, LogPos: event.EndPosition, Type: this.binlogType}
+	if this.maxBytes > 0 && len(this.result.Transactions) > 0 && this.totalBytes+transaction.Size > this.maxBytes {
+		// Over budget; at least one transaction is always returned
+		return false
+	}
+	this.totalBytes += transaction.Size
+	this.result.Transactions = append(this.result.Transactions, *transaction)
+	this.result.NextCoordinates = transaction.EndCoordinates
+	if this.maxCount > 0 && len(this.result.Transactions) >= this.maxCount {
+		return false
+	}
+	if this.maxBytes > 0 && this.totalBytes >= this.maxBytes {
+		return false
+	}
+	return true
+}
+
+// onEvent handles the next event, and returns false when no more transactions are wanted.
+// Events preceding the first transaction boundary (those of a transaction which began before the start
+// coordinates) are skipped. Boundaries are reliable where GTID events are written, as with MySQL 5.7+
+// (anonymous GTID events even without GTID mode); otherwise they are BEGIN/COMMIT and Xid events.
+func (this *binlogTransactionsReader) onEvent(logFile string, event *inst.BinlogEvent) bool {
+	if isBinlogFileHeaderEvent(event.Type) {
+		return true
+	}
+	isGTID := event.Type == inst.GTIDEvent || event.Type == inst.AnonymousGTIDEvent
+	isBegin := event.Type == inst.QueryEvent && strings.ToUpper(strings.TrimSpace(event.SQL)) == "BEGIN"
+	if this.current != nil && isGTID {
+		// The previous transaction never completed, e.g. the master crashed mid-transaction
+		this.current = nil
+	}
+	if this.current == nil {
+		switch {
+		case isGTID:
+			this.begin(logFile, event)
+		case isBegin:
+			this.begin(logFile, event)
+		case event.Type == inst.QueryEvent && this.synced && !isBinlogCommitQuery(event.SQL):
+			// A statement outside BEGIN/COMMIT, e.g. DDL, is a transaction of its own
+			this.begin(logFile, event)
+		default:
+			return true
+		}
+	}
+	this.current.Events++
+	this.current.Size += event.EndPosition - event.StartPosition
+
+	switch event.Type {
+	case inst.QueryEvent:
+		if isBegin {
+			this.currentHasBegin = true
+			return true
+		}
+		if !this.currentHasBegin || isBinlogCommitQuery(event.SQL) {
+			return this.end(logFile, event)
+		}
+	case inst.XidEvent, inst.XAPrepareEvent:
+		return this.end(logFile, event)
+	}
+	return true
+}
+
+// readBinlogTransactions reads complete transactions from given (oldest first) log files, starting at the start
+// position of the first file, up to a count and/or byte budget
+func readBinlogTransactions(binlogPaths []string, binlogType inst.BinlogType, startPosition int64, maxCount int, maxBytes int64) (*BinlogTransactions, error) {
+	if len(binlogPaths) == 0 {
+		return nil, fmt.Errorf("No log files to read transactions from")
+	}
+	if maxCount <= 0 && maxBytes <= 0 {
+		maxCount = defaultBinlogTransactionsCount
+	}
+	reader := &binlogTransactionsReader{
+		binlogType: binlogType,
+		maxCount:   maxCount,
+		maxBytes:   maxBytes,
+		result: BinlogTransactions{
+			Transactions:    []BinlogTransaction{},
+			NextCoordinates: inst.BinlogCoordinates{LogFile: path.Base(binlogPaths[0]), LogPos: startPosition, Type: binlogType},
+		},
+	}
+	for i, binlogPath := range binlogPaths {
+		filePosition := int64(0)
+		if i == 0 {
+			filePosition = startPosition
+		}
+		logFile := path.Base(binlogPath)
+		wantMore := true
+		err := inst.ScanBinlogEvents(binlogPath, filePosition, 0, func(event *inst.BinlogEvent) bool {
+			wantMore = reader.onEvent(logFile, event)
+			return wantMore
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !wantMore {
+			break
+		}
+	}
+	return &reader.result, nil
+}
+
+// ReadBinlogTransactions returns the complete transactions following given binary log or relay log coordinates,
+// up to a count or a byte budget (at least one transaction is returned when any is available). A transaction
+// in progress at the start coordinates is skipped, as is an incomplete transaction at the end of the logs.
+func (this *MySQLInstance) ReadBinlogTransactions(startCoordinates inst.BinlogCoordinates, maxCount int, maxBytes int64) (*BinlogTransactions, error) {
+	var binlogPaths []string
+	var err error
+	if startCoordinates.Type == inst.RelayLog {
+		binlogPaths, err = this.GetRelayLogFileNamesFrom(startCoordinates.LogFile)
+	} else {
+		binlogPaths, err = this.GetBinlogFileNamesFrom(startCoordinates.LogFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(binlogPaths) == 0 {
+		return nil, fmt.Errorf("Log file not found: %s", startCoordinates.LogFile)
+	}
+	return readBinlogTransactions(binlogPaths, startCoordinates.Type, startCoordinates.LogPos, maxCount, maxBytes)
+}
@@ -0,0 +1,114 @@
+package osagent
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+func testQueryEvent(sql string) []byte {
+	body := make([]byte, 13+1)
+	return testBinlogEvent(inst.QueryEvent, append(body, sql...))
+}
+
+func testGTIDEvent(gno uint64) []byte {
+	body := make([]byte, 42)
+	for i := 1; i < 17; i++ {
+		body[i] = 0x11
+	}
+	binary.LittleEndian.PutUint64(body[17:25], gno)
+	return testBinlogEvent(inst.GTIDEvent, body)
+}
+
+// testBinlogFileOf writes a binary log with a format description event followed by given events,
+// returning the start position of each of these events
+func testBinlogFileOf(t *testing.T, binlogPath string, events ...[]byte) (positions []int64) {
+	formatDescriptionBody := make([]byte, 2+50+4+1+27)
+	binary.LittleEndian.PutUint16(formatDescriptionBody[0:2], 4)
+	copy(formatDescriptionBody[2:], "5.5.40-log")
+	formatDescriptionBody[56] = 19
+	formatDescriptionBody[57+int(inst.QueryEvent)-1] = 13
+	binlog := append(append([]byte{}, inst.BinlogMagic...), testBinlogEvent(inst.FormatDescriptionEvent, formatDescriptionBody)...)
+	for _, event := range events {
+		positions = append(positions, int64(len(binlog)))
+		binlog = append(binlog, event...)
+	}
+	positions = append(positions, int64(len(binlog)))
+	if err := ioutil.WriteFile(binlogPath, binlog, 0644); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	return positions
+}
+
+func TestReadBinlogTransactions(t *testing.T) {
+	binlogDir, err := ioutil.TempDir("", "orchestrator-agent-test-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(binlogDir)
+
+	xid := testBinlogEvent(inst.XidEvent, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+	relaylog1 := path.Join(binlogDir, "relay-bin.000001")
+	relaylog2 := path.Join(binlogDir, "relay-bin.000002")
+	positions1 := testBinlogFileOf(t, relaylog1,
+		// tail of a transaction in progress at the start position
+		testQueryEvent("INSERT INTO t VALUES (1)"), xid,
+		testGTIDEvent(1), testQueryEvent("BEGIN"), testQueryEvent("INSERT INTO t VALUES (2)"), xid,
+		testGTIDEvent(2), testQueryEvent("CREATE TABLE u (id INT)"),
+		testQueryEvent("BEGIN"), testQueryEvent("INSERT INTO t VALUES (3)"),
+	)
+	positions2 := testBinlogFileOf(t, relaylog2,
+		testQueryEvent("COMMIT"),
+		// incomplete transaction at end of logs
+		testGTIDEvent(3), testQueryEvent("BEGIN"),
+	)
+	binlogPaths := []string{relaylog1, relaylog2}
+
+	result, err := readBinlogTransactions(binlogPaths, inst.RelayLog, positions1[0], 0, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(result.Transactions) != 3 {
+		t.Fatalf("Expected 3 transactions, got %+v", result.Transactions)
+	}
+	expected := []struct {
+		gtid  string
+		start inst.BinlogCoordinates
+		end   inst.BinlogCoordinates
+	}{
+		{"11111111-1111-1111-1111-111111111111:1", inst.BinlogCoordinates{LogFile: "relay-bin.000001", LogPos: positions1[2], Type: inst.RelayLog}, inst.BinlogCoordinates{LogFile: "relay-bin.000001", LogPos: positions1[6], Type: inst.RelayLog}},
+		{"11111111-1111-1111-1111-111111111111:2", inst.BinlogCoordinates{LogFile: "relay-bin.000001", LogPos: positions1[6], Type: inst.RelayLog}, inst.BinlogCoordinates{LogFile: "relay-bin.000001", LogPos: positions1[8], Type: inst.RelayLog}},
+		{"", inst.BinlogCoordinates{LogFile: "relay-bin.000001", LogPos: positions1[8], Type: inst.RelayLog}, inst.BinlogCoordinates{LogFile: "relay-bin.000002", LogPos: positions2[1], Type: inst.RelayLog}},
+	}
+	for i, transaction := range result.Transactions {
+		if transaction.GTID != expected[i].gtid || !transaction.StartCoordinates.Equals(&expected[i].start) || !transaction.EndCoordinates.Equals(&expected[i].end) {
+			t.Errorf("Transaction %d: expected %+v, got %+v", i, expected[i], transaction)
+		}
+	}
+	if !result.NextCoordinates.Equals(&expected[2].end) {
+		t.Errorf("Expected next coordinates %+v, got %+v", expected[2].end, result.NextCoordinates)
+	}
+
+	result, err = readBinlogTransactions(binlogPaths, inst.RelayLog, positions1[0], 2, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(result.Transactions) != 2 || !result.NextCoordinates.Equals(&expected[1].end) {
+		t.Errorf("Expected 2 transactions up to %+v, got %+v", expected[1].end, result)
+	}
+
+	firstSize := positions1[6] - positions1[2]
+	for _, maxBytes := range []int64{1, firstSize, firstSize + 1} {
+		result, err = readBinlogTransactions(binlogPaths, inst.RelayLog, positions1[0], 0, maxBytes)
+		if err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+		if len(result.Transactions) != 1 || result.Transactions[0].Size != firstSize {
+			t.Errorf("Byte budget %d: expected the first transaction only, got %+v", maxBytes, result.Transactions)
+		}
+	}
+}
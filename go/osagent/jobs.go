@@ -0,0 +1,162 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/outbrain/golib/log"
+)
+
+// Job tracks a long running, asynchronous operation such as a snapshot rollback
+type Job struct {
+	Id         string
+	Type       string
+	Target     string
+	StartTime  time.Time
+	EndTime    time.Time
+	Phase      string
+	Progress   float64
+	IsComplete bool
+	Succeeded  bool
+	Error      string
+	Details    interface{}
+
+	mutex *sync.Mutex
+}
+
+// completedJobRetention is the time completed jobs remain listed
+const completedJobRetention = 24 * time.Hour
+
+var jobs map[string](*Job) = make(map[string](*Job))
+var jobsMutex = &sync.Mutex{}
+var jobsCounter int64
+
+// pruneCompletedJobs forgets jobs completed longer than completedJobRetention ago. Expects jobsMutex to be held.
+func pruneCompletedJobs() {
+	for jobId, job := range jobs {
+		if state := job.State(); state.IsComplete && time.Since(state.EndTime) > completedJobRetention {
+			delete(jobs, jobId)
+		}
+	}
+}
+
+// NewJob registers a new job of given type, operating on given target (e.g. logical volume)
+func NewJob(jobType string, target string) *Job {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	pruneCompletedJobs()
+	jobsCounter++
+	job := &Job{
+		Id:        fmt.Sprintf("%s-%d-%d", jobType, time.Now().Unix(), jobsCounter),
+		Type:      jobType,
+		Target:    target,
+		StartTime: time.Now(),
+		mutex:     &sync.Mutex{},
+	}
+	jobs[job.Id] = job
+	return job
+}
+
+// Run executes given function asynchronously, marking the job complete when the function returns
+func (this *Job) Run(f func(job *Job) error) {
+	go func() {
+		err := f(this)
+
+		this.mutex.Lock()
+		defer this.mutex.Unlock()
+		this.EndTime = time.Now()
+		this.IsComplete = true
+		this.Succeeded = (err == nil)
+		if err == nil {
+			this.Progress = 100
+			log.Infof("Job %s completed", this.Id)
+		} else {
+			this.Error = err.Error()
+			log.Errorf("Job %s failed in phase %s: %+v", this.Id, this.Phase, err)
+		}
+	}()
+}
+
+// SetPhase describes the step the job is currently executing
+func (this *Job) SetPhase(phase string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	log.Debugf("Job %s: %s", this.Id, phase)
+	this.Phase = phase
+}
+
+// SetProgress sets job progress, in percent
+func (this *Job) SetProgress(progress float64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.Progress = progress
+}
+
+// SetDetails attaches job-type specific information to the job
+func (this *Job) SetDetails(details interface{}) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.Details = details
+}
+
+// State returns a copy of this job, safe for reading
+func (this *Job) State() Job {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return *this
+}
+
+// GetJob returns the state of a job by id
+func GetJob(jobId string) (Job, error) {
+	jobsMutex.Lock()
+	job, ok := jobs[jobId]
+	jobsMutex.Unlock()
+	if !ok {
+		return Job{}, fmt.Errorf("Job not found: %s", jobId)
+	}
+	return job.State(), nil
+}
+
+// Jobs lists all known jobs, optionally of given type only, ordered by start time
+func Jobs(jobType string) []Job {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	result := []Job{}
+	for _, job := range jobs {
+		if jobType == "" || job.Type == jobType {
+			result = append(result, job.State())
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].StartTime.Before(result[j].StartTime) })
+	return result
+}
+
+// JobInProgress returns true when a job of given type is running
+func JobInProgress(jobType string) bool {
+	for _, job := range Jobs(jobType) {
+		if !job.IsComplete {
+			return true
+		}
+	}
+	return false
+}
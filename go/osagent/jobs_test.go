@@ -0,0 +1,75 @@
+package osagent
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func waitForJob(t *testing.T, jobId string) Job {
+	for i := 0; i < 100; i++ {
+		if job, err := GetJob(jobId); err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		} else if job.IsComplete {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Job %s did not complete", jobId)
+	return Job{}
+}
+
+func TestJobRun(t *testing.T) {
+	release := make(chan bool)
+	job := NewJob("test-run", "target")
+	job.Run(func(job *Job) error {
+		job.SetPhase("work")
+		<-release
+		return nil
+	})
+	if !JobInProgress("test-run") {
+		t.Errorf("Expected job in progress")
+	}
+	close(release)
+	state := waitForJob(t, job.Id)
+	if !state.Succeeded || state.Progress != 100 || state.Error != "" {
+		t.Errorf("Unexpected job state: %+v", state)
+	}
+	if JobInProgress("test-run") {
+		t.Errorf("Unexpected job in progress")
+	}
+
+	failed := NewJob("test-run", "target")
+	failed.Run(func(job *Job) error {
+		job.SetPhase("fail")
+		return errors.New("failure")
+	})
+	state = waitForJob(t, failed.Id)
+	if state.Succeeded || state.Error != "failure" || state.Phase != "fail" {
+		t.Errorf("Unexpected job state: %+v", state)
+	}
+	if jobs := Jobs("test-run"); len(jobs) != 2 || jobs[0].Id != job.Id {
+		t.Errorf("Unexpected jobs: %+v", jobs)
+	}
+}
+
+func TestPruneCompletedJobs(t *testing.T) {
+	stale := NewJob("test-prune", "target")
+	stale.IsComplete = true
+	stale.EndTime = time.Now().Add(-completedJobRetention - time.Minute)
+	recent := NewJob("test-prune", "target")
+	recent.IsComplete = true
+	recent.EndTime = time.Now()
+	running := NewJob("test-prune", "target")
+	running.StartTime = time.Now().Add(-completedJobRetention - time.Hour)
+
+	NewJob("test-prune-trigger", "target")
+	if _, err := GetJob(stale.Id); err == nil {
+		t.Errorf("Expected stale job to be pruned")
+	}
+	for _, job := range []*Job{recent, running} {
+		if _, err := GetJob(job.Id); err != nil {
+			t.Errorf("Unexpected prune of job %s", job.Id)
+		}
+	}
+}
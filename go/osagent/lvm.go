@@ -0,0 +1,238 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// VolumeGroup describes an LVM volume group
+type VolumeGroup struct {
+	Name             string
+	Size             int64
+	Free             int64
+	ExtentSize       int64
+	ExtentCount      int64
+	FreeExtents      int64
+	AllocationPolicy string
+	LVCount          int64
+	SnapshotCount    int64
+	PhysicalVolumes  []string
+}
+
+// SnapshotFeasibility tells whether a snapshot of requested size can be created for the MySQL datadir's volume
+type SnapshotFeasibility struct {
+	DataDir         string
+	OriginLVPath    string
+	VolumeGroup     string
+	RequestedSize   int64
+	RequiredExtents int64
+	FreeExtents     int64
+	Free            int64
+	ThinPoolFree    int64
+	Feasible        bool
+	Reason          string
+}
+
+// vgsReport maps the output of `vgs --reportformat json`
+type vgsReport struct {
+	Report []struct {
+		VG []map[string]string `json:"vg"`
+	} `json:"report"`
+}
+
+const vgsReportFields = "vg_name,vg_size,vg_free,vg_extent_size,vg_extent_count,vg_free_count,vg_allocation_policy,lv_count,snap_count,pv_name"
+
+// parseVolumeGroupsReport parses `vgs --reportformat json` output, as produced with vgsReportFields.
+// vgs reports a row per physical volume; rows are aggregated per volume group.
+func parseVolumeGroupsReport(output []byte) ([]VolumeGroup, error) {
+	report := vgsReport{}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, fmt.Errorf("Cannot parse vgs report: %+v", err)
+	}
+	volumeGroups := []VolumeGroup{}
+	volumeGroupsIndexes := make(map[string]int)
+	for _, reportEntry := range report.Report {
+		for _, fields := range reportEntry.VG {
+			name := fields["vg_name"]
+			i, ok := volumeGroupsIndexes[name]
+			if !ok {
+				volumeGroup := VolumeGroup{
+					Name:             name,
+					AllocationPolicy: fields["vg_allocation_policy"],
+					PhysicalVolumes:  []string{},
+				}
+				volumeGroup.Size, _ = strconv.ParseInt(fields["vg_size"], 10, 0)
+				volumeGroup.Free, _ = strconv.ParseInt(fields["vg_free"], 10, 0)
+				volumeGroup.ExtentSize, _ = strconv.ParseInt(fields["vg_extent_size"], 10, 0)
+				volumeGroup.ExtentCount, _ = strconv.ParseInt(fields["vg_extent_count"], 10, 0)
+				volumeGroup.FreeExtents, _ = strconv.ParseInt(fields["vg_free_count"], 10, 0)
+				volumeGroup.LVCount, _ = strconv.ParseInt(fields["lv_count"], 10, 0)
+				volumeGroup.SnapshotCount, _ = strconv.ParseInt(fields["snap_count"], 10, 0)
+				volumeGroups = append(volumeGroups, volumeGroup)
+				i = len(volumeGroups) - 1
+				volumeGroupsIndexes[name] = i
+			}
+			if pvName := fields["pv_name"]; pvName != "" {
+				volumeGroups[i].PhysicalVolumes = append(volumeGroups[i].PhysicalVolumes, pvName)
+			}
+		}
+	}
+	return volumeGroups, nil
+}
+
+// VolumeGroups lists volume groups by given name (or all volume groups if empty)
+func VolumeGroups(groupName string) ([]VolumeGroup, error) {
+	output, err := commandOutput(sudoCmd(fmt.Sprintf("vgs --reportformat json --units b --nosuffix -o %s %s", vgsReportFields, groupName)))
+	if err != nil {
+		return nil, err
+	}
+	return parseVolumeGroupsReport(output)
+}
+
+// GetVolumeGroupFreeExtents returns the number of unallocated physical extents in given volume group
+func GetVolumeGroupFreeExtents(groupName string) (int64, error) {
+	volumeGroups, err := VolumeGroups(groupName)
+	if err != nil {
+		return 0, err
+	}
+	for _, volumeGroup := range volumeGroups {
+		return volumeGroup.FreeExtents, nil
+	}
+	return 0, fmt.Errorf("volume group not found: %s", groupName)
+}
+
+// ParseSize parses a size such as "10G", "512m" or "1073741824" into bytes. Suffixes are binary (K=1024), as with LVM.
+func ParseSize(size string) (int64, error) {
+	size = strings.TrimSpace(size)
+	multiplier := int64(1)
+	if size != "" {
+		switch strings.ToUpper(size[len(size)-1:]) {
+		case "B":
+			size = size[:len(size)-1]
+		case "K":
+			multiplier = 1 << 10
+		case "M":
+			multiplier = 1 << 20
+		case "G":
+			multiplier = 1 << 30
+		case "T":
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			size = size[:len(size)-1]
+		}
+	}
+	value, err := strconv.ParseFloat(size, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+		return 0, fmt.Errorf("Cannot parse size: %s", size)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// GetMySQLDataDirLogicalVolume returns the logical volume on which the MySQL datadir resides
+func (this *MySQLInstance) GetMySQLDataDirLogicalVolume() (*LogicalVolume, error) {
+	directory, err := this.GetMySQLDataDir()
+	if err != nil {
+		return nil, err
+	}
+	mount, err := FindMountOf(directory)
+	if err != nil {
+		return nil, err
+	}
+	volumeName := mount.LVPath
+	if volumeName == "" {
+		volumeName = mount.Device
+	}
+	logicalVolumes, err := LogicalVolumes(volumeName, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(logicalVolumes) == 0 {
+		return nil, fmt.Errorf("Cannot find logical volume of datadir %s", directory)
+	}
+	return &logicalVolumes[0], nil
+}
+
+// GetSnapshotFeasibility checks whether a snapshot of given size could be created right now for the
+// logical volume on which the MySQL datadir resides
+func GetSnapshotFeasibility(size int64) (*SnapshotFeasibility, error) {
+	feasibility := &SnapshotFeasibility{RequestedSize: size}
+	var err error
+	if feasibility.DataDir, err = GetMySQLDataDir(); err != nil {
+		return nil, err
+	}
+	origin, err := GetMySQLDataDirLogicalVolume()
+	if err != nil {
+		return nil, err
+	}
+	feasibility.OriginLVPath = origin.Path
+	feasibility.VolumeGroup = origin.GroupName
+
+	volumeGroups, err := VolumeGroups(origin.GroupName)
+	if err != nil {
+		return nil, err
+	}
+	if len(volumeGroups) == 0 {
+		return nil, fmt.Errorf("Cannot find volume group %s", origin.GroupName)
+	}
+	volumeGroup := volumeGroups[0]
+	feasibility.Free = volumeGroup.Free
+	feasibility.FreeExtents = volumeGroup.FreeExtents
+	if volumeGroup.ExtentSize > 0 {
+		feasibility.RequiredExtents = (size + volumeGroup.ExtentSize - 1) / volumeGroup.ExtentSize
+	}
+
+	var thinPool *LogicalVolume
+	if origin.ThinPool != "" {
+		pools, err := readLogicalVolumes(origin.GroupName + "/" + origin.ThinPool)
+		if err != nil {
+			return nil, err
+		}
+		if len(pools) == 0 {
+			return nil, fmt.Errorf("Cannot find thin pool %s/%s", origin.GroupName, origin.ThinPool)
+		}
+		thinPool = &pools[0]
+	}
+	decideSnapshotFeasibility(feasibility, origin, thinPool)
+	return feasibility, nil
+}
+
+// decideSnapshotFeasibility fills in Feasible and Reason. A thin snapshot allocates no extents up front, but
+// fills its pool as the origin changes: it is feasible when the pool's unused data space covers the requested size.
+func decideSnapshotFeasibility(feasibility *SnapshotFeasibility, origin *LogicalVolume, thinPool *LogicalVolume) {
+	switch {
+	case origin.IsSnapshot:
+		feasibility.Reason = fmt.Sprintf("%s is itself a snapshot", origin.Path)
+	case thinPool != nil:
+		feasibility.ThinPoolFree = int64(float64(thinPool.Size) * (100 - thinPool.DataPercent) / 100)
+		if feasibility.RequestedSize > feasibility.ThinPoolFree {
+			feasibility.Reason = fmt.Sprintf("thin pool %s is %.2f%% full, with %d bytes free; %d requested", thinPool.Path, thinPool.DataPercent, feasibility.ThinPoolFree, feasibility.RequestedSize)
+		} else {
+			feasibility.Feasible = true
+			feasibility.Reason = fmt.Sprintf("%s is thin provisioned in pool %s; snapshot allocates from the pool", origin.Path, thinPool.Path)
+		}
+	case feasibility.RequiredExtents > feasibility.FreeExtents:
+		feasibility.Reason = fmt.Sprintf("volume group %s has %d free extents; %d required", feasibility.VolumeGroup, feasibility.FreeExtents, feasibility.RequiredExtents)
+	default:
+		feasibility.Feasible = true
+	}
+}
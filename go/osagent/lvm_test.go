@@ -0,0 +1,81 @@
+package osagent
+
+import (
+	"testing"
+)
+
+const testVgsReport = `
+  {
+      "report": [
+          {
+              "vg": [
+                  {"vg_name":"vg00", "vg_size":"214748364800", "vg_free":"21474836480", "vg_extent_size":"4194304", "vg_extent_count":"51200", "vg_free_count":"5120", "vg_allocation_policy":"normal", "lv_count":"3", "snap_count":"1", "pv_name":"/dev/sda2"},
+                  {"vg_name":"vg00", "vg_size":"214748364800", "vg_free":"21474836480", "vg_extent_size":"4194304", "vg_extent_count":"51200", "vg_free_count":"5120", "vg_allocation_policy":"normal", "lv_count":"3", "snap_count":"1", "pv_name":"/dev/sdb1"},
+                  {"vg_name":"vg01", "vg_size":"107374182400", "vg_free":"0", "vg_extent_size":"4194304", "vg_extent_count":"25600", "vg_free_count":"0", "vg_allocation_policy":"normal", "lv_count":"1", "snap_count":"0", "pv_name":"/dev/sdc1"}
+              ]
+          }
+      ]
+  }
+`
+
+func TestParseVolumeGroupsReport(t *testing.T) {
+	volumeGroups, err := parseVolumeGroupsReport([]byte(testVgsReport))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(volumeGroups) != 2 {
+		t.Fatalf("Expected 2 volume groups, got %d", len(volumeGroups))
+	}
+	if vg := volumeGroups[0]; vg.Name != "vg00" || len(vg.PhysicalVolumes) != 2 || vg.FreeExtents != 5120 || vg.Free != 21474836480 || vg.SnapshotCount != 1 {
+		t.Errorf("Unexpected volume group: %+v", vg)
+	}
+	if vg := volumeGroups[1]; vg.Name != "vg01" || len(vg.PhysicalVolumes) != 1 || vg.FreeExtents != 0 {
+		t.Errorf("Unexpected volume group: %+v", vg)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"1073741824": 1073741824,
+		"512b":       512,
+		"10K":        10240,
+		"1.5g":       1610612736,
+		"2T":         2199023255552,
+	}
+	for size, expected := range tests {
+		if parsed, err := ParseSize(size); err != nil || parsed != expected {
+			t.Errorf("ParseSize(%s): expected %d, got %d, %+v", size, expected, parsed, err)
+		}
+	}
+	for _, size := range []string{"", "G", "ten", "-5M", "NaN", "Inf", "-Inf", "infG", "nanM"} {
+		if _, err := ParseSize(size); err == nil {
+			t.Errorf("ParseSize(%s): expected error", size)
+		}
+	}
+}
+
+func TestDecideSnapshotFeasibility(t *testing.T) {
+	thick := &LogicalVolume{Path: "/dev/vg00/data"}
+	thin := &LogicalVolume{Path: "/dev/vg00/data", ThinPool: "pool0"}
+	pool := &LogicalVolume{Path: "/dev/vg00/pool0", Size: 100 << 30, DataPercent: 90}
+	tests := []struct {
+		origin        *LogicalVolume
+		thinPool      *LogicalVolume
+		requestedSize int64
+		freeExtents   int64
+		expected      bool
+	}{
+		{&LogicalVolume{Path: "/dev/vg00/snap", IsSnapshot: true}, nil, 1 << 30, 1000, false},
+		{thick, nil, 1 << 30, 1000, true},
+		{thick, nil, 1 << 30, 10, false},
+		{thin, pool, 5 << 30, 0, true},
+		{thin, pool, 20 << 30, 0, false},
+	}
+	for i, test := range tests {
+		feasibility := &SnapshotFeasibility{RequestedSize: test.requestedSize, RequiredExtents: 256, FreeExtents: test.freeExtents}
+		decideSnapshotFeasibility(feasibility, test.origin, test.thinPool)
+		if feasibility.Feasible != test.expected {
+			t.Errorf("Unexpected feasibility in test %d: %+v", i, feasibility)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/orchestrator-agent/go/config"
+)
+
+const mountInfoFile = "/proc/self/mountinfo"
+
+// FileSystemCheck is the result of a no-modify file system check
+type FileSystemCheck struct {
+	Command string
+	Passed  bool
+	Output  string
+}
+
+// unescapeMountInfoField decodes octal escapes (e.g. `\040` for space) used in mountinfo paths
+func unescapeMountInfoField(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	result := []byte{}
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if value, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				result = append(result, byte(value))
+				i += 3
+				continue
+			}
+		}
+		result = append(result, field[i])
+	}
+	return string(result)
+}
+
+// parseMountInfo parses /proc/<pid>/mountinfo format, where each line reads like:
+// `36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue`
+func parseMountInfo(reader io.Reader) ([]Mount, error) {
+	mounts := []Mount{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		tokens := strings.Fields(scanner.Text())
+		if len(tokens) == 0 {
+			continue
+		}
+		separatorIndex := -1
+		for i := 6; i < len(tokens); i++ {
+			if tokens[i] == "-" {
+				separatorIndex = i
+				break
+			}
+		}
+		if separatorIndex < 0 || separatorIndex+2 >= len(tokens) {
+			return mounts, fmt.Errorf("Cannot parse mountinfo line: %s", scanner.Text())
+		}
+		mount := Mount{
+			Path:       unescapeMountInfoField(tokens[4]),
+			MajorMinor: tokens[2],
+			Root:       unescapeMountInfoField(tokens[3]),
+			Options:    tokens[5],
+			FileSystem: tokens[separatorIndex+1],
+			Device:     unescapeMountInfoField(tokens[separatorIndex+2]),
+			IsMounted:  true,
+		}
+		for _, option := range strings.Split(mount.Options, ",") {
+			if option == "ro" {
+				mount.ReadOnly = true
+			}
+		}
+		if separatorIndex+3 < len(tokens) {
+			mount.SuperOptions = tokens[separatorIndex+3]
+		}
+		for _, option := range strings.Split(mount.SuperOptions, ",") {
+			if option == "norecovery" || option == "noload" {
+				mount.NoRecovery = true
+			}
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts, scanner.Err()
+}
+
+// deviceMapperLVPath translates a device mapper name (e.g. `vg00-mysql--data`) into the logical volume
+// path (`/dev/vg00/mysql-data`). Hyphens within VG and LV names are doubled in device mapper names.
+// dmUUID is the device's device mapper UUID: only devices created by LVM (UUID prefixed by `LVM-`) are
+// logical volumes. An empty string is returned when the name does not map to a logical volume.
+func deviceMapperLVPath(dmName string, dmUUID string) string {
+	if !strings.HasPrefix(dmUUID, "LVM-") {
+		return ""
+	}
+	for i := 0; i < len(dmName); i++ {
+		if dmName[i] != '-' {
+			continue
+		}
+		if i+1 < len(dmName) && dmName[i+1] == '-' {
+			i++
+			continue
+		}
+		groupName := strings.Replace(dmName[:i], "--", "-", -1)
+		volumeName := strings.Replace(dmName[i+1:], "--", "-", -1)
+		if groupName == "" || volumeName == "" {
+			return ""
+		}
+		return path.Join("/dev", groupName, volumeName)
+	}
+	return ""
+}
+
+// mountLVPath attempts to find the logical volume path of a mounted device,
+// based on its device mapper name and UUID
+func mountLVPath(mount *Mount) string {
+	dmUUID, err := ioutil.ReadFile(fmt.Sprintf("/sys/dev/block/%s/dm/uuid", mount.MajorMinor))
+	if err != nil {
+		// Not a device mapper device
+		return ""
+	}
+	if strings.HasPrefix(mount.Device, "/dev/mapper/") {
+		return deviceMapperLVPath(strings.TrimPrefix(mount.Device, "/dev/mapper/"), strings.TrimSpace(string(dmUUID)))
+	}
+	if dmName, err := ioutil.ReadFile(fmt.Sprintf("/sys/dev/block/%s/dm/name", mount.MajorMinor)); err == nil {
+		return deviceMapperLVPath(strings.TrimSpace(string(dmName)), strings.TrimSpace(string(dmUUID)))
+	}
+	return ""
+}
+
+// applyFileSystemUsage reads size and usage of a mounted file system
+func applyFileSystemUsage(mount *Mount) error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(mount.Path, &stat); err != nil {
+		return err
+	}
+	blockSize := int64(stat.Bsize)
+	mount.Size = int64(stat.Blocks) * blockSize
+	mount.Available = int64(stat.Bavail) * blockSize
+	mount.Used = (int64(stat.Blocks) - int64(stat.Bfree)) * blockSize
+	return nil
+}
+
+// readMounts lists all mounted file systems, without their usage
+func readMounts() ([]Mount, error) {
+	file, err := os.Open(mountInfoFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mounts, err := parseMountInfo(file)
+	if err != nil {
+		return nil, err
+	}
+	for i := range mounts {
+		mounts[i].LVPath = mountLVPath(&mounts[i])
+	}
+	return mounts, nil
+}
+
+// Mounts lists all mounted file systems, along with their usage
+func Mounts() ([]Mount, error) {
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, err
+	}
+	for i := range mounts {
+		applyFileSystemUsage(&mounts[i])
+	}
+	return mounts, nil
+}
+
+// FindMount returns the file system mounted exactly on given mount point, or nil when nothing is mounted there.
+// With stacked mounts, the topmost (last) mount is returned.
+func FindMount(mountPoint string) (*Mount, error) {
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, err
+	}
+	mountPoint = path.Clean(mountPoint)
+	var result *Mount
+	for i := range mounts {
+		if mounts[i].Path == mountPoint {
+			result = &mounts[i]
+		}
+	}
+	if result != nil {
+		applyFileSystemUsage(result)
+	}
+	return result, nil
+}
+
+// FindMountOf returns the file system on which given path resides: the mount with longest mount point
+// that is a prefix of the path
+func FindMountOf(fileName string) (*Mount, error) {
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, err
+	}
+	fileName = path.Clean(fileName)
+	var result *Mount
+	for i := range mounts {
+		mountPoint := mounts[i].Path
+		if fileName != mountPoint && mountPoint != "/" && !strings.HasPrefix(fileName, mountPoint+"/") {
+			continue
+		}
+		if result == nil || len(mountPoint) >= len(result.Path) {
+			result = &mounts[i]
+		}
+	}
+	if result == nil {
+		return nil, fmt.Errorf("Cannot find mount of %s", fileName)
+	}
+	applyFileSystemUsage(result)
+	return result, nil
+}
+
+// MountOptions returns the `-o` mount argument for given file system type: the configured SnapshotMountOptions,
+// plus read-only options if so requested. norecovery asks to skip log recovery, XFS norecovery or ext3/ext4
+// noload (read-only mounts only). Skipping recovery on a crash-consistent snapshot gives an inconsistent view
+// of the file system, so it is only meant as a fallback.
+func MountOptions(fsType string, readOnly bool, norecovery bool) string {
+	options := []string{}
+	if readOnly {
+		options = append(options, "ro")
+		if norecovery {
+			switch fsType {
+			case "xfs":
+				options = append(options, "norecovery")
+			case "ext3", "ext4":
+				options = append(options, "noload")
+			}
+		}
+	}
+	if configuredOptions := config.Config.SnapshotMountOptions[fsType]; configuredOptions != "" {
+		options = append(options, configuredOptions)
+	}
+	if len(options) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("-o %s", strings.Join(options, ","))
+}
+
+// CheckFileSystem runs a no-modify file system check on given volume (xfs_repair -n, e2fsck -n)
+func CheckFileSystem(volumeName string, fsType string) (*FileSystemCheck, error) {
+	fileSystemCheck := &FileSystemCheck{}
+	switch fsType {
+	case "xfs":
+		fileSystemCheck.Command = fmt.Sprintf("xfs_repair -n %s", volumeName)
+	case "ext2", "ext3", "ext4":
+		fileSystemCheck.Command = fmt.Sprintf("e2fsck -n -f %s", volumeName)
+	default:
+		return nil, fmt.Errorf("Unsupported file system for check: %s", fsType)
+	}
+	output, err := commandCombinedOutput(sudoCmd(fileSystemCheck.Command))
+	fileSystemCheck.Passed = (err == nil)
+	fileSystemCheck.Output = string(output)
+	if !fileSystemCheck.Passed {
+		log.Warningf("File system check failed on %s: %s", volumeName, fileSystemCheck.Output)
+	}
+	return fileSystemCheck, nil
+}
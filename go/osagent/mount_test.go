@@ -0,0 +1,91 @@
+package osagent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/outbrain/orchestrator-agent/go/config"
+)
+
+const testMountInfo = `22 1 253:0 / / rw,relatime shared:1 - xfs /dev/mapper/centos-root rw,attr2,inode64,noquota
+40 22 8:1 / /boot rw,relatime shared:25 - xfs /dev/sda1 rw,attr2,inode64,noquota
+45 22 253:3 / /var/lib/mysql rw,noatime shared:30 - ext4 /dev/mapper/vg00-mysql--data rw,data=ordered
+46 22 0:38 / /tmp rw,nosuid,nodev shared:31 - tmpfs tmpfs rw
+47 46 253:5 / /tmp/foo rw,relatime shared:32 master:1 - xfs /dev/mapper/vg00-mysql--data--snap rw,nouuid
+48 22 0:40 / /mnt/with\040space ro,relatime - nfs4 server:/export rw,vers=4.1
+49 22 253:6 / /mnt/snap ro,relatime - ext4 /dev/mapper/vg00-mysql--snap ro,norecovery
+`
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := parseMountInfo(strings.NewReader(testMountInfo))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(mounts) != 7 {
+		t.Fatalf("Expected 7 mounts, got %d", len(mounts))
+	}
+	mysql := mounts[2]
+	if mysql.Path != "/var/lib/mysql" || mysql.Device != "/dev/mapper/vg00-mysql--data" || mysql.FileSystem != "ext4" || mysql.MajorMinor != "253:3" || mysql.Options != "rw,noatime" || mysql.SuperOptions != "rw,data=ordered" {
+		t.Errorf("Unexpected mount: %+v", mysql)
+	}
+	snapshot := mounts[4]
+	if snapshot.Path != "/tmp/foo" || snapshot.FileSystem != "xfs" || snapshot.SuperOptions != "rw,nouuid" {
+		t.Errorf("Unexpected mount with optional fields: %+v", snapshot)
+	}
+	if mounts[5].Path != "/mnt/with space" {
+		t.Errorf("Unexpected unescaped path: %s", mounts[5].Path)
+	}
+	if snapshot.NoRecovery || !mounts[6].NoRecovery || !mounts[6].ReadOnly {
+		t.Errorf("Unexpected log recovery state: %+v, %+v", snapshot, mounts[6])
+	}
+}
+
+func TestDeviceMapperLVPath(t *testing.T) {
+	const lvmUUID = "LVM-kX3fWbWn0qHq2Y7c8m0CdyzFqvX1ZpFqOlK2yq9sW4C0r5bTmXk7m8d0XfZ0aQ1r"
+	tests := map[string]string{
+		"vg00-mysql":               "/dev/vg00/mysql",
+		"vg00-mysql--data":         "/dev/vg00/mysql-data",
+		"my--vg-mysql--data--snap": "/dev/my-vg/mysql-data-snap",
+		"centos-root":              "/dev/centos/root",
+		"nohyphen":                 "",
+		"trailing-":                "",
+	}
+	for dmName, expected := range tests {
+		if lvPath := deviceMapperLVPath(dmName, lvmUUID); lvPath != expected {
+			t.Errorf("deviceMapperLVPath(%s): expected %s, got %s", dmName, expected, lvPath)
+		}
+	}
+	if lvPath := deviceMapperLVPath("luks-crypt", "CRYPT-LUKS2-3e1a5b7c9d0f4e2a8b6c1d3e5f7a9b0c-luks-crypt"); lvPath != "" {
+		t.Errorf("Unexpected logical volume path of LUKS device: %s", lvPath)
+	}
+	if lvPath := deviceMapperLVPath("vg00-mysql", ""); lvPath != "" {
+		t.Errorf("Unexpected logical volume path of device without UUID: %s", lvPath)
+	}
+}
+
+func TestMountOptions(t *testing.T) {
+	defer func(options map[string]string) {
+		config.Config.SnapshotMountOptions = options
+	}(config.Config.SnapshotMountOptions)
+	config.Config.SnapshotMountOptions = map[string]string{"xfs": "nouuid"}
+
+	tests := []struct {
+		fsType     string
+		readOnly   bool
+		norecovery bool
+		expected   string
+	}{
+		{"xfs", false, false, "-o nouuid"},
+		{"xfs", true, false, "-o ro,nouuid"},
+		{"xfs", true, true, "-o ro,norecovery,nouuid"},
+		{"ext4", true, false, "-o ro"},
+		{"ext4", true, true, "-o ro,noload"},
+		{"ext3", false, true, ""},
+		{"ext4", false, false, ""},
+	}
+	for _, test := range tests {
+		if options := MountOptions(test.fsType, test.readOnly, test.norecovery); options != test.expected {
+			t.Errorf("MountOptions(%s, %t, %t): expected %q, got %q", test.fsType, test.readOnly, test.norecovery, test.expected, options)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const maxMySQLConfigIncludeDepth = 10
+
+// isMySQLServerConfigSection returns true for my.cnf sections read by mysqld
+func isMySQLServerConfigSection(section string) bool {
+	return section == "mysqld" || section == "server" || strings.HasPrefix(section, "mysqld-") || strings.HasPrefix(section, "mariadb")
+}
+
+// parseMySQLConfig reads my.cnf formatted content into given options map, for server sections only.
+// Option names are normalized to use dashes; options given without a value map to an empty string.
+// Include directives are resolved via given function.
+func parseMySQLConfig(reader io.Reader, options map[string]string, include func(directive string, target string) error) error {
+	section := ""
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "!include"):
+			tokens := strings.Fields(line)
+			if len(tokens) == 2 {
+				if err := include(tokens[0], tokens[1]); err != nil {
+					return err
+				}
+			}
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+		if !isMySQLServerConfigSection(section) {
+			continue
+		}
+		tokens := strings.SplitN(line, "=", 2)
+		name := strings.Replace(strings.TrimSpace(tokens[0]), "_", "-", -1)
+		value := ""
+		if len(tokens) == 2 {
+			value = strings.TrimSpace(tokens[1])
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+			value = strings.Trim(value, `"'`)
+		}
+		options[name] = value
+	}
+	return scanner.Err()
+}
+
+// readMySQLConfigFile reads server options from a my.cnf file, following !include and !includedir
+func readMySQLConfigFile(configFile string, options map[string]string, depth int) error {
+	if depth > maxMySQLConfigIncludeDepth {
+		return fmt.Errorf("Too many nested includes at %s", configFile)
+	}
+	file, err := os.Open(configFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return parseMySQLConfig(file, options, func(directive string, target string) error {
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(configFile), target)
+		}
+		if directive == "!include" {
+			return readMySQLConfigFile(target, options, depth+1)
+		}
+		includedFiles, _ := filepath.Glob(path.Join(target, "*.cnf"))
+		for _, includedFile := range includedFiles {
+			if err := readMySQLConfigFile(includedFile, options, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetMySQLServerOptions reads the server options in MySQLConfigFile, which is available whether or not
+// MySQL is running
+func (this *MySQLInstance) GetMySQLServerOptions() (map[string]string, error) {
+	options := make(map[string]string)
+	err := readMySQLConfigFile(this.Config.MySQLConfigFile, options, 0)
+	return options, err
+}
@@ -0,0 +1,80 @@
+package osagent
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestReadMySQLConfigFile(t *testing.T) {
+	configDir, err := ioutil.TempDir("", "orchestrator-agent-test-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(configDir)
+
+	files := map[string]string{
+		"my.cnf": `
+[client]
+port = 3307
+
+[mysqld]
+datadir = /var/lib/mysql  # data
+log_bin = mysql-bin.log
+skip-name-resolve
+!includedir conf.d
+`,
+		"conf.d/binlog.cnf": `
+[mysqld-8.0]
+log-bin-index = "/var/log/mysql/binlogs.index"
+[mysqldump]
+log-bin = ignored
+`,
+	}
+	for fileName, content := range files {
+		filePath := path.Join(configDir, fileName)
+		os.MkdirAll(path.Dir(filePath), 0755)
+		if err := ioutil.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+	}
+
+	options := make(map[string]string)
+	if err := readMySQLConfigFile(path.Join(configDir, "my.cnf"), options, 0); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	expected := map[string]string{
+		"datadir":           "/var/lib/mysql",
+		"log-bin":           "mysql-bin.log",
+		"skip-name-resolve": "",
+		"log-bin-index":     "/var/log/mysql/binlogs.index",
+	}
+	if len(options) != len(expected) {
+		t.Errorf("Expected %+v, got %+v", expected, options)
+	}
+	for name, value := range expected {
+		if options[name] != value {
+			t.Errorf("Expected %s=%s, got %s", name, value, options[name])
+		}
+	}
+}
+
+func TestBinlogIndexFileFromOptions(t *testing.T) {
+	tests := []struct {
+		options  map[string]string
+		expected string
+	}{
+		{map[string]string{}, ""},
+		{map[string]string{"log-bin": ""}, ""},
+		{map[string]string{"log-bin": "mysql-bin"}, "/data/mysql-bin.index"},
+		{map[string]string{"log-bin": "mysql-bin.log"}, "/data/mysql-bin.index"},
+		{map[string]string{"log-bin": "/logs/mysql-bin"}, "/logs/mysql-bin.index"},
+		{map[string]string{"log-bin": "mysql-bin", "log-bin-index": "binlogs.idx"}, "/data/binlogs.idx"},
+	}
+	for _, test := range tests {
+		if indexFile := binlogIndexFileFromOptions(test.options, "/data"); indexFile != test.expected {
+			t.Errorf("Options %+v: expected %s, got %s", test.options, test.expected, indexFile)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/orchestrator-agent/go/config"
+)
+
+const largestTablesCount = 20
+
+var partitionSuffixPattern = regexp.MustCompile(`#[pP]#.*$`)
+
+// SchemaDiskUsage is the disk usage of a single schema directory
+type SchemaDiskUsage struct {
+	Schema string
+	Files  int64
+	Bytes  int64
+}
+
+// TableDiskUsage is the disk usage of a single table's tablespace files (.ibd), partitions included
+type TableDiskUsage struct {
+	Schema string
+	Table  string
+	Bytes  int64
+}
+
+// MySQLDiskUsage is a breakdown of the MySQL datadir's disk usage
+type MySQLDiskUsage struct {
+	DataDir          string
+	Total            int64
+	Schemas          []SchemaDiskUsage
+	LargestTables    []TableDiskUsage
+	SystemTablespace int64
+	RedoLogs         int64
+	UndoLogs         int64
+	TemporaryFiles   int64
+	Binlogs          int64
+	RelayLogs        int64
+	Other            int64
+	ComputedAt       time.Time
+	ComputeSeconds   float64
+}
+
+// Cached disk usage and refresh state, by MySQL instance name
+var mySQLDiskUsageCache = make(map[string]*MySQLDiskUsage)
+var mySQLDiskUsageRefreshing = make(map[string]bool)
+var mySQLDiskUsageMutex = &sync.Mutex{}
+
+// dataDirFile is a file within the datadir, by path relative to the datadir
+type dataDirFile struct {
+	RelativePath string
+	Size         int64
+}
+
+// walkDataDirFiles lists the files under given datadir natively, as the agent's OS user
+func walkDataDirFiles(dataDir string) ([]dataDirFile, error) {
+	files := []dataDirFile{}
+	err := filepath.Walk(dataDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relativePath, err := filepath.Rel(dataDir, filePath)
+		if err != nil {
+			return err
+		}
+		files = append(files, dataDirFile{RelativePath: relativePath, Size: info.Size()})
+		return nil
+	})
+	return files, err
+}
+
+// parseFindFilesOutput parses the output of `find -type f -printf '%s %P\n'`: size and relative path per line
+func parseFindFilesOutput(output []byte) ([]dataDirFile, error) {
+	files := []dataDirFile{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if line == "" {
+			continue
+		}
+		tokens := strings.SplitN(line, " ", 2)
+		if len(tokens) != 2 {
+			return nil, fmt.Errorf("Cannot parse find output line: %s", line)
+		}
+		size, err := strconv.ParseInt(tokens[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse find output line: %s", line)
+		}
+		files = append(files, dataDirFile{RelativePath: tokens[1], Size: size})
+	}
+	return files, nil
+}
+
+// findDataDirFiles lists the files under given datadir with find, via sudo when ExecWithSudo is set,
+// as the datadir is typically not readable by the agent's OS user
+func findDataDirFiles(dataDir string) ([]dataDirFile, error) {
+	output, err := commandOutput(sudoCmd(fmt.Sprintf(`find %s -type f -printf '%%s %%P\n'`, dataDir)))
+	if err != nil {
+		return nil, err
+	}
+	return parseFindFilesOutput(output)
+}
+
+// readDataDirFile reads a file within the datadir, via sudo when ExecWithSudo is set
+func readDataDirFile(filePath string) ([]byte, error) {
+	if config.Config.ExecWithSudo {
+		return commandOutput(sudoCmd(fmt.Sprintf("cat %s", filePath)))
+	}
+	return ioutil.ReadFile(filePath)
+}
+
+// indexedLogFiles returns the names of files listed in the binary log and relay log index files
+// found at the top of the datadir
+func indexedLogFiles(dataDir string, files []dataDirFile) (binlogs map[string]bool, relayLogs map[string]bool) {
+	binlogs = make(map[string]bool)
+	relayLogs = make(map[string]bool)
+	for _, file := range files {
+		indexFile := file.RelativePath
+		if strings.Contains(indexFile, string(filepath.Separator)) || !strings.HasSuffix(indexFile, ".index") {
+			continue
+		}
+		logFiles := binlogs
+		if strings.Contains(indexFile, "relay") {
+			logFiles = relayLogs
+		}
+		logFiles[indexFile] = true
+		contents, err := readDataDirFile(path.Join(dataDir, indexFile))
+		if err != nil {
+			continue
+		}
+		for _, fileName := range strings.Split(string(contents), "\n") {
+			if fileName = strings.TrimSpace(fileName); fileName != "" {
+				logFiles[path.Base(fileName)] = true
+			}
+		}
+	}
+	return binlogs, relayLogs
+}
+
+// addTopLevelFile accounts for a file directly under the datadir
+func (this *MySQLDiskUsage) addTopLevelFile(name string, size int64, binlogs map[string]bool, relayLogs map[string]bool) {
+	switch {
+	case binlogs[name]:
+		this.Binlogs += size
+	case relayLogs[name]:
+		this.RelayLogs += size
+	case strings.HasPrefix(name, "ibdata"):
+		this.SystemTablespace += size
+	case strings.HasPrefix(name, "ib_logfile"):
+		this.RedoLogs += size
+	case strings.HasPrefix(name, "undo_") || strings.HasSuffix(name, ".ibu"):
+		this.UndoLogs += size
+	case strings.HasPrefix(name, "ibtmp"):
+		this.TemporaryFiles += size
+	default:
+		this.Other += size
+	}
+}
+
+// addInternalDirectoryFile accounts for a file under one of InnoDB's #-prefixed directories
+func (this *MySQLDiskUsage) addInternalDirectoryFile(directory string, size int64) {
+	switch directory {
+	case "#innodb_redo":
+		this.RedoLogs += size
+	case "#innodb_temp":
+		this.TemporaryFiles += size
+	default:
+		this.Other += size
+	}
+}
+
+// ComputeMySQLDiskUsage lists the files of given MySQL data directory and breaks its disk usage down by schema,
+// largest tables, and InnoDB system files and logs. Sizes are apparent file sizes, as with du -b. With
+// ExecWithSudo, files are listed by find through sudo; otherwise the directory is walked natively.
+func ComputeMySQLDiskUsage(dataDir string) (*MySQLDiskUsage, error) {
+	startTime := time.Now()
+	var files []dataDirFile
+	var err error
+	if config.Config.ExecWithSudo {
+		files, err = findDataDirFiles(dataDir)
+	} else {
+		files, err = walkDataDirFiles(dataDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &MySQLDiskUsage{DataDir: dataDir}
+	binlogs, relayLogs := indexedLogFiles(dataDir, files)
+	schemas := make(map[string]*SchemaDiskUsage)
+	tables := make(map[string]*TableDiskUsage)
+
+	for _, file := range files {
+		size := file.Size
+		usage.Total += size
+
+		tokens := strings.SplitN(file.RelativePath, string(filepath.Separator), 2)
+		if len(tokens) == 1 {
+			usage.addTopLevelFile(tokens[0], size, binlogs, relayLogs)
+			continue
+		}
+		directory := tokens[0]
+		if strings.HasPrefix(directory, "#") {
+			usage.addInternalDirectoryFile(directory, size)
+			continue
+		}
+		schema, ok := schemas[directory]
+		if !ok {
+			schema = &SchemaDiskUsage{Schema: directory}
+			schemas[directory] = schema
+		}
+		schema.Files++
+		schema.Bytes += size
+
+		if strings.HasSuffix(tokens[1], ".ibd") {
+			tableName := partitionSuffixPattern.ReplaceAllString(strings.TrimSuffix(tokens[1], ".ibd"), "")
+			tableKey := directory + "." + tableName
+			table, ok := tables[tableKey]
+			if !ok {
+				table = &TableDiskUsage{Schema: directory, Table: tableName}
+				tables[tableKey] = table
+			}
+			table.Bytes += size
+		}
+	}
+
+	usage.Schemas = []SchemaDiskUsage{}
+	for _, schema := range schemas {
+		usage.Schemas = append(usage.Schemas, *schema)
+	}
+	sort.Slice(usage.Schemas, func(i, j int) bool { return usage.Schemas[i].Bytes > usage.Schemas[j].Bytes })
+
+	usage.LargestTables = []TableDiskUsage{}
+	for _, table := range tables {
+		usage.LargestTables = append(usage.LargestTables, *table)
+	}
+	sort.Slice(usage.LargestTables, func(i, j int) bool { return usage.LargestTables[i].Bytes > usage.LargestTables[j].Bytes })
+	if len(usage.LargestTables) > largestTablesCount {
+		usage.LargestTables = usage.LargestTables[:largestTablesCount]
+	}
+
+	usage.ComputedAt = time.Now()
+	usage.ComputeSeconds = time.Since(startTime).Seconds()
+	return usage, nil
+}
+
+// refreshMySQLDiskUsage recomputes the datadir disk usage into the cache
+func (this *MySQLInstance) refreshMySQLDiskUsage() (*MySQLDiskUsage, error) {
+	defer func() {
+		mySQLDiskUsageMutex.Lock()
+		mySQLDiskUsageRefreshing[this.Name] = false
+		mySQLDiskUsageMutex.Unlock()
+	}()
+	dataDir, err := this.GetMySQLDataDir()
+	if err != nil {
+		return nil, err
+	}
+	usage, err := ComputeMySQLDiskUsage(dataDir)
+	if err != nil {
+		return nil, log.Errore(err)
+	}
+	mySQLDiskUsageMutex.Lock()
+	mySQLDiskUsageCache[this.Name] = usage
+	mySQLDiskUsageMutex.Unlock()
+	return usage, nil
+}
+
+// GetMySQLDiskUsage returns the disk usage breakdown of the MySQL datadir. A cached result is returned
+// as long as it is fresher than MySQLDiskUsageRefreshSeconds; a stale result is returned while it is being
+// refreshed in the background. Only the very first call, or a forced refresh, waits for the walk.
+func (this *MySQLInstance) GetMySQLDiskUsage(forceRefresh bool) (*MySQLDiskUsage, error) {
+	mySQLDiskUsageMutex.Lock()
+	cached := mySQLDiskUsageCache[this.Name]
+	refreshing := mySQLDiskUsageRefreshing[this.Name]
+	if cached != nil && !forceRefresh {
+		if time.Since(cached.ComputedAt) >= time.Duration(config.Config.MySQLDiskUsageRefreshSeconds)*time.Second && !refreshing {
+			mySQLDiskUsageRefreshing[this.Name] = true
+			go this.refreshMySQLDiskUsage()
+		}
+		mySQLDiskUsageMutex.Unlock()
+		return cached, nil
+	}
+	mySQLDiskUsageRefreshing[this.Name] = true
+	mySQLDiskUsageMutex.Unlock()
+
+	return this.refreshMySQLDiskUsage()
+}
@@ -0,0 +1,94 @@
+package osagent
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestComputeMySQLDiskUsage(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "orchestrator-agent-test-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	files := map[string]int{
+		"ibdata1":                  100,
+		"ib_logfile0":              50,
+		"#innodb_redo/#ib_redo10":  30,
+		"undo_001":                 20,
+		"ibtmp1":                   10,
+		"mysql-bin.000001":         7,
+		"mysql-bin.index":          3,
+		"relay-bin.000002":         5,
+		"auto.cnf":                 1,
+		"shop/orders.ibd":          400,
+		"shop/items#P#p0.ibd":      60,
+		"shop/items#P#p1.ibd":      40,
+		"shop/db.opt":              2,
+		"mysql/user.ibd":           8,
+		"performance_schema/a.sdi": 4,
+	}
+	for fileName, size := range files {
+		filePath := path.Join(dataDir, fileName)
+		os.MkdirAll(path.Dir(filePath), 0755)
+		if err := ioutil.WriteFile(filePath, []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatalf("Unexpected error: %+v", err)
+		}
+	}
+	ioutil.WriteFile(path.Join(dataDir, "mysql-bin.index"), []byte("./mysql-bin.000001\n"), 0644)
+	ioutil.WriteFile(path.Join(dataDir, "relay-bin.index"), []byte("./relay-bin.000002\n"), 0644)
+
+	usage, err := ComputeMySQLDiskUsage(dataDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if usage.SystemTablespace != 100 || usage.RedoLogs != 80 || usage.UndoLogs != 20 || usage.TemporaryFiles != 10 || usage.Other != 1 {
+		t.Errorf("Unexpected InnoDB breakdown: %+v", usage)
+	}
+	if usage.Binlogs != 7+19 || usage.RelayLogs != 5+19 {
+		t.Errorf("Unexpected log breakdown: binlogs %d, relay logs %d", usage.Binlogs, usage.RelayLogs)
+	}
+	if len(usage.Schemas) != 3 || usage.Schemas[0].Schema != "shop" || usage.Schemas[0].Bytes != 502 || usage.Schemas[0].Files != 4 {
+		t.Errorf("Unexpected schemas: %+v", usage.Schemas)
+	}
+	if len(usage.LargestTables) != 3 || usage.LargestTables[0].Table != "orders" || usage.LargestTables[1].Table != "items" || usage.LargestTables[1].Bytes != 100 {
+		t.Errorf("Unexpected tables: %+v", usage.LargestTables)
+	}
+	if usage.Total != 100+50+30+20+10+7+19+5+19+1+400+60+40+2+8+4 {
+		t.Errorf("Unexpected total: %d", usage.Total)
+	}
+}
+
+func TestFindDataDirFiles(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "orchestrator-agent-test-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(dataDir)
+	os.MkdirAll(path.Join(dataDir, "shop"), 0755)
+	ioutil.WriteFile(path.Join(dataDir, "ibdata1"), []byte(strings.Repeat("x", 100)), 0644)
+	ioutil.WriteFile(path.Join(dataDir, "shop", "my orders.ibd"), []byte(strings.Repeat("x", 40)), 0644)
+
+	found, err := findDataDirFiles(dataDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	walked, err := walkDataDirFiles(dataDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].RelativePath < found[j].RelativePath })
+	if !reflect.DeepEqual(found, walked) {
+		t.Errorf("find and walk listings differ: %+v, %+v", found, walked)
+	}
+
+	if _, err := parseFindFilesOutput([]byte("12 ibdata1\nbogus\n")); err == nil {
+		t.Errorf("Expected error on unparsable find output")
+	}
+}
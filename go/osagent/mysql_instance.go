@@ -0,0 +1,85 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"github.com/outbrain/orchestrator-agent/go/config"
+)
+
+// MySQLInstance is a MySQL server on this host, as named in MySQLInstances configuration
+type MySQLInstance struct {
+	Name   string
+	Config *config.MySQLInstanceConfiguration
+}
+
+// GetMySQLInstance returns the named MySQL instance; an empty name is the default instance
+func GetMySQLInstance(name string) (*MySQLInstance, error) {
+	if name == "" {
+		name = config.DefaultMySQLInstanceName
+	}
+	instanceConfig, err := config.Config.GetMySQLInstanceConfiguration(name)
+	if err != nil {
+		return nil, err
+	}
+	return &MySQLInstance{Name: name, Config: instanceConfig}, nil
+}
+
+// DefaultMySQLInstance returns the MySQL instance described by the top level MySQL settings
+func DefaultMySQLInstance() *MySQLInstance {
+	instance, _ := GetMySQLInstance(config.DefaultMySQLInstanceName)
+	return instance
+}
+
+// GetMySQLDataDir returns the datadir of the default MySQL instance
+func GetMySQLDataDir() (string, error) {
+	return DefaultMySQLInstance().GetMySQLDataDir()
+}
+
+// GetBinlogFileNames returns the binary logs of the default MySQL instance
+func GetBinlogFileNames() ([]string, error) {
+	return DefaultMySQLInstance().GetBinlogFileNames()
+}
+
+// GetMySQLDataDirAvailableDiskSpace returns the free space on the datadir volume of the default MySQL instance
+func GetMySQLDataDirAvailableDiskSpace() (int64, error) {
+	return DefaultMySQLInstance().GetMySQLDataDirAvailableDiskSpace()
+}
+
+// MySQLRunning returns true when the default MySQL instance is running
+func MySQLRunning() (bool, error) {
+	return DefaultMySQLInstance().MySQLRunning()
+}
+
+// PostCopy executes the post-copy command of the default MySQL instance
+func PostCopy() error {
+	return DefaultMySQLInstance().PostCopy()
+}
+
+// GetMount returns the status of given mount point, with the MySQL data path of the default MySQL instance
+func GetMount(mountPoint string) (Mount, error) {
+	return DefaultMySQLInstance().GetMount(mountPoint)
+}
+
+// HeuristicMySQLDataPath locates the default MySQL instance's data under given mount point
+func HeuristicMySQLDataPath(mountPoint string) (string, error) {
+	return DefaultMySQLInstance().HeuristicMySQLDataPath(mountPoint)
+}
+
+// GetMySQLDataDirLogicalVolume returns the logical volume on which the default MySQL instance's datadir resides
+func GetMySQLDataDirLogicalVolume() (*LogicalVolume, error) {
+	return DefaultMySQLInstance().GetMySQLDataDirLogicalVolume()
+}
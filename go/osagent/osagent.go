@@ -17,6 +17,7 @@
 package osagent
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -26,10 +27,13 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/outbrain/golib/log"
 	"github.com/outbrain/orchestrator-agent/go/config"
 	"github.com/outbrain/orchestrator-agent/go/inst"
+	"github.com/outbrain/orchestrator-agent/go/mysql"
 )
 
 const (
@@ -37,24 +41,79 @@ const (
 )
 
 var activeCommands map[string](*exec.Cmd) = make(map[string](*exec.Cmd))
+var activeCommandsMutex = &sync.Mutex{}
+
+// setActiveCommand registers the command executing given seed
+func setActiveCommand(seedId string, cmd *exec.Cmd) {
+	activeCommandsMutex.Lock()
+	defer activeCommandsMutex.Unlock()
+	activeCommands[seedId] = cmd
+}
+
+// getActiveCommand returns the command executing given seed, if any
+func getActiveCommand(seedId string) (*exec.Cmd, bool) {
+	activeCommandsMutex.Lock()
+	defer activeCommandsMutex.Unlock()
+	cmd, ok := activeCommands[seedId]
+	return cmd, ok
+}
 
 // LogicalVolume describes an LVM volume
 type LogicalVolume struct {
 	Name            string
 	GroupName       string
 	Path            string
+	Size            int64
+	Attributes      string
+	Origin          string
+	ThinPool        string
+	DataPercent     float64
+	MetadataPercent float64
+	Tags            []string
+	CreationTime    time.Time
+	IsActive        bool
 	IsSnapshot      bool
+	IsThinSnapshot  bool
 	SnapshotPercent float64
 }
 
-func GetMySQLDataDir() (string, error) {
-	command := config.Config.MySQLDatadirCommand
+// lvsReport maps the output of `lvs --reportformat json`
+type lvsReport struct {
+	Report []struct {
+		LV []map[string]string `json:"lv"`
+	} `json:"report"`
+}
+
+const lvsReportFields = "lv_name,vg_name,lv_path,lv_size,lv_attr,origin,pool_lv,data_percent,metadata_percent,snap_percent,lv_tags,lv_time,lv_active"
+
+// getMySQLVariable reads a global variable through the agent's MySQL connection, when one is configured.
+// It returns false when there is no connection or the read fails, for the caller to fall back to commands.
+func (this *MySQLInstance) getMySQLVariable(name string) (string, bool) {
+	if !mysql.IsConfigured(this.Config) {
+		return "", false
+	}
+	value, err := mysql.GetVariable(this.Config, name)
+	if err != nil {
+		log.Errorf("Cannot read @@%s: %+v; falling back to command", name, err)
+		return "", false
+	}
+	return value, true
+}
+
+func (this *MySQLInstance) GetMySQLDataDir() (string, error) {
+	if dataDir, ok := this.getMySQLVariable("datadir"); ok && dataDir != "" {
+		return strings.TrimSuffix(dataDir, "/"), nil
+	}
+	command := this.Config.MySQLDatadirCommand
 	output, err := commandOutput(command)
 	return strings.TrimSpace(fmt.Sprintf("%s", output)), err
 }
 
-func GetMySQLPort() (int64, error) {
-	command := config.Config.MySQLPortCommand
+func (this *MySQLInstance) GetMySQLPort() (int64, error) {
+	if port, ok := this.getMySQLVariable("port"); ok {
+		return strconv.ParseInt(port, 10, 0)
+	}
+	command := this.Config.MySQLPortCommand
 	output, err := commandOutput(command)
 	if err != nil {
 		return 0, err
@@ -62,9 +121,12 @@ func GetMySQLPort() (int64, error) {
 	return strconv.ParseInt(strings.TrimSpace(fmt.Sprintf("%s", output)), 10, 0)
 }
 
-// GetRelayLogIndexFileName attempts to find the relay log index file under the mysql datadir
-func GetRelayLogIndexFileName() (string, error) {
-	directory, err := GetMySQLDataDir()
+// GetRelayLogIndexFileName attempts to find the relay log index file: @@relay_log_index, or else under the mysql datadir
+func (this *MySQLInstance) GetRelayLogIndexFileName() (string, error) {
+	if relayLogIndexFile, ok := this.getMySQLVariable("relay_log_index"); ok && relayLogIndexFile != "" {
+		return relayLogIndexFile, nil
+	}
+	directory, err := this.GetMySQLDataDir()
 	if err != nil {
 		return "", log.Errore(err)
 	}
@@ -78,8 +140,8 @@ func GetRelayLogIndexFileName() (string, error) {
 }
 
 // GetRelayLogFileNames attempts to find the active relay logs
-func GetRelayLogFileNames() (fileNames []string, err error) {
-	relayLogIndexFile, err := GetRelayLogIndexFileName()
+func (this *MySQLInstance) GetRelayLogFileNames() (fileNames []string, err error) {
+	relayLogIndexFile, err := this.GetRelayLogIndexFileName()
 	if err != nil {
 		return fileNames, log.Errore(err)
 	}
@@ -98,9 +160,94 @@ func GetRelayLogFileNames() (fileNames []string, err error) {
 	return fileNames, nil
 }
 
+// GetRelayLogFileNamesFrom returns the active relay logs, starting with given relay log (by path or file name)
+func (this *MySQLInstance) GetRelayLogFileNamesFrom(firstRelaylog string) (fileNames []string, err error) {
+	existingRelaylogs, err := this.GetRelayLogFileNames()
+	if err != nil {
+		return fileNames, err
+	}
+	return logFileNamesFrom(existingRelaylogs, firstRelaylog), nil
+}
+
+// logFileNamesFrom returns the log files, starting with given log file (by path or file name)
+func logFileNamesFrom(existingLogs []string, firstLog string) (fileNames []string) {
+	for i, existingLog := range existingLogs {
+		if (firstLog == existingLog) || (firstLog == path.Base(existingLog)) {
+			// found the log we want to start with
+			fileNames = existingLogs[i:]
+		}
+	}
+	return fileNames
+}
+
+// GetBinlogIndexFileName attempts to find the binary log index file: @@log_bin_index, as configured by
+// log-bin/log-bin-index in MySQLConfigFile, or else under the mysql datadir
+func (this *MySQLInstance) GetBinlogIndexFileName() (string, error) {
+	if binlogIndexFile, ok := this.getMySQLVariable("log_bin_index"); ok && binlogIndexFile != "" {
+		return binlogIndexFile, nil
+	}
+	options, optionsErr := this.GetMySQLServerOptions()
+	if optionsErr != nil {
+		log.Debugf("Cannot read %s: %+v", this.Config.MySQLConfigFile, optionsErr)
+	}
+	directory, err := this.GetMySQLDataDir()
+	if (err != nil || directory == "") && options["datadir"] != "" {
+		directory, err = options["datadir"], nil
+	}
+	if err != nil {
+		return "", log.Errore(err)
+	}
+
+	if binlogIndexFile := binlogIndexFileFromOptions(options, directory); binlogIndexFile != "" {
+		return binlogIndexFile, nil
+	}
+
+	output, err := commandOutput(fmt.Sprintf("ls %s/*.index | grep -v relay", directory))
+	if err != nil {
+		return "", log.Errore(err)
+	}
+	lines, err := outputLines(output, err)
+	if err != nil {
+		return "", log.Errore(err)
+	}
+	return strings.TrimSpace(lines[0]), nil
+}
+
+// GetBinlogFileNames attempts to find the binary logs listed in the binary log index, oldest first
+func (this *MySQLInstance) GetBinlogFileNames() (fileNames []string, err error) {
+	binlogIndexFile, err := this.GetBinlogIndexFileName()
+	if err != nil {
+		return fileNames, log.Errore(err)
+	}
+
+	contents, err := ioutil.ReadFile(binlogIndexFile)
+	if err != nil {
+		return fileNames, log.Errore(err)
+	}
+
+	for _, fileName := range strings.Split(string(contents), "\n") {
+		if fileName != "" {
+			if !path.IsAbs(fileName) {
+				fileName = path.Join(path.Dir(binlogIndexFile), fileName)
+			}
+			fileNames = append(fileNames, fileName)
+		}
+	}
+	return fileNames, nil
+}
+
+// GetBinlogFileNamesFrom returns the binary logs, starting with given binary log (by path or file name)
+func (this *MySQLInstance) GetBinlogFileNamesFrom(firstBinlog string) (fileNames []string, err error) {
+	existingBinlogs, err := this.GetBinlogFileNames()
+	if err != nil {
+		return fileNames, err
+	}
+	return logFileNamesFrom(existingBinlogs, firstBinlog), nil
+}
+
 // GetRelayLogEndCoordinates returns the coordinates at the end of relay logs
-func GetRelayLogEndCoordinates() (coordinates *inst.BinlogCoordinates, err error) {
-	relaylogFileNames, err := GetRelayLogFileNames()
+func (this *MySQLInstance) GetRelayLogEndCoordinates() (coordinates *inst.BinlogCoordinates, err error) {
+	relaylogFileNames, err := this.GetRelayLogFileNames()
 	if err != nil {
 		return coordinates, log.Errore(err)
 	}
@@ -122,10 +269,13 @@ func GetRelayLogEndCoordinates() (coordinates *inst.BinlogCoordinates, err error
 	return &inst.BinlogCoordinates{LogFile: lastRelayLogFile, LogPos: fileSize, Type: inst.RelayLog}, nil
 }
 
-func MySQLBinlogContents(binlogFiles []string, startPosition int64, stopPosition int64) (string, error) {
+func (this *MySQLInstance) MySQLBinlogContents(binlogFiles []string, startPosition int64, stopPosition int64, filter *BinlogEventFilter) (string, error) {
 	if len(binlogFiles) == 0 {
 		return "", log.Errorf("No binlog files provided in MySQLBinlogContents")
 	}
+	if !filter.IsEmpty() {
+		return this.filteredMySQLBinlogContents(binlogFiles, startPosition, stopPosition, filter)
+	}
 	cmd := `mysqlbinlog`
 	for _, binlogFile := range binlogFiles {
 		cmd = fmt.Sprintf("%s %s", cmd, binlogFile)
@@ -142,11 +292,52 @@ func MySQLBinlogContents(binlogFiles []string, startPosition int64, stopPosition
 	return string(output), err
 }
 
-// Equals tests equality of this corrdinate and another one.
+// resolveMySQLLogPath returns the path of a binary log or relay log; relative names are taken to be
+// under the MySQL datadir
+func (this *MySQLInstance) resolveMySQLLogPath(fileName string) (string, error) {
+	if fileName == "" {
+		return "", errors.New("Empty binary log file name")
+	}
+	if path.IsAbs(fileName) {
+		return fileName, nil
+	}
+	directory, err := this.GetMySQLDataDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(directory, fileName), nil
+}
+
+// MySQLBinlogEvents natively reads the events of a binary log or relay log which start within the
+// given position range (0 stop position meaning the end of file) and pass the (optional) filter, up to limit events
+func (this *MySQLInstance) MySQLBinlogEvents(binlogFile string, startPosition int64, stopPosition int64, filter *BinlogEventFilter, limit int) ([]inst.BinlogEvent, error) {
+	binlogPath, err := this.resolveMySQLLogPath(binlogFile)
+	if err != nil {
+		return nil, err
+	}
+	if filter.IsEmpty() {
+		return inst.ReadBinlogEvents(binlogPath, startPosition, stopPosition, limit)
+	}
+	events := []inst.BinlogEvent{}
+	err = inst.ScanBinlogEvents(binlogPath, startPosition, stopPosition, func(event *inst.BinlogEvent) bool {
+		if filter.Matches(event) {
+			events = append(events, *event)
+		}
+		return limit <= 0 || len(events) < limit
+	})
+	return events, err
+}
+
+// IsSnapshotValid returns true when this is a snapshot which has not overflowed.
+// A thin snapshot is only as valid as its thin pool, which must not be full.
 func (this *LogicalVolume) IsSnapshotValid() bool {
 	if !this.IsSnapshot {
 		return false
 	}
+	if len(this.Attributes) > 4 && this.Attributes[4] == 'I' {
+		// state column: invalid snapshot ('S' in the volume type column is a merging snapshot, not an invalid one)
+		return false
+	}
 	if this.SnapshotPercent >= 100.0 {
 		return false
 	}
@@ -155,14 +346,24 @@ func (this *LogicalVolume) IsSnapshotValid() bool {
 
 // Mount describes a file system mount point
 type Mount struct {
-	Path           string
-	Device         string
-	LVPath         string
-	FileSystem     string
-	IsMounted      bool
-	DiskUsage      int64
-	MySQLDataPath  string
-	MySQLDiskUsage int64
+	Path            string
+	Device          string
+	MajorMinor      string
+	Root            string
+	LVPath          string
+	FileSystem      string
+	Options         string
+	SuperOptions    string
+	IsMounted       bool
+	ReadOnly        bool
+	NoRecovery      bool // mounted without log recovery (XFS norecovery, ext3/ext4 noload): possibly inconsistent
+	Size            int64
+	Used            int64
+	Available       int64
+	DiskUsage       int64
+	MySQLDataPath   string
+	MySQLDiskUsage  int64
+	FileSystemCheck *FileSystemCheck
 }
 
 func init() {
@@ -211,6 +412,17 @@ func commandOutput(commandText string) ([]byte, error) {
 	return outputBytes, nil
 }
 
+// commandCombinedOutput executes a command and returns its combined stdout and stderr, whether it succeeds or not
+func commandCombinedOutput(commandText string) ([]byte, error) {
+	cmd, tmpFileName, err := execCmd(commandText)
+	if err != nil {
+		return nil, log.Errore(err)
+	}
+	defer os.Remove(tmpFileName)
+
+	return cmd.CombinedOutput()
+}
+
 // commandRun executes a command
 func commandRun(commandText string, onCommand func(*exec.Cmd)) error {
 	cmd, tmpFileName, err := execCmd(commandText)
@@ -253,22 +465,97 @@ func Hostname() (string, error) {
 	return os.Hostname()
 }
 
+// parseLogicalVolumesReport parses `lvs --reportformat json` output, as produced with lvsReportFields
+func parseLogicalVolumesReport(output []byte) ([]LogicalVolume, error) {
+	report := lvsReport{}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, fmt.Errorf("Cannot parse lvs report: %+v", err)
+	}
+	logicalVolumes := []LogicalVolume{}
+	for _, reportEntry := range report.Report {
+		for _, fields := range reportEntry.LV {
+			logicalVolume := LogicalVolume{
+				Name:       fields["lv_name"],
+				GroupName:  fields["vg_name"],
+				Path:       fields["lv_path"],
+				Attributes: fields["lv_attr"],
+				Origin:     fields["origin"],
+				ThinPool:   fields["pool_lv"],
+				IsActive:   fields["lv_active"] == "active",
+				Tags:       []string{},
+			}
+			logicalVolume.Size, _ = strconv.ParseInt(fields["lv_size"], 10, 0)
+			logicalVolume.DataPercent, _ = strconv.ParseFloat(fields["data_percent"], 64)
+			logicalVolume.MetadataPercent, _ = strconv.ParseFloat(fields["metadata_percent"], 64)
+			logicalVolume.SnapshotPercent, _ = strconv.ParseFloat(fields["snap_percent"], 64)
+			if tags := fields["lv_tags"]; tags != "" {
+				logicalVolume.Tags = strings.Split(tags, ",")
+			}
+			logicalVolume.CreationTime, _ = time.Parse("2006-01-02 15:04:05 -0700", fields["lv_time"])
+
+			volumeType := byte(0)
+			if len(logicalVolume.Attributes) > 0 {
+				volumeType = logicalVolume.Attributes[0]
+			}
+			switch {
+			case volumeType == 's' || volumeType == 'S':
+				logicalVolume.IsSnapshot = true
+			case volumeType == 'V' && logicalVolume.Origin != "":
+				logicalVolume.IsSnapshot = true
+				logicalVolume.IsThinSnapshot = true
+			}
+			logicalVolumes = append(logicalVolumes, logicalVolume)
+		}
+	}
+	return logicalVolumes, nil
+}
+
+// applyThinPoolUsage sets the snapshot percent of thin snapshots to be their pool's data usage;
+// thin snapshots do not report snap_percent, and become unusable only when the pool fills up.
+func applyThinPoolUsage(logicalVolumes []LogicalVolume) {
+	poolUsage := make(map[string]float64)
+	for _, logicalVolume := range logicalVolumes {
+		if len(logicalVolume.Attributes) > 0 && logicalVolume.Attributes[0] == 't' {
+			poolUsage[logicalVolume.GroupName+"/"+logicalVolume.Name] = logicalVolume.DataPercent
+		}
+	}
+	for i := range logicalVolumes {
+		logicalVolume := &logicalVolumes[i]
+		if !logicalVolume.IsThinSnapshot {
+			continue
+		}
+		poolName := logicalVolume.GroupName + "/" + logicalVolume.ThinPool
+		usage, ok := poolUsage[poolName]
+		if !ok {
+			if pools, err := readLogicalVolumes(poolName); err == nil && len(pools) > 0 {
+				usage = pools[0].DataPercent
+				poolUsage[poolName] = usage
+			}
+		}
+		logicalVolume.SnapshotPercent = usage
+	}
+}
+
+// readLogicalVolumes runs lvs on given volume name (or all volumes if empty) and parses its report
+func readLogicalVolumes(volumeName string) ([]LogicalVolume, error) {
+	output, err := commandOutput(sudoCmd(fmt.Sprintf("lvs --reportformat json --units b --nosuffix -o %s %s", lvsReportFields, volumeName)))
+	if err != nil {
+		return nil, err
+	}
+	return parseLogicalVolumesReport(output)
+}
+
+// LogicalVolumes lists logical volumes by given volume name (or all volumes if empty),
+// whose name contains the given filter pattern
 func LogicalVolumes(volumeName string, filterPattern string) ([]LogicalVolume, error) {
-	output, err := commandOutput(sudoCmd(fmt.Sprintf("lvs --noheading -o lv_name,vg_name,lv_path,snap_percent %s", volumeName)))
-	tokens, err := outputTokens(`[ \t]+`, output, err)
+	allLogicalVolumes, err := readLogicalVolumes(volumeName)
 	if err != nil {
 		return nil, err
 	}
+	applyThinPoolUsage(allLogicalVolumes)
 
 	logicalVolumes := []LogicalVolume{}
-	for _, lineTokens := range tokens {
-		logicalVolume := LogicalVolume{
-			Name:      lineTokens[1],
-			GroupName: lineTokens[2],
-			Path:      lineTokens[3],
-		}
-		logicalVolume.SnapshotPercent, err = strconv.ParseFloat(lineTokens[4], 32)
-		logicalVolume.IsSnapshot = (err == nil)
+	for _, logicalVolume := range allLogicalVolumes {
 		if strings.Contains(logicalVolume.Name, filterPattern) {
 			logicalVolumes = append(logicalVolumes, logicalVolume)
 		}
@@ -295,33 +582,36 @@ func GetLogicalVolumeFSType(volumeName string) (string, error) {
 	return "", errors.New(fmt.Sprintf("Cannot find FS type for logical volume %s", volumeName))
 }
 
-func GetMount(mountPoint string) (Mount, error) {
+// GetMount returns the status of given mount point, including disk usage of the file system and of
+// the MySQL data it may contain, located by this instance's datadir
+func (this *MySQLInstance) GetMount(mountPoint string) (Mount, error) {
 	mount := Mount{
 		Path:      mountPoint,
 		IsMounted: false,
 	}
 
-	output, err := commandOutput(fmt.Sprintf("grep %s /etc/mtab", mountPoint))
-	tokens, err := outputTokens(`[ \t]+`, output, err)
+	foundMount, err := FindMount(mountPoint)
 	if err != nil {
-		// when grep does not find rows, it returns an error. So this is actually OK
+		return mount, err
+	}
+	if foundMount == nil {
 		return mount, nil
 	}
-
-	for _, lineTokens := range tokens {
-		mount.IsMounted = true
-		mount.Device = lineTokens[0]
-		mount.Path = lineTokens[1]
-		mount.FileSystem = lineTokens[2]
+	mount = *foundMount
+	if mount.LVPath == "" && strings.HasPrefix(mount.Device, "/dev/") {
 		mount.LVPath, _ = GetLogicalVolumePath(mount.Device)
-		mount.DiskUsage, _ = DiskUsage(mountPoint)
-		mount.MySQLDataPath, _ = HeuristicMySQLDataPath(mountPoint)
-		mount.MySQLDiskUsage, _ = DiskUsage(mount.MySQLDataPath)
 	}
+	mount.DiskUsage, _ = DiskUsage(mountPoint)
+	mount.MySQLDataPath, _ = this.HeuristicMySQLDataPath(mountPoint)
+	mount.MySQLDiskUsage, _ = DiskUsage(mount.MySQLDataPath)
 	return mount, nil
 }
 
-func MountLV(mountPoint string, volumeName string) (Mount, error) {
+// MountLV mounts a logical volume on given mount point. Unless readOnly is false, the volume is mounted read-only,
+// so that snapshots we seed from cannot be modified. A file system check precedes the mount when checkFileSystem
+// is true, and its result is reported in the returned Mount. Should a read-only mount fail, allowNoRecovery
+// permits retrying without log recovery, which the returned Mount then reports as NoRecovery.
+func MountLV(mountPoint string, volumeName string, readOnly bool, checkFileSystem bool, allowNoRecovery bool) (Mount, error) {
 	mount := Mount{
 		Path:      mountPoint,
 		IsMounted: false,
@@ -334,16 +624,28 @@ func MountLV(mountPoint string, volumeName string) (Mount, error) {
 		return mount, err
 	}
 
-	mountOptions := ""
-	if fsType == "xfs" {
-		mountOptions = "-o nouuid"
+	var fileSystemCheck *FileSystemCheck
+	if checkFileSystem {
+		if fileSystemCheck, err = CheckFileSystem(volumeName, fsType); err != nil {
+			return mount, err
+		}
 	}
+
+	mountOptions := MountOptions(fsType, readOnly, false)
 	_, err = commandOutput(sudoCmd(fmt.Sprintf("mount %s %s %s", mountOptions, volumeName, mountPoint)))
+	if err != nil && readOnly && allowNoRecovery && (fsType == "xfs" || fsType == "ext3" || fsType == "ext4") {
+		// A file system with a dirty log may refuse a read-only mount, unless log recovery is skipped
+		log.Warningf("Cannot mount %s read-only; retrying without log recovery", volumeName)
+		mountOptions = MountOptions(fsType, readOnly, true)
+		_, err = commandOutput(sudoCmd(fmt.Sprintf("mount %s %s %s", mountOptions, volumeName, mountPoint)))
+	}
 	if err != nil {
 		return mount, err
 	}
 
-	return GetMount(mountPoint)
+	mount, err = GetMount(mountPoint)
+	mount.FileSystemCheck = fileSystemCheck
+	return mount, err
 }
 
 func RemoveLV(volumeName string) error {
@@ -351,11 +653,175 @@ func RemoveLV(volumeName string) error {
 	return err
 }
 
-func CreateSnapshot() error {
-	_, err := commandOutput(config.Config.CreateSnapshotCommand)
+// extendLVCommands returns the lvextend commands growing a logical volume by given percentage of its current
+// size, and failing that, by the free space remaining in its volume group
+func extendLVCommands(volumeName string, extendPercent uint) (string, string) {
+	return fmt.Sprintf("lvextend -l +%d%%LV %s", extendPercent, volumeName), fmt.Sprintf("lvextend -l +100%%FREE %s", volumeName)
+}
+
+// ExtendLV grows a logical volume by given percentage of its current size. Should the volume group
+// not have enough free extents for that, the volume is grown by whatever free space remains.
+func ExtendLV(volumeName string, extendPercent uint) error {
+	extendCommand, fallbackCommand := extendLVCommands(volumeName, extendPercent)
+	_, err := commandOutput(sudoCmd(extendCommand))
+	if err == nil {
+		return nil
+	}
+	log.Warningf("Cannot extend %s by %d%%; attempting to use remaining free space in volume group", volumeName, extendPercent)
+	_, err = commandOutput(sudoCmd(fallbackCommand))
 	return err
 }
 
+// lvmTagRegexp matches strings made of the characters LVM allows in tags, less `!`, `&` and `#`: tags are
+// passed to lvchange through the shell, where these would be interpreted
+var lvmTagRegexp = regexp.MustCompile(`^[A-Za-z0-9_+.\-/=:]+$`)
+
+// ValidSnapshotTagValue returns true when given value only has characters allowed in tags (see lvmTagRegexp)
+func ValidSnapshotTagValue(value string) bool {
+	return lvmTagRegexp.MatchString(value)
+}
+
+// SnapshotTag returns an agent snapshot tag of given key and value, e.g. "orchestrator-agent-purpose=scheduled".
+// An empty key returns the tag marking agent created snapshots.
+func SnapshotTag(key string, value string) string {
+	if key == "" {
+		return config.Config.SnapshotVolumesTag
+	}
+	return fmt.Sprintf("%s-%s=%s", config.Config.SnapshotVolumesTag, key, value)
+}
+
+// HasTag returns true when this logical volume carries given tag
+func (this *LogicalVolume) HasTag(tag string) bool {
+	for _, volumeTag := range this.Tags {
+		if volumeTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// TagValue returns the value of an agent snapshot tag by given key, or empty string if the tag does not exist
+func (this *LogicalVolume) TagValue(key string) string {
+	prefix := SnapshotTag(key, "")
+	for _, volumeTag := range this.Tags {
+		if strings.HasPrefix(volumeTag, prefix) {
+			return strings.TrimPrefix(volumeTag, prefix)
+		}
+	}
+	return ""
+}
+
+// IsAgentSnapshot returns true when this is a snapshot identified as a MySQL snapshot: either it is tagged as
+// created by the agent, or (legacy) its name contains the configured SnapshotVolumesFilter
+func (this *LogicalVolume) IsAgentSnapshot() bool {
+	if !this.IsSnapshot {
+		return false
+	}
+	if config.Config.SnapshotVolumesTag != "" && this.HasTag(SnapshotTag("", "")) {
+		return true
+	}
+	if config.Config.SnapshotVolumesFilter != "" && strings.Contains(this.Name, config.Config.SnapshotVolumesFilter) {
+		return true
+	}
+	return false
+}
+
+// SnapshotLogicalVolumes lists MySQL snapshots, optionally only those carrying given tag
+func SnapshotLogicalVolumes(tag string) ([]LogicalVolume, error) {
+	logicalVolumes, err := LogicalVolumes("", "")
+	if err != nil {
+		return nil, err
+	}
+	snapshots := []LogicalVolume{}
+	for _, logicalVolume := range logicalVolumes {
+		if !logicalVolume.IsAgentSnapshot() {
+			continue
+		}
+		if tag != "" && !logicalVolume.HasTag(tag) {
+			continue
+		}
+		snapshots = append(snapshots, logicalVolume)
+	}
+	return snapshots, nil
+}
+
+// TagLogicalVolume adds given LVM tags to a logical volume
+func TagLogicalVolume(volumeName string, tags ...string) error {
+	command := "lvchange"
+	for _, tag := range tags {
+		if !lvmTagRegexp.MatchString(tag) {
+			return fmt.Errorf("Invalid LVM tag: %s", tag)
+		}
+		command = fmt.Sprintf("%s --addtag %s", command, tag)
+	}
+	_, err := commandOutput(sudoCmd(fmt.Sprintf("%s %s", command, volumeName)))
+	return err
+}
+
+// RemoveLogicalVolumeTags removes given LVM tags from a logical volume
+func RemoveLogicalVolumeTags(volumeName string, tags ...string) error {
+	command := "lvchange"
+	for _, tag := range tags {
+		command = fmt.Sprintf("%s --deltag %s", command, tag)
+	}
+	_, err := commandOutput(sudoCmd(fmt.Sprintf("%s %s", command, volumeName)))
+	return err
+}
+
+// snapshotPaths returns the paths of all existing snapshot volumes
+func snapshotPaths() (map[string]bool, error) {
+	logicalVolumes, err := LogicalVolumes("", "")
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]bool)
+	for _, logicalVolume := range logicalVolumes {
+		if logicalVolume.IsSnapshot {
+			paths[logicalVolume.Path] = true
+		}
+	}
+	return paths, nil
+}
+
+// CreateSnapshot runs the CreateSnapshotCommand, then tags the newly created snapshot(s) as agent-created,
+// with given purpose and origin volume.
+func CreateSnapshot(purpose string) error {
+	if !ValidSnapshotTagValue(purpose) {
+		return fmt.Errorf("Invalid snapshot purpose: %s", purpose)
+	}
+	existingSnapshots, lvsErr := snapshotPaths()
+	_, err := commandOutput(config.Config.CreateSnapshotCommand)
+	if err != nil {
+		return err
+	}
+	if config.Config.SnapshotVolumesTag == "" {
+		return nil
+	}
+	if lvsErr != nil {
+		return log.Errorf("Snapshot created, but cannot tag it: %+v", lvsErr)
+	}
+
+	logicalVolumes, err := LogicalVolumes("", "")
+	if err != nil {
+		return log.Errorf("Snapshot created, but cannot tag it: %+v", err)
+	}
+	for _, logicalVolume := range logicalVolumes {
+		if !logicalVolume.IsSnapshot || existingSnapshots[logicalVolume.Path] {
+			continue
+		}
+		tags := []string{
+			SnapshotTag("", ""),
+			SnapshotTag("purpose", purpose),
+			SnapshotTag("source", logicalVolume.GroupName+"/"+logicalVolume.Origin),
+		}
+		if err := TagLogicalVolume(logicalVolume.Path, tags...); err != nil {
+			return log.Errorf("Snapshot %s created, but cannot tag it: %+v", logicalVolume.Path, err)
+		}
+		log.Infof("Tagged snapshot %s: %+v", logicalVolume.Path, tags)
+	}
+	return nil
+}
+
 func Unmount(mountPoint string) (Mount, error) {
 	mount := Mount{
 		Path:      mountPoint,
@@ -385,9 +851,9 @@ func DiskUsage(path string) (int64, error) {
 }
 
 // DeleteMySQLDataDir self explanatory. Be responsible! This function does not verify the MySQL service is down
-func DeleteMySQLDataDir() error {
+func (this *MySQLInstance) DeleteMySQLDataDir() error {
 
-	directory, err := GetMySQLDataDir()
+	directory, err := this.GetMySQLDataDir()
 	if err != nil {
 		return err
 	}
@@ -399,13 +865,13 @@ func DeleteMySQLDataDir() error {
 	if path.Dir(directory) == directory {
 		return errors.New(fmt.Sprintf("Directory %s seems to be root; refusing to delete", directory))
 	}
-	_, err = commandOutput(config.Config.MySQLDeleteDatadirContentCommand)
+	_, err = commandOutput(this.Config.MySQLDeleteDatadirContentCommand)
 
 	return err
 }
 
-func GetMySQLDataDirAvailableDiskSpace() (int64, error) {
-	directory, err := GetMySQLDataDir()
+func (this *MySQLInstance) GetMySQLDataDirAvailableDiskSpace() (int64, error) {
+	directory, err := this.GetMySQLDataDir()
 	if err != nil {
 		return 0, log.Errore(err)
 	}
@@ -429,13 +895,15 @@ func GetMySQLDataDirAvailableDiskSpace() (int64, error) {
 }
 
 // PostCopy executes a post-copy command -- after LVM copy is done, before service starts. Some cleanup may go here.
-func PostCopy() error {
-	_, err := commandOutput(config.Config.PostCopyCommand)
+func (this *MySQLInstance) PostCopy() error {
+	_, err := commandOutput(this.Config.PostCopyCommand)
 	return err
 }
 
-func HeuristicMySQLDataPath(mountPoint string) (string, error) {
-	datadir, err := GetMySQLDataDir()
+// HeuristicMySQLDataPath locates this instance's data under given mount point: the datadir path, or the
+// shortest suffix of it, under which ibdata1 is found
+func (this *MySQLInstance) HeuristicMySQLDataPath(mountPoint string) (string, error) {
+	datadir, err := this.GetMySQLDataDir()
 	if err != nil {
 		return "", err
 	}
@@ -469,30 +937,41 @@ func AvailableSnapshots(requireLocal bool) ([]string, error) {
 	return hosts, err
 }
 
-func MySQLErrorLogTail() ([]string, error) {
-	output, err := commandOutput(sudoCmd(`tail -n 20 $(egrep "log[-_]error" /etc/my.cnf | cut -d "=" -f 2)`))
+func (this *MySQLInstance) MySQLErrorLogTail() ([]string, error) {
+	if errorLog, ok := this.getMySQLVariable("log_error"); ok && errorLog != "" && errorLog != "stderr" {
+		if !path.IsAbs(errorLog) {
+			dataDir, err := this.GetMySQLDataDir()
+			if err != nil {
+				return nil, err
+			}
+			errorLog = path.Join(dataDir, errorLog)
+		}
+		output, err := commandOutput(sudoCmd(fmt.Sprintf("tail -n 20 %s", errorLog)))
+		return outputLines(output, err)
+	}
+	output, err := commandOutput(sudoCmd(fmt.Sprintf(`tail -n 20 $(egrep "log[-_]error" %s | cut -d "=" -f 2)`, this.Config.MySQLConfigFile)))
 	tail, err := outputLines(output, err)
 	return tail, err
 }
 
-func MySQLRunning() (bool, error) {
-	_, err := commandOutput(config.Config.MySQLServiceStatusCommand)
+func (this *MySQLInstance) MySQLRunning() (bool, error) {
+	_, err := commandOutput(this.Config.MySQLServiceStatusCommand)
 	// status command exits with 0 when MySQL is running, or otherwise if not running
 	return err == nil, nil
 }
 
-func MySQLStop() error {
-	_, err := commandOutput(config.Config.MySQLServiceStopCommand)
+func (this *MySQLInstance) MySQLStop() error {
+	_, err := commandOutput(this.Config.MySQLServiceStopCommand)
 	return err
 }
 
-func MySQLStart() error {
-	_, err := commandOutput(config.Config.MySQLServiceStartCommand)
+func (this *MySQLInstance) MySQLStart() error {
+	_, err := commandOutput(this.Config.MySQLServiceStartCommand)
 	return err
 }
 
-func ReceiveMySQLSeedData(seedId string) error {
-	directory, err := GetMySQLDataDir()
+func (this *MySQLInstance) ReceiveMySQLSeedData(seedId string) error {
+	directory, err := this.GetMySQLDataDir()
 	if err != nil {
 		return log.Errore(err)
 	}
@@ -500,7 +979,7 @@ func ReceiveMySQLSeedData(seedId string) error {
 	err = commandRun(
 		fmt.Sprintf("%s %s %d", config.Config.ReceiveSeedDataCommand, directory, SeedTransferPort),
 		func(cmd *exec.Cmd) {
-			activeCommands[seedId] = cmd
+			setActiveCommand(seedId, cmd)
 			log.Debug("ReceiveMySQLSeedData command completed")
 		})
 	if err != nil {
@@ -516,7 +995,7 @@ func SendMySQLSeedData(targetHostname string, directory string, seedId string) e
 	}
 	err := commandRun(fmt.Sprintf("%s %s %s %d", config.Config.SendSeedDataCommand, directory, targetHostname, SeedTransferPort),
 		func(cmd *exec.Cmd) {
-			activeCommands[seedId] = cmd
+			setActiveCommand(seedId, cmd)
 			log.Debug("SendMySQLSeedData command completed")
 		})
 	if err != nil {
@@ -526,7 +1005,7 @@ func SendMySQLSeedData(targetHostname string, directory string, seedId string) e
 }
 
 func SeedCommandCompleted(seedId string) bool {
-	if cmd, ok := activeCommands[seedId]; ok {
+	if cmd, ok := getActiveCommand(seedId); ok {
 		if cmd.ProcessState != nil {
 			return cmd.ProcessState.Exited()
 		}
@@ -535,7 +1014,7 @@ func SeedCommandCompleted(seedId string) bool {
 }
 
 func SeedCommandSucceeded(seedId string) bool {
-	if cmd, ok := activeCommands[seedId]; ok {
+	if cmd, ok := getActiveCommand(seedId); ok {
 		if cmd.ProcessState != nil {
 			return cmd.ProcessState.Success()
 		}
@@ -543,8 +1022,20 @@ func SeedCommandSucceeded(seedId string) bool {
 	return false
 }
 
+// SeedInProgress returns true when any seed send/receive command is still running
+func SeedInProgress() bool {
+	activeCommandsMutex.Lock()
+	defer activeCommandsMutex.Unlock()
+	for _, cmd := range activeCommands {
+		if cmd.Process != nil && cmd.ProcessState == nil {
+			return true
+		}
+	}
+	return false
+}
+
 func AbortSeed(seedId string) error {
-	if cmd, ok := activeCommands[seedId]; ok {
+	if cmd, ok := getActiveCommand(seedId); ok {
 		log.Debugf("Killing process %d", cmd.Process.Pid)
 		return cmd.Process.Kill()
 	} else {
@@ -0,0 +1,173 @@
+package osagent
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/outbrain/orchestrator-agent/go/config"
+)
+
+const testLvsReport = `
+  {
+      "report": [
+          {
+              "lv": [
+                  {"lv_name":"mysql", "vg_name":"vg00", "lv_path":"/dev/vg00/mysql", "lv_size":"107374182400", "lv_attr":"owi-aos---", "origin":"", "pool_lv":"", "data_percent":"", "metadata_percent":"", "snap_percent":"", "lv_tags":"", "lv_time":"2015-06-01 10:00:00 +0000", "lv_active":"active"},
+                  {"lv_name":"mysql-snap 2015", "vg_name":"vg00", "lv_path":"/dev/vg00/mysql-snap 2015", "lv_size":"10737418240", "lv_attr":"swi-a-s---", "origin":"mysql", "pool_lv":"", "data_percent":"12.50", "metadata_percent":"", "snap_percent":"12.50", "lv_tags":"a,b", "lv_time":"2015-06-02 03:00:00 +0200", "lv_active":"active"},
+                  {"lv_name":"pool", "vg_name":"vg01", "lv_path":"", "lv_size":"53687091200", "lv_attr":"twi-aotz--", "origin":"", "pool_lv":"", "data_percent":"45.00", "metadata_percent":"3.10", "snap_percent":"", "lv_tags":"", "lv_time":"2015-06-01 10:00:00 +0000", "lv_active":"active"},
+                  {"lv_name":"thin-snap", "vg_name":"vg01", "lv_path":"/dev/vg01/thin-snap", "lv_size":"21474836480", "lv_attr":"Vwi---tz-k", "origin":"thin", "pool_lv":"pool", "data_percent":"", "metadata_percent":"", "snap_percent":"", "lv_tags":"", "lv_time":"2015-06-02 03:00:00 +0000", "lv_active":""},
+                  {"lv_name":"mysql-snap-old", "vg_name":"vg00", "lv_path":"/dev/vg00/mysql-snap-old", "lv_size":"10737418240", "lv_attr":"swi-I-s---", "origin":"mysql", "pool_lv":"", "data_percent":"100.00", "metadata_percent":"", "snap_percent":"100.00", "lv_tags":"", "lv_time":"2015-06-01 03:00:00 +0000", "lv_active":"active"}
+              ]
+          }
+      ]
+  }
+`
+
+func TestParseLogicalVolumesReport(t *testing.T) {
+	logicalVolumes, err := parseLogicalVolumesReport([]byte(testLvsReport))
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(logicalVolumes) != 5 {
+		t.Fatalf("Expected 5 logical volumes, got %d", len(logicalVolumes))
+	}
+	applyThinPoolUsage(logicalVolumes)
+
+	origin := logicalVolumes[0]
+	if origin.IsSnapshot || !origin.IsActive || origin.Size != 107374182400 {
+		t.Errorf("Unexpected origin volume: %+v", origin)
+	}
+
+	snapshot := logicalVolumes[1]
+	if snapshot.Name != "mysql-snap 2015" || snapshot.Path != "/dev/vg00/mysql-snap 2015" {
+		t.Errorf("Unexpected snapshot name/path: %+v", snapshot)
+	}
+	if !snapshot.IsSnapshot || snapshot.IsThinSnapshot || !snapshot.IsSnapshotValid() || snapshot.SnapshotPercent != 12.5 || snapshot.Origin != "mysql" {
+		t.Errorf("Unexpected snapshot: %+v", snapshot)
+	}
+	if len(snapshot.Tags) != 2 || snapshot.Tags[1] != "b" {
+		t.Errorf("Unexpected tags: %+v", snapshot.Tags)
+	}
+	if snapshot.CreationTime.UTC().Hour() != 1 {
+		t.Errorf("Unexpected creation time: %+v", snapshot.CreationTime)
+	}
+
+	thinSnapshot := logicalVolumes[3]
+	if !thinSnapshot.IsSnapshot || !thinSnapshot.IsThinSnapshot || thinSnapshot.IsActive || thinSnapshot.ThinPool != "pool" {
+		t.Errorf("Unexpected thin snapshot: %+v", thinSnapshot)
+	}
+	if thinSnapshot.SnapshotPercent != 45.0 {
+		t.Errorf("Expected thin snapshot to report pool usage, got %+v", thinSnapshot.SnapshotPercent)
+	}
+
+	if invalidSnapshot := logicalVolumes[4]; !invalidSnapshot.IsSnapshot || invalidSnapshot.IsSnapshotValid() {
+		t.Errorf("Expected invalid snapshot: %+v", invalidSnapshot)
+	}
+}
+
+func TestIsSnapshotValid(t *testing.T) {
+	if (&LogicalVolume{IsSnapshot: true, Attributes: "swi-I-s---"}).IsSnapshotValid() {
+		t.Errorf("Expected invalid snapshot")
+	}
+	if !(&LogicalVolume{IsSnapshot: true, Attributes: "swi-a-s---", SnapshotPercent: 12.5}).IsSnapshotValid() {
+		t.Errorf("Expected valid snapshot")
+	}
+	if !(&LogicalVolume{IsSnapshot: true, Attributes: "Swi-a-s---", SnapshotPercent: 12.5}).IsSnapshotValid() {
+		t.Errorf("Expected merging snapshot to be valid")
+	}
+	if (&LogicalVolume{IsSnapshot: true, SnapshotPercent: 100}).IsSnapshotValid() {
+		t.Errorf("Expected full snapshot to be invalid")
+	}
+	if (&LogicalVolume{IsSnapshot: false}).IsSnapshotValid() {
+		t.Errorf("Expected non snapshot to be invalid")
+	}
+}
+
+func TestIsAgentSnapshot(t *testing.T) {
+	defer func(tag, filter string) {
+		config.Config.SnapshotVolumesTag, config.Config.SnapshotVolumesFilter = tag, filter
+	}(config.Config.SnapshotVolumesTag, config.Config.SnapshotVolumesFilter)
+	config.Config.SnapshotVolumesTag = "orchestrator-agent"
+	config.Config.SnapshotVolumesFilter = "-mysql-snap-"
+
+	tagged := LogicalVolume{Name: "daily", IsSnapshot: true, Tags: []string{"orchestrator-agent", "orchestrator-agent-purpose=scheduled"}}
+	if !tagged.IsAgentSnapshot() {
+		t.Errorf("Expected tagged snapshot to be identified")
+	}
+	if purpose := tagged.TagValue("purpose"); purpose != "scheduled" {
+		t.Errorf("Unexpected purpose: %s", purpose)
+	}
+	legacy := LogicalVolume{Name: "data-mysql-snap-20150601", IsSnapshot: true}
+	if !legacy.IsAgentSnapshot() {
+		t.Errorf("Expected legacy snapshot to be identified by name")
+	}
+	unrelated := LogicalVolume{Name: "home-snap", IsSnapshot: true, Tags: []string{"backup"}}
+	if unrelated.IsAgentSnapshot() {
+		t.Errorf("Unexpected identification of unrelated snapshot")
+	}
+	notSnapshot := LogicalVolume{Name: "data-mysql-snap-volume", Tags: []string{"orchestrator-agent"}}
+	if notSnapshot.IsAgentSnapshot() {
+		t.Errorf("Unexpected identification of non snapshot volume")
+	}
+}
+
+func TestValidSnapshotTagValue(t *testing.T) {
+	for _, value := range []string{"api", "scheduled", "pre-upgrade_5.7", "vg/data=x:y+1"} {
+		if !ValidSnapshotTagValue(value) {
+			t.Errorf("Expected valid tag value: %s", value)
+		}
+	}
+	for _, value := range []string{"", "x;rm -rf /", "a b", "$(reboot)", "`id`", "x|y", "x&reboot", "x#", "!!"} {
+		if ValidSnapshotTagValue(value) {
+			t.Errorf("Expected invalid tag value: %s", value)
+		}
+	}
+}
+
+func TestActiveCommands(t *testing.T) {
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	defer func() {
+		activeCommandsMutex.Lock()
+		delete(activeCommands, "test-seed")
+		activeCommandsMutex.Unlock()
+	}()
+
+	registered := make(chan bool)
+	go func() {
+		setActiveCommand("test-seed", cmd)
+		close(registered)
+	}()
+	select {
+	case <-registered:
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatalf("Timed out registering seed command")
+	}
+	if registeredCmd, ok := getActiveCommand("test-seed"); !ok || registeredCmd != cmd {
+		t.Errorf("Expected registered seed command, got %+v", registeredCmd)
+	}
+	if !SeedInProgress() {
+		t.Errorf("Expected seed in progress")
+	}
+	if err := AbortSeed("test-seed"); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	cmd.Wait()
+	if SeedInProgress() {
+		t.Errorf("Unexpected seed in progress after abort")
+	}
+	if SeedCommandSucceeded("test-seed") {
+		t.Errorf("Unexpected success of aborted seed command")
+	}
+}
+
+func TestExtendLVCommands(t *testing.T) {
+	extendCommand, fallbackCommand := extendLVCommands("/dev/vg00/mysql-snap", 20)
+	if extendCommand != "lvextend -l +20%LV /dev/vg00/mysql-snap" || fallbackCommand != "lvextend -l +100%FREE /dev/vg00/mysql-snap" {
+		t.Errorf("Unexpected extend commands: %s; %s", extendCommand, fallbackCommand)
+	}
+}
@@ -0,0 +1,405 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/outbrain/orchestrator-agent/go/config"
+	"github.com/outbrain/orchestrator-agent/go/inst"
+)
+
+const pitrDatetimeFormat = "2006-01-02 15:04:05"
+
+var (
+	mysqlbinlogPositionPattern = regexp.MustCompile(`^# at ([0-9]+)$`)
+	mysqlbinlogGTIDNextPattern = regexp.MustCompile(`^SET @@SESSION.GTID_NEXT= '([^']+)'`)
+	binlogFileNumberPattern    = regexp.MustCompile(`\.[0-9]+$`)
+)
+
+// PointInTimeRecoveryTarget is where point-in-time recovery stops. Exactly one of the fields is expected.
+// Recovery stops right before the target: events at or after the datetime, at or after the coordinates,
+// or the transaction of the GTID and onwards, are not applied.
+type PointInTimeRecoveryTarget struct {
+	Datetime    string
+	Coordinates *inst.BinlogCoordinates
+	GTID        string
+}
+
+// PointInTimeRecoveryFile describes the application of a single binary log
+type PointInTimeRecoveryFile struct {
+	LogFile       string
+	StartPosition int64
+	StopPosition  int64
+	Applied       bool
+}
+
+// PointInTimeRecoveryDetails describes a point-in-time recovery
+type PointInTimeRecoveryDetails struct {
+	StartCoordinates inst.BinlogCoordinates
+	Target           PointInTimeRecoveryTarget
+	Files            []PointInTimeRecoveryFile
+	ReachedTarget    bool
+}
+
+// selectBinlogFiles returns, out of given binary log file names, the consecutive files starting with the
+// start file and ending with the stop file (or the last file, if stopFile is empty), ordered by file number
+func selectBinlogFiles(fileNames []string, startFile string, stopFile string) ([]string, error) {
+	startCoordinates := inst.BinlogCoordinates{LogFile: startFile}
+	startNumber, _ := startCoordinates.FileNumber()
+	stopNumber := -1
+	if stopFile != "" {
+		stopCoordinates := inst.BinlogCoordinates{LogFile: stopFile}
+		stopNumber, _ = stopCoordinates.FileNumber()
+	}
+	baseName := strings.TrimSuffix(startFile, binlogFileNumberPattern.FindString(startFile))
+
+	selected := map[int]string{}
+	numbers := []int{}
+	for _, fileName := range fileNames {
+		if !binlogFileNumberPattern.MatchString(fileName) || strings.TrimSuffix(fileName, binlogFileNumberPattern.FindString(fileName)) != baseName {
+			continue
+		}
+		coordinates := inst.BinlogCoordinates{LogFile: fileName}
+		number, _ := coordinates.FileNumber()
+		if number < startNumber || (stopNumber >= 0 && number > stopNumber) {
+			continue
+		}
+		selected[number] = fileName
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	if len(numbers) == 0 || numbers[0] != startNumber {
+		return nil, fmt.Errorf("Binary log %s not found", startFile)
+	}
+	result := []string{}
+	for i, number := range numbers {
+		if number != startNumber+i {
+			return nil, fmt.Errorf("Binary log sequence is broken: file number %d missing", startNumber+i)
+		}
+		result = append(result, selected[number])
+	}
+	if stopNumber >= 0 && numbers[len(numbers)-1] != stopNumber {
+		return nil, fmt.Errorf("Binary log %s not found", stopFile)
+	}
+	return result, nil
+}
+
+// archivedBinlogFiles lists the binary logs in BinlogArchiveDirectory needed to get from the start file to
+// the stop file (or to the last archived file, if stopFile is empty)
+func archivedBinlogFiles(startFile string, stopFile string) ([]string, error) {
+	if config.Config.BinlogArchiveDirectory == "" {
+		return nil, errors.New("BinlogArchiveDirectory is not configured")
+	}
+	paths, err := filepath.Glob(path.Join(config.Config.BinlogArchiveDirectory, "*"))
+	if err != nil {
+		return nil, err
+	}
+	fileNames := []string{}
+	for _, filePath := range paths {
+		fileNames = append(fileNames, path.Base(filePath))
+	}
+	return selectBinlogFiles(fileNames, startFile, stopFile)
+}
+
+// findGTIDEventPosition scans mysqlbinlog textual output for the GTID event of given transaction,
+// returning the position at which the event starts
+func findGTIDEventPosition(mysqlbinlogOutput io.Reader, gtid string) (position int64, found bool, err error) {
+	scanner := bufio.NewScanner(mysqlbinlogOutput)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if submatch := mysqlbinlogPositionPattern.FindStringSubmatch(line); submatch != nil {
+			position, _ = strconv.ParseInt(submatch[1], 10, 0)
+			continue
+		}
+		if submatch := mysqlbinlogGTIDNextPattern.FindStringSubmatch(line); submatch != nil {
+			if strings.EqualFold(submatch[1], gtid) {
+				return position, true, nil
+			}
+		}
+	}
+	return 0, false, scanner.Err()
+}
+
+// binlogGTIDEventPosition returns the position of the GTID event of given transaction within a binary log
+func binlogGTIDEventPosition(binlogPath string, gtid string) (position int64, found bool, err error) {
+	cmd, tmpFileName, err := execCmd(sudoCmd(fmt.Sprintf("mysqlbinlog %s", binlogPath)))
+	if err != nil {
+		return 0, false, err
+	}
+	defer os.Remove(tmpFileName)
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, false, err
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, false, err
+	}
+	position, found, err = findGTIDEventPosition(output, gtid)
+	if found {
+		// No need to read further
+		cmd.Process.Kill()
+		cmd.Wait()
+		return position, found, err
+	}
+	if waitErr := cmd.Wait(); err == nil {
+		err = waitErr
+	}
+	return position, found, err
+}
+
+// mysqlbinlogCommand returns the mysqlbinlog command decoding given binary logs, in order, from the start
+// position of the first file to the stop position of the last file. All files go through a single
+// mysqlbinlog, so that temporary tables and transactions spanning files are applied correctly.
+func mysqlbinlogCommand(binlogPaths []string, startPosition int64, stopPosition int64, stopDatetime string) string {
+	cmd := "mysqlbinlog"
+	if startPosition != 0 {
+		cmd = fmt.Sprintf("%s --start-position=%d", cmd, startPosition)
+	}
+	if stopPosition != 0 {
+		cmd = fmt.Sprintf("%s --stop-position=%d", cmd, stopPosition)
+	}
+	if stopDatetime != "" {
+		cmd = fmt.Sprintf(`%s --stop-datetime="%s"`, cmd, stopDatetime)
+	}
+	return sudoCmd(fmt.Sprintf("%s %s", cmd, strings.Join(binlogPaths, " ")))
+}
+
+// copyMySQLBinlogOutput copies mysqlbinlog textual output of consecutive binary logs to given writer,
+// calling fileCopied with the index of each file but the last once all of its output has been copied. A file
+// ends where the event positions (`# at N` lines) go back, which is where the next file starts.
+// The index of the last file is returned.
+func copyMySQLBinlogOutput(mysqlbinlogOutput io.Reader, writer io.Writer, fileCopied func(fileIndex int)) (lastFileIndex int, err error) {
+	reader := bufio.NewReader(mysqlbinlogOutput)
+	fileIndex := 0
+	lastPosition := int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if submatch := mysqlbinlogPositionPattern.FindSubmatch(bytes.TrimRight(line, "\n")); submatch != nil {
+			position, _ := strconv.ParseInt(string(submatch[1]), 10, 0)
+			if position < lastPosition {
+				fileCopied(fileIndex)
+				fileIndex++
+			}
+			lastPosition = position
+		}
+		if _, writeErr := writer.Write(line); writeErr != nil {
+			return fileIndex, writeErr
+		}
+		if err == io.EOF {
+			return fileIndex, nil
+		}
+		if err != nil {
+			return fileIndex, err
+		}
+	}
+}
+
+// applyBinlogFiles pipes mysqlbinlog output of given binary logs into PointInTimeRecoveryMySQLCommand, calling
+// fileApplied with the index of each file once all of its output has been handed to mysql; for the last file,
+// once mysql has successfully exited
+func applyBinlogFiles(binlogPaths []string, startPosition int64, stopPosition int64, stopDatetime string, fileApplied func(fileIndex int)) error {
+	binlogCmd, binlogTmpFileName, err := execCmd(mysqlbinlogCommand(binlogPaths, startPosition, stopPosition, stopDatetime))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(binlogTmpFileName)
+	mysqlCmd, mysqlTmpFileName, err := execCmd(config.Config.PointInTimeRecoveryMySQLCommand)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(mysqlTmpFileName)
+
+	var binlogStderr, mysqlOutput bytes.Buffer
+	binlogCmd.Stderr = &binlogStderr
+	mysqlCmd.Stdout = &mysqlOutput
+	mysqlCmd.Stderr = &mysqlOutput
+	binlogOutput, err := binlogCmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	mysqlInput, err := mysqlCmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := mysqlCmd.Start(); err != nil {
+		return err
+	}
+	if err := binlogCmd.Start(); err != nil {
+		mysqlInput.Close()
+		mysqlCmd.Wait()
+		return err
+	}
+	lastFileIndex, copyErr := copyMySQLBinlogOutput(binlogOutput, mysqlInput, fileApplied)
+	// Closing the pipes lets either process end, should the other have failed
+	binlogOutput.Close()
+	mysqlInput.Close()
+	binlogErr := binlogCmd.Wait()
+	mysqlErr := mysqlCmd.Wait()
+	// A failing mysql also fails mysqlbinlog, on a broken pipe: mysql's error comes first
+	if mysqlErr != nil {
+		return fmt.Errorf("mysql: %+v: %s", mysqlErr, strings.TrimSpace(mysqlOutput.String()))
+	}
+	if binlogErr != nil {
+		return fmt.Errorf("mysqlbinlog: %+v: %s", binlogErr, strings.TrimSpace(binlogStderr.String()))
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+	fileApplied(lastFileIndex)
+	return nil
+}
+
+// PointInTimeRecovery rolls a restored MySQL server forward, from given binary log coordinates up to the
+// target, by applying binary logs from BinlogArchiveDirectory with mysqlbinlog | mysql. MySQL must be running.
+// The files needed are first determined (for a GTID target, by scanning for its transaction), then applied
+// through a single mysqlbinlog, with progress reported per file. Validation is synchronous; the recovery
+// itself runs as a job.
+func PointInTimeRecovery(startCoordinates inst.BinlogCoordinates, target PointInTimeRecoveryTarget) (*Job, error) {
+	if startCoordinates.IsEmpty() {
+		return nil, errors.New("Empty start coordinates in PointInTimeRecovery")
+	}
+	targets := 0
+	if target.Datetime != "" {
+		if _, err := time.Parse(pitrDatetimeFormat, target.Datetime); err != nil {
+			return nil, fmt.Errorf("Invalid target datetime %s; expected format is %s", target.Datetime, pitrDatetimeFormat)
+		}
+		targets++
+	}
+	if target.Coordinates != nil {
+		if target.Coordinates.SmallerThan(&startCoordinates) {
+			return nil, fmt.Errorf("Target coordinates %s precede start coordinates %s", target.Coordinates.DisplayString(), startCoordinates.DisplayString())
+		}
+		targets++
+	}
+	if target.GTID != "" {
+		targets++
+	}
+	if targets != 1 {
+		return nil, errors.New("Exactly one of target datetime, coordinates or GTID is expected")
+	}
+	if running, err := MySQLRunning(); !running {
+		return nil, fmt.Errorf("MySQL is not running; cannot apply binary logs: %+v", err)
+	}
+	if JobInProgress("pitr") {
+		return nil, errors.New("A point-in-time recovery is already in progress")
+	}
+	stopFile := ""
+	if target.Coordinates != nil {
+		stopFile = target.Coordinates.LogFile
+	}
+	binlogFiles, err := archivedBinlogFiles(startCoordinates.LogFile, stopFile)
+	if err != nil {
+		return nil, err
+	}
+
+	details := &PointInTimeRecoveryDetails{
+		StartCoordinates: startCoordinates,
+		Target:           target,
+	}
+	for _, binlogFile := range binlogFiles {
+		details.Files = append(details.Files, PointInTimeRecoveryFile{LogFile: binlogFile})
+	}
+	details.Files[0].StartPosition = startCoordinates.LogPos
+	if target.Coordinates != nil {
+		details.Files[len(details.Files)-1].StopPosition = target.Coordinates.LogPos
+	}
+
+	job := NewJob("pitr", startCoordinates.DisplayString())
+	job.SetDetails(*details)
+	job.Run(func(job *Job) error {
+		defer func() { job.SetDetails(*details) }()
+
+		if target.GTID != "" {
+			for i := range details.Files {
+				file := &details.Files[i]
+				job.SetPhase(fmt.Sprintf("scan %s", file.LogFile))
+				position, found, err := binlogGTIDEventPosition(path.Join(config.Config.BinlogArchiveDirectory, file.LogFile), target.GTID)
+				if err != nil {
+					return err
+				}
+				if found {
+					if position <= file.StartPosition {
+						return fmt.Errorf("Target GTID %s precedes start coordinates %s", target.GTID, startCoordinates.DisplayString())
+					}
+					file.StopPosition = position
+					details.Files = details.Files[:i+1]
+					details.ReachedTarget = true
+					break
+				}
+			}
+		}
+		if target.Datetime != "" {
+			lastFile := details.Files[len(details.Files)-1].LogFile
+			job.SetPhase(fmt.Sprintf("scan %s", lastFile))
+			_, lastEventTime, err := binlogEventTimeRange(path.Join(config.Config.BinlogArchiveDirectory, lastFile))
+			if err != nil {
+				return err
+			}
+			targetTime, _ := time.ParseInLocation(pitrDatetimeFormat, target.Datetime, time.Local)
+			// The target is reached when the archived binary logs have an event at or after it
+			details.ReachedTarget = !lastEventTime.Before(targetTime)
+		}
+		if target.Coordinates != nil {
+			details.ReachedTarget = true
+		}
+		job.SetDetails(*details)
+
+		binlogPaths := []string{}
+		for _, file := range details.Files {
+			binlogPaths = append(binlogPaths, path.Join(config.Config.BinlogArchiveDirectory, file.LogFile))
+		}
+		job.SetPhase(fmt.Sprintf("apply %s", details.Files[0].LogFile))
+		err := applyBinlogFiles(binlogPaths, details.Files[0].StartPosition, details.Files[len(details.Files)-1].StopPosition, target.Datetime, func(fileIndex int) {
+			if fileIndex >= len(details.Files) {
+				return
+			}
+			details.Files[fileIndex].Applied = true
+			job.SetProgress(100 * float64(fileIndex+1) / float64(len(details.Files)))
+			if fileIndex+1 < len(details.Files) {
+				job.SetPhase(fmt.Sprintf("apply %s", details.Files[fileIndex+1].LogFile))
+			}
+			job.SetDetails(*details)
+		})
+		if err != nil {
+			return fmt.Errorf("Failed applying binary logs: %+v", err)
+		}
+
+		if !details.ReachedTarget {
+			if target.GTID != "" {
+				return fmt.Errorf("Target GTID %s not found in archived binary logs; all %d files applied", target.GTID, len(details.Files))
+			}
+			return fmt.Errorf("Target datetime %s is past the last archived event; all %d files applied", target.Datetime, len(details.Files))
+		}
+		job.SetPhase("done")
+		return nil
+	})
+	return job, nil
+}
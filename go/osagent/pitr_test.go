@@ -0,0 +1,98 @@
+package osagent
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testMySQLBinlogOutput = `# at 4
+#200101 10:00:00 server id 1  end_log_pos 125 CRC32 0x1a2b3c4d 	Start: binlog v 4, server v 8.0.20 created 200101 10:00:00
+# at 125
+#200101 10:00:00 server id 1  end_log_pos 196 CRC32 0x1a2b3c4d 	Previous-GTIDs
+# 3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5
+# at 196
+#200101 10:00:01 server id 1  end_log_pos 275 CRC32 0x1a2b3c4d 	GTID	last_committed=0	sequence_number=1
+SET @@SESSION.GTID_NEXT= '3e11fa47-71ca-11e1-9e33-c80aa9429562:6'/*!*/;
+# at 275
+#200101 10:00:01 server id 1  end_log_pos 350 CRC32 0x1a2b3c4d 	Query	thread_id=8	exec_time=0	error_code=0
+BEGIN
+/*!*/;
+# at 420
+#200101 10:00:02 server id 1  end_log_pos 499 CRC32 0x1a2b3c4d 	GTID	last_committed=1	sequence_number=2
+SET @@SESSION.GTID_NEXT= '3e11fa47-71ca-11e1-9e33-c80aa9429562:7'/*!*/;
+`
+
+func TestFindGTIDEventPosition(t *testing.T) {
+	position, found, err := findGTIDEventPosition(strings.NewReader(testMySQLBinlogOutput), "3E11FA47-71CA-11E1-9E33-C80AA9429562:7")
+	if err != nil || !found || position != 420 {
+		t.Errorf("Unexpected result: %d, %t, %+v", position, found, err)
+	}
+	if _, found, _ := findGTIDEventPosition(strings.NewReader(testMySQLBinlogOutput), "3e11fa47-71ca-11e1-9e33-c80aa9429562:8"); found {
+		t.Errorf("Unexpected GTID found")
+	}
+}
+
+func TestSelectBinlogFiles(t *testing.T) {
+	fileNames := []string{"mysql-bin.000012", "mysql-bin.000010", "mysql-bin.index", "mysql-bin.000011", "relay-bin.000011", "mysql-bin.000013"}
+	files, err := selectBinlogFiles(fileNames, "mysql-bin.000011", "")
+	if err != nil || strings.Join(files, ",") != "mysql-bin.000011,mysql-bin.000012,mysql-bin.000013" {
+		t.Errorf("Unexpected result: %+v, %+v", files, err)
+	}
+	files, err = selectBinlogFiles(fileNames, "mysql-bin.000010", "mysql-bin.000012")
+	if err != nil || strings.Join(files, ",") != "mysql-bin.000010,mysql-bin.000011,mysql-bin.000012" {
+		t.Errorf("Unexpected result: %+v, %+v", files, err)
+	}
+	if _, err := selectBinlogFiles(fileNames, "mysql-bin.000009", ""); err == nil {
+		t.Errorf("Expected error on missing start file")
+	}
+	if _, err := selectBinlogFiles(fileNames, "mysql-bin.000011", "mysql-bin.000014"); err == nil {
+		t.Errorf("Expected error on missing stop file")
+	}
+	if _, err := selectBinlogFiles([]string{"mysql-bin.000010", "mysql-bin.000012"}, "mysql-bin.000010", ""); err == nil {
+		t.Errorf("Expected error on broken sequence")
+	}
+}
+
+func TestMySQLBinlogCommand(t *testing.T) {
+	command := mysqlbinlogCommand([]string{"/archive/mysql-bin.000011", "/archive/mysql-bin.000012"}, 120, 4000, "2020-01-01 10:00:00")
+	if command != `mysqlbinlog --start-position=120 --stop-position=4000 --stop-datetime="2020-01-01 10:00:00" /archive/mysql-bin.000011 /archive/mysql-bin.000012` {
+		t.Errorf("Unexpected command: %s", command)
+	}
+	if command := mysqlbinlogCommand([]string{"/archive/mysql-bin.000011"}, 0, 0, ""); command != "mysqlbinlog /archive/mysql-bin.000011" {
+		t.Errorf("Unexpected command: %s", command)
+	}
+}
+
+func TestCopyMySQLBinlogOutput(t *testing.T) {
+	mysqlbinlogOutput := `# at 4
+#200101 10:00:00 server id 1  end_log_pos 120 CRC32 0x00000000 	Start: binlog v 4
+# at 120
+INSERT INTO t VALUES (1)
+# at 340
+# at 4
+#200101 11:00:00 server id 1  end_log_pos 120 CRC32 0x00000000 	Start: binlog v 4
+# at 120
+INSERT INTO t VALUES (2)
+# at 4
+#200101 12:00:00 server id 1  end_log_pos 120 CRC32 0x00000000 	Start: binlog v 4
+INSERT INTO t VALUES (3)`
+	var copied bytes.Buffer
+	filesCopied := []int{}
+	lastFileIndex, err := copyMySQLBinlogOutput(strings.NewReader(mysqlbinlogOutput), &copied, func(fileIndex int) {
+		if !strings.Contains(copied.String(), fmt.Sprintf("VALUES (%d)", fileIndex+1)) || strings.Contains(copied.String(), fmt.Sprintf("VALUES (%d)", fileIndex+2)) {
+			t.Errorf("File %d reported copied before all of its output: %s", fileIndex, copied.String())
+		}
+		filesCopied = append(filesCopied, fileIndex)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if copied.String() != mysqlbinlogOutput {
+		t.Errorf("Unexpected copied output: %s", copied.String())
+	}
+	if len(filesCopied) != 2 || filesCopied[0] != 0 || filesCopied[1] != 1 || lastFileIndex != 2 {
+		t.Errorf("Unexpected files copied: %+v, last file %d", filesCopied, lastFileIndex)
+	}
+}
@@ -0,0 +1,203 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/orchestrator-agent/go/config"
+)
+
+const restoreProgressInterval = 5 * time.Second
+
+// LocalRestoreDetails describes a restore of MySQL data from a locally mounted snapshot into the datadir
+type LocalRestoreDetails struct {
+	SeedId         string
+	SourcePath     string
+	DataDir        string
+	CopyMethod     string
+	SourceFiles    int64
+	SourceBytes    int64
+	RestoredFiles  int64
+	RestoredBytes  int64
+	PostCopyPassed bool
+}
+
+// DirectoryFileStats returns the number of regular files under given directory, and their total size in bytes
+func DirectoryFileStats(directory string) (files int64, bytes int64, err error) {
+	output, err := commandOutput(sudoCmd(fmt.Sprintf(`find %s -type f -printf "%%s\n"`, directory)))
+	lines, err := outputLines(output, err)
+	if err != nil {
+		return files, bytes, err
+	}
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		size, err := strconv.ParseInt(line, 10, 0)
+		if err != nil {
+			return files, bytes, err
+		}
+		files++
+		bytes += size
+	}
+	return files, bytes, nil
+}
+
+// isEmptyDirectory returns true when given directory has no entries
+func isEmptyDirectory(directory string) (bool, error) {
+	output, err := commandOutput(sudoCmd(fmt.Sprintf("ls -A %s", directory)))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(output)) == "", nil
+}
+
+// isSameFileSystem returns true when both paths reside on the same mounted file system
+func isSameFileSystem(path1 string, path2 string) bool {
+	mount1, err := FindMountOf(path1)
+	if err != nil {
+		return false
+	}
+	mount2, err := FindMountOf(path2)
+	if err != nil {
+		return false
+	}
+	return mount1.MajorMinor == mount2.MajorMinor
+}
+
+// localRestoreCopyCommand returns the command copying source directory content into target directory,
+// as per LocalRestoreCopyMethod
+func localRestoreCopyCommand(sourcePath string, targetPath string) (method string, command string) {
+	method = config.Config.LocalRestoreCopyMethod
+	if method == "hardlink" && !isSameFileSystem(sourcePath, targetPath) {
+		log.Warningf("Cannot hardlink %s into %s: different file systems. Using reflink", sourcePath, targetPath)
+		method = "reflink"
+	}
+	switch method {
+	case "hardlink":
+		command = fmt.Sprintf("cp -al %s/. %s/", sourcePath, targetPath)
+	case "copy":
+		command = fmt.Sprintf("cp -a %s/. %s/", sourcePath, targetPath)
+	default:
+		// reflinks where the file system supports them, plain copy otherwise
+		method = "reflink"
+		command = fmt.Sprintf("cp -a --reflink=auto %s/. %s/", sourcePath, targetPath)
+	}
+	return method, sudoCmd(command)
+}
+
+// monitorLocalRestoreProgress reports restore progress by datadir growth, until done is closed
+func monitorLocalRestoreProgress(job *Job, details *LocalRestoreDetails, done chan bool) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(restoreProgressInterval):
+		}
+		if restoredBytes, err := DiskUsage(details.DataDir); err == nil && details.SourceBytes > 0 {
+			progress := 100 * float64(restoredBytes) / float64(details.SourceBytes)
+			if progress > 99 {
+				progress = 99
+			}
+			job.SetProgress(progress)
+		}
+	}
+}
+
+// LocalRestoreMySQLSeedData copies MySQL data of the snapshot mounted on SnapshotMountPoint into the MySQL
+// datadir, as an alternative to seeding the host from itself over the network. The copy command is tracked
+// by seedId, like network seeds; the complete restore (copy, verification, post-copy) runs as a job.
+// MySQL must not be running, and the datadir must be empty.
+func (this *MySQLInstance) LocalRestoreMySQLSeedData(seedId string) (*Job, error) {
+	if seedId == "" {
+		return nil, errors.New("Empty seedId in LocalRestoreMySQLSeedData")
+	}
+	if running, _ := this.MySQLRunning(); running {
+		return nil, errors.New("MySQL is running; refusing to restore")
+	}
+	mount, err := this.GetMount(config.Config.SnapshotMountPoint)
+	if err != nil {
+		return nil, err
+	}
+	if !mount.IsMounted || mount.MySQLDataPath == "" {
+		return nil, fmt.Errorf("No MySQL data found on %s", config.Config.SnapshotMountPoint)
+	}
+	directory, err := this.GetMySQLDataDir()
+	if err != nil {
+		return nil, err
+	}
+	if empty, err := isEmptyDirectory(directory); err != nil {
+		return nil, err
+	} else if !empty {
+		return nil, fmt.Errorf("MySQL datadir %s is not empty; refusing to restore", directory)
+	}
+
+	details := &LocalRestoreDetails{
+		SeedId:     seedId,
+		SourcePath: mount.MySQLDataPath,
+		DataDir:    directory,
+	}
+	job := NewJob("local-restore", mount.MySQLDataPath)
+	job.Run(func(job *Job) error {
+		var err error
+		defer func() { job.SetDetails(*details) }()
+
+		job.SetPhase("inspect")
+		if details.SourceFiles, details.SourceBytes, err = DirectoryFileStats(details.SourcePath); err != nil {
+			return err
+		}
+
+		job.SetPhase("copy")
+		var copyCommand string
+		details.CopyMethod, copyCommand = localRestoreCopyCommand(details.SourcePath, details.DataDir)
+		job.SetDetails(*details)
+		done := make(chan bool)
+		go monitorLocalRestoreProgress(job, details, done)
+		err = commandRun(copyCommand, func(cmd *exec.Cmd) {
+			setActiveCommand(seedId, cmd)
+		})
+		close(done)
+		if err != nil {
+			return err
+		}
+
+		job.SetPhase("verify")
+		if details.RestoredFiles, details.RestoredBytes, err = DirectoryFileStats(details.DataDir); err != nil {
+			return err
+		}
+		if details.RestoredFiles != details.SourceFiles || details.RestoredBytes != details.SourceBytes {
+			return fmt.Errorf("Restored data mismatch: source has %d files, %d bytes; datadir has %d files, %d bytes",
+				details.SourceFiles, details.SourceBytes, details.RestoredFiles, details.RestoredBytes)
+		}
+
+		job.SetPhase("post-copy")
+		if err := this.PostCopy(); err != nil {
+			return err
+		}
+		details.PostCopyPassed = true
+		job.SetPhase("done")
+		return nil
+	})
+	return job, nil
+}
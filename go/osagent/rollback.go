@@ -0,0 +1,206 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/orchestrator-agent/go/config"
+)
+
+var mergePollInterval = time.Second
+
+// rollbackMutex serializes the check for a running rollback with the registration of a new one
+var rollbackMutex = &sync.Mutex{}
+
+// RollbackDetails describes a snapshot rollback
+type RollbackDetails struct {
+	SnapshotPath string
+	OriginPath   string
+	MountPoint   string
+	MountOptions string
+}
+
+// isMerging returns true if this is an origin volume into which a snapshot is being merged
+func (this *LogicalVolume) isMerging() bool {
+	return len(this.Attributes) > 0 && this.Attributes[0] == 'O'
+}
+
+// rollbackInProgress returns true when a running rollback job merges into given origin
+func rollbackInProgress(originPath string) bool {
+	for _, job := range Jobs("rollback") {
+		if details, ok := job.Details.(RollbackDetails); ok && !job.IsComplete && details.OriginPath == originPath {
+			return true
+		}
+	}
+	return false
+}
+
+// remountOptions returns the options with which to remount given mount: its per-mount options, followed by
+// those file system specific super options not already given. ro/rw in the super options reflect the
+// superblock rather than the mount, and seclabel is reported by the kernel but not accepted by mount.
+func remountOptions(mount *Mount) string {
+	options := []string{}
+	given := make(map[string]bool)
+	for _, option := range strings.Split(mount.Options, ",") {
+		if option != "" {
+			options = append(options, option)
+			given[strings.SplitN(option, "=", 2)[0]] = true
+		}
+	}
+	for _, option := range strings.Split(mount.SuperOptions, ",") {
+		name := strings.SplitN(option, "=", 2)[0]
+		if option == "" || name == "ro" || name == "rw" || name == "seclabel" || given[name] {
+			continue
+		}
+		options = append(options, option)
+		given[name] = true
+	}
+	return strings.Join(options, ",")
+}
+
+// waitForMerge polls the merging snapshot until it is gone from its volume group, reporting progress in the job.
+// listVolumes lists the volume group. A failure to list the volume group fails the wait, as does the deadline.
+// Progress is computed from the decrease of the snapshot's usage relative to initialPercent; an initialPercent
+// of 0 reports no progress.
+func waitForMerge(job *Job, listVolumes func() ([]LogicalVolume, error), snapshotPath string, initialPercent float64, deadline time.Time) error {
+	for {
+		logicalVolumes, err := listVolumes()
+		if err != nil {
+			return fmt.Errorf("Cannot determine merge state of %s: %+v", snapshotPath, err)
+		}
+		var snapshot *LogicalVolume
+		for i := range logicalVolumes {
+			if logicalVolumes[i].Path == snapshotPath {
+				snapshot = &logicalVolumes[i]
+			}
+		}
+		if snapshot == nil {
+			// The snapshot is gone: merge is complete
+			return nil
+		}
+		if initialPercent > 0 {
+			job.SetProgress(100 * (initialPercent - snapshot.SnapshotPercent) / initialPercent)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Merge of %s not complete after %d seconds; LVM continues the merge in the background", snapshotPath, config.Config.SnapshotMergeTimeoutSeconds)
+		}
+		time.Sleep(mergePollInterval)
+	}
+}
+
+// RollbackSnapshot rolls back the MySQL datadir's logical volume to given snapshot: it verifies MySQL is
+// not running, unmounts the origin, merges the snapshot into it (lvconvert --merge), waits for the merge to
+// complete and remounts the origin. The snapshot no longer exists after the rollback.
+// Validation is synchronous; the rollback itself runs as a job. Only one rollback may run per origin.
+func (this *MySQLInstance) RollbackSnapshot(snapshotName string) (*Job, error) {
+	if snapshotName == "" {
+		return nil, errors.New("Empty snapshot name in RollbackSnapshot")
+	}
+	if running, _ := this.MySQLRunning(); running {
+		return nil, errors.New("MySQL is running; refusing to roll back")
+	}
+	logicalVolumes, err := LogicalVolumes(snapshotName, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(logicalVolumes) == 0 || !logicalVolumes[0].IsSnapshot {
+		return nil, fmt.Errorf("Not a snapshot: %s", snapshotName)
+	}
+	snapshot := logicalVolumes[0]
+	if !snapshot.IsSnapshotValid() {
+		return nil, fmt.Errorf("Snapshot %s is invalid and cannot be merged", snapshot.Path)
+	}
+
+	origin, err := this.GetMySQLDataDirLogicalVolume()
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.GroupName != origin.GroupName || snapshot.Origin != origin.Name {
+		return nil, fmt.Errorf("Snapshot %s is not a snapshot of the datadir volume %s", snapshot.Path, origin.Path)
+	}
+	directory, err := this.GetMySQLDataDir()
+	if err != nil {
+		return nil, err
+	}
+	mount, err := FindMountOf(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	details := RollbackDetails{
+		SnapshotPath: snapshot.Path,
+		OriginPath:   origin.Path,
+		MountPoint:   mount.Path,
+		MountOptions: remountOptions(mount),
+	}
+	// A thin snapshot's usage is that of its pool, which says nothing of the merge: no progress is reported
+	initialPercent := snapshot.SnapshotPercent
+	if snapshot.IsThinSnapshot {
+		initialPercent = 0
+	}
+
+	rollbackMutex.Lock()
+	if rollbackInProgress(origin.Path) {
+		rollbackMutex.Unlock()
+		return nil, fmt.Errorf("A rollback into %s is already running", origin.Path)
+	}
+	job := NewJob("rollback", snapshot.Path)
+	job.SetDetails(details)
+	rollbackMutex.Unlock()
+	job.Run(func(job *Job) error {
+		job.SetPhase("unmount")
+		if _, err := commandOutput(sudoCmd(fmt.Sprintf("umount %s", details.MountPoint))); err != nil {
+			return err
+		}
+
+		job.SetPhase("merge")
+		if _, err := commandOutput(sudoCmd(fmt.Sprintf("lvconvert --merge %s", details.SnapshotPath))); err != nil {
+			return err
+		}
+		if logicalVolumes, err := LogicalVolumes(details.SnapshotPath, ""); err == nil && len(logicalVolumes) > 0 {
+			if originVolumes, err := LogicalVolumes(details.OriginPath, ""); err == nil && len(originVolumes) > 0 && !originVolumes[0].isMerging() {
+				// Merge is deferred while the origin is open; reactivating the origin starts it
+				log.Infof("Merge into %s deferred; reactivating origin", details.OriginPath)
+				if _, err := commandOutput(sudoCmd(fmt.Sprintf("lvchange -an %s", details.OriginPath))); err != nil {
+					return err
+				}
+				if _, err := commandOutput(sudoCmd(fmt.Sprintf("lvchange -ay %s", details.OriginPath))); err != nil {
+					return err
+				}
+			}
+		}
+		listVolumes := func() ([]LogicalVolume, error) { return LogicalVolumes(snapshot.GroupName, "") }
+		deadline := time.Now().Add(time.Duration(config.Config.SnapshotMergeTimeoutSeconds) * time.Second)
+		if err := waitForMerge(job, listVolumes, details.SnapshotPath, initialPercent, deadline); err != nil {
+			return err
+		}
+
+		job.SetPhase("mount")
+		if _, err := commandOutput(sudoCmd(fmt.Sprintf("mount -o %s %s %s", details.MountOptions, details.OriginPath, details.MountPoint))); err != nil {
+			return err
+		}
+		job.SetPhase("done")
+		return nil
+	})
+	return job, nil
+}
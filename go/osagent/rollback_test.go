@@ -0,0 +1,99 @@
+package osagent
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitForMerge(t *testing.T) {
+	defer func(interval time.Duration) { mergePollInterval = interval }(mergePollInterval)
+	mergePollInterval = time.Millisecond
+
+	snapshot := LogicalVolume{Path: "/dev/vg00/mysql-snap", SnapshotPercent: 10}
+	origin := LogicalVolume{Path: "/dev/vg00/mysql"}
+	polls := 0
+	listVolumes := func() ([]LogicalVolume, error) {
+		polls++
+		if polls < 3 {
+			snapshot.SnapshotPercent -= 4
+			return []LogicalVolume{origin, snapshot}, nil
+		}
+		return []LogicalVolume{origin}, nil
+	}
+	job := NewJob("test-merge", snapshot.Path)
+	if err := waitForMerge(job, listVolumes, snapshot.Path, 10, time.Now().Add(time.Minute)); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+	if polls != 3 {
+		t.Errorf("Expected 3 polls, got %d", polls)
+	}
+	if progress := job.State().Progress; progress != 80 {
+		t.Errorf("Unexpected progress: %f", progress)
+	}
+
+	failing := func() ([]LogicalVolume, error) { return nil, errors.New("lvs failed") }
+	if err := waitForMerge(job, failing, snapshot.Path, 10, time.Now().Add(time.Minute)); err == nil {
+		t.Errorf("Expected error on lvs failure")
+	}
+
+	merging := func() ([]LogicalVolume, error) { return []LogicalVolume{origin, snapshot}, nil }
+	if err := waitForMerge(job, merging, snapshot.Path, 10, time.Now().Add(5*time.Millisecond)); err == nil {
+		t.Errorf("Expected error on deadline")
+	}
+}
+
+func TestIsMerging(t *testing.T) {
+	if !(&LogicalVolume{Attributes: "Owi-a-s---"}).isMerging() {
+		t.Errorf("Expected merging origin")
+	}
+	if (&LogicalVolume{Attributes: "owi-aos---"}).isMerging() {
+		t.Errorf("Unexpected merging origin")
+	}
+}
+
+func TestWaitForMergeWithoutProgress(t *testing.T) {
+	snapshot := LogicalVolume{Path: "/dev/vg00/mysql-snap", SnapshotPercent: 40, IsThinSnapshot: true}
+	listVolumes := func() ([]LogicalVolume, error) { return []LogicalVolume{}, nil }
+	job := NewJob("test-merge", snapshot.Path)
+	if err := waitForMerge(job, listVolumes, snapshot.Path, 0, time.Now().Add(time.Minute)); err != nil {
+		t.Errorf("Unexpected error: %+v", err)
+	}
+	if progress := job.State().Progress; progress != 0 {
+		t.Errorf("Unexpected progress: %f", progress)
+	}
+}
+
+func TestRollbackInProgress(t *testing.T) {
+	done := make(chan bool)
+	job := NewJob("rollback", "/dev/vg00/mysql-snap")
+	job.SetDetails(RollbackDetails{SnapshotPath: "/dev/vg00/mysql-snap", OriginPath: "/dev/vg00/mysql"})
+	job.Run(func(job *Job) error {
+		<-done
+		return nil
+	})
+	if !rollbackInProgress("/dev/vg00/mysql") {
+		t.Errorf("Expected rollback in progress")
+	}
+	if rollbackInProgress("/dev/vg00/other") {
+		t.Errorf("Unexpected rollback in progress")
+	}
+	close(done)
+	for !job.State().IsComplete {
+		time.Sleep(time.Millisecond)
+	}
+	if rollbackInProgress("/dev/vg00/mysql") {
+		t.Errorf("Unexpected rollback in progress after completion")
+	}
+}
+
+func TestRemountOptions(t *testing.T) {
+	mount := &Mount{Options: "rw,noatime", SuperOptions: "rw,seclabel,attr2,inode64,noatime,logbsize=256k"}
+	if options := remountOptions(mount); options != "rw,noatime,attr2,inode64,logbsize=256k" {
+		t.Errorf("Unexpected options: %s", options)
+	}
+	mount = &Mount{Options: "ro,relatime", SuperOptions: ""}
+	if options := remountOptions(mount); options != "ro,relatime" {
+		t.Errorf("Unexpected options: %s", options)
+	}
+}
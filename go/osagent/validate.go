@@ -0,0 +1,378 @@
+/*
+   Copyright 2014 Outbrain Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package osagent
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/orchestrator-agent/go/config"
+)
+
+// SnapshotValidation describes a test-restore of a snapshot: a throwaway mysqld run on a writable throwaway
+// copy-on-write layer over the snapshot
+type SnapshotValidation struct {
+	SnapshotPath     string
+	ValidationVolume string
+	MountPoint       string
+	MySQLDataPath    string
+	Port             uint
+	Socket           string
+	ErrorLog         string
+	QueryResults     map[string]string
+	ErrorLogTail     []string
+	Passed           bool
+}
+
+// validationMySQLClient returns a mysql client command line, connecting via given socket
+func validationMySQLClient(client string, socket string) string {
+	return fmt.Sprintf("%s --no-defaults --protocol=socket --socket=%s --user=root", client, socket)
+}
+
+// waitForValidationMySQL waits until the throwaway mysqld answers ping, exits or times out.
+// InnoDB crash recovery takes place in the meantime.
+func waitForValidationMySQL(socket string, exited chan error) error {
+	timeout := time.After(time.Duration(config.Config.SnapshotValidationTimeoutSeconds) * time.Second)
+	for {
+		if _, err := commandOutput(sudoCmd(fmt.Sprintf("%s ping", validationMySQLClient("mysqladmin", socket)))); err == nil {
+			return nil
+		}
+		select {
+		case err := <-exited:
+			return fmt.Errorf("mysqld exited before becoming available: %+v", err)
+		case <-timeout:
+			return fmt.Errorf("mysqld not available after %d seconds", config.Config.SnapshotValidationTimeoutSeconds)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// recordSnapshotValidation tags the snapshot with the validation result, replacing previous results
+func recordSnapshotValidation(snapshot *LogicalVolume, validation *SnapshotValidation) error {
+	if config.Config.SnapshotVolumesTag == "" {
+		return nil
+	}
+	if logicalVolumes, err := LogicalVolumes(snapshot.Path, ""); err == nil && len(logicalVolumes) > 0 {
+		for _, key := range []string{"validated", "validated-at"} {
+			if value := logicalVolumes[0].TagValue(key); value != "" {
+				if err := RemoveLogicalVolumeTags(snapshot.Path, SnapshotTag(key, value)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	result := "fail"
+	if validation.Passed {
+		result = "pass"
+	}
+	return TagLogicalVolume(snapshot.Path, SnapshotTag("validated", result), SnapshotTag("validated-at", fmt.Sprintf("%d", time.Now().Unix())))
+}
+
+// validationInnoDBOptions are the server options describing the on-disk format of the data, which the
+// test-restore mysqld must share with the snapshot
+var validationInnoDBOptions = []string{
+	"innodb-page-size",
+	"innodb-data-file-path",
+	"innodb-log-file-size",
+	"innodb-log-files-in-group",
+	"innodb-undo-tablespaces",
+	"innodb-checksum-algorithm",
+	"innodb-file-per-table",
+	"lower-case-table-names",
+}
+
+// readValidationInnoDBOptions reads validationInnoDBOptions from given my.cnf. Other options, such as memory
+// settings and file paths of the production server, are not read.
+func readValidationInnoDBOptions(defaultsFile string) (map[string]string, error) {
+	innodbOptions := make(map[string]string)
+	if defaultsFile == "" {
+		return innodbOptions, nil
+	}
+	options := make(map[string]string)
+	if err := readMySQLConfigFile(defaultsFile, options, 0); err != nil {
+		return innodbOptions, err
+	}
+	for _, name := range validationInnoDBOptions {
+		if value, ok := options[name]; ok {
+			innodbOptions[name] = value
+		}
+	}
+	return innodbOptions, nil
+}
+
+// validationMySQLDCommand returns the command line of the throwaway mysqld. It runs with --no-defaults, so
+// that it inherits neither memory settings nor paths of the production server: all its files are in the
+// data path or next to its socket. Given InnoDB options are passed as --loose- options.
+func validationMySQLDCommand(validation *SnapshotValidation, innodbOptions map[string]string) string {
+	runDir := path.Dir(validation.Socket)
+	mysqldCommand := fmt.Sprintf("%s --no-defaults --datadir=%s --tmpdir=%s --port=%d --socket=%s --pid-file=%s --log-error=%s --user=%s --skip-networking --skip-grant-tables --skip-slave-start --skip-log-bin",
+		config.Config.SnapshotValidationMySQLDCommand, validation.MySQLDataPath, runDir, validation.Port, validation.Socket, path.Join(runDir, "mysqld.pid"), validation.ErrorLog, config.Config.SnapshotValidationMySQLUser)
+	names := []string{}
+	for name := range innodbOptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if value := innodbOptions[name]; value == "" {
+			mysqldCommand = fmt.Sprintf("%s --loose-%s", mysqldCommand, name)
+		} else {
+			mysqldCommand = fmt.Sprintf("%s --loose-%s=%s", mysqldCommand, name, value)
+		}
+	}
+	return mysqldCommand
+}
+
+// validationSnapshotName returns the name of the throwaway snapshot taken of given snapshot for validation
+func validationSnapshotName(snapshot *LogicalVolume) string {
+	return fmt.Sprintf("%s-validate", snapshot.Name)
+}
+
+// createValidationSnapshot takes a throwaway snapshot of given thin snapshot, which the test-restore mysqld
+// may freely write to. LVM only supports snapshots of thin snapshots.
+func createValidationSnapshot(snapshot *LogicalVolume) (string, error) {
+	name := validationSnapshotName(snapshot)
+	if _, err := commandOutput(sudoCmd(fmt.Sprintf("lvcreate --snapshot --setactivationskip n --activate y --name %s %s/%s", name, snapshot.GroupName, snapshot.Name))); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("/dev/%s/%s", snapshot.GroupName, name), nil
+}
+
+// validationOverlay is a writable device mapper snapshot of a classic LVM snapshot, which LVM cannot snapshot.
+// Writes go to a sparse file, attached as a loop device, so that the LVM snapshot is only read from.
+type validationOverlay struct {
+	name       string
+	device     string
+	loopDevice string
+	file       string
+}
+
+// validationOverlayName returns the device mapper name of the overlay of given snapshot
+func validationOverlayName(snapshot *LogicalVolume) string {
+	return fmt.Sprintf("orchestrator-agent-validate-%s-%s", snapshot.GroupName, snapshot.Name)
+}
+
+// validationOverlayTable returns the device mapper table of a non-persistent snapshot of given device,
+// sized in 512 byte sectors, storing changed 4KB chunks on given copy-on-write device
+func validationOverlayTable(sectors int64, device string, cowDevice string) string {
+	return fmt.Sprintf("0 %d snapshot %s %s N 8", sectors, device, cowDevice)
+}
+
+// createValidationOverlay creates a writable overlay of given classic snapshot. The copy-on-write file is
+// created in SnapshotValidationOverlayDirectory, sparse, with the snapshot's size so that it cannot overflow.
+func createValidationOverlay(snapshot *LogicalVolume) (*validationOverlay, error) {
+	output, err := commandOutput(sudoCmd(fmt.Sprintf("blockdev --getsz %s", snapshot.Path)))
+	if err != nil {
+		return nil, err
+	}
+	sectors, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read size of %s: %+v", snapshot.Path, err)
+	}
+
+	overlay := &validationOverlay{name: validationOverlayName(snapshot)}
+	file, err := ioutil.TempFile(config.Config.SnapshotValidationOverlayDirectory, "orchestrator-agent-validate-")
+	if err != nil {
+		return nil, err
+	}
+	overlay.file = file.Name()
+	err = file.Truncate(sectors * 512)
+	file.Close()
+	if err == nil {
+		output, err = commandOutput(sudoCmd(fmt.Sprintf("losetup --find --show %s", overlay.file)))
+		overlay.loopDevice = strings.TrimSpace(string(output))
+	}
+	if err == nil {
+		_, err = commandOutput(sudoCmd(fmt.Sprintf(`dmsetup create %s --table "%s"`, overlay.name, validationOverlayTable(sectors, snapshot.Path, overlay.loopDevice))))
+	}
+	if err != nil {
+		overlay.remove()
+		return nil, err
+	}
+	overlay.device = path.Join("/dev/mapper", overlay.name)
+	return overlay, nil
+}
+
+// remove tears the overlay down, discarding whatever was written to it
+func (this *validationOverlay) remove() error {
+	var err error
+	if this.device != "" {
+		if _, err = commandOutput(sudoCmd(fmt.Sprintf("dmsetup remove %s", this.name))); err != nil {
+			// the loop device and file are still in use
+			return err
+		}
+	}
+	if this.loopDevice != "" {
+		_, err = commandOutput(sudoCmd(fmt.Sprintf("losetup --detach %s", this.loopDevice)))
+	}
+	if removeErr := os.Remove(this.file); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// runSnapshotValidation starts mysqld on the mounted snapshot data, runs sanity queries and shuts mysqld down
+func runSnapshotValidation(job *Job, validation *SnapshotValidation) error {
+	innodbOptions, err := readValidationInnoDBOptions(config.Config.SnapshotValidationDefaultsFile)
+	if err != nil {
+		return fmt.Errorf("Cannot read InnoDB options from %s: %+v", config.Config.SnapshotValidationDefaultsFile, err)
+	}
+	cmd, tmpFileName, err := execCmd(sudoCmd(validationMySQLDCommand(validation, innodbOptions)))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFileName)
+
+	job.SetPhase("start mysqld")
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	defer func() {
+		job.SetPhase("shutdown mysqld")
+		commandOutput(sudoCmd(fmt.Sprintf("%s shutdown", validationMySQLClient("mysqladmin", validation.Socket))))
+		select {
+		case <-exited:
+		case <-time.After(time.Duration(config.Config.SnapshotValidationTimeoutSeconds) * time.Second):
+			log.Warningf("mysqld did not shut down; killing process %d", cmd.Process.Pid)
+			cmd.Process.Kill()
+		}
+		validation.ErrorLogTail, _ = outputLines(commandOutput(sudoCmd(fmt.Sprintf("tail -n 20 %s", validation.ErrorLog))))
+	}()
+
+	job.SetPhase("recovery")
+	if err := waitForValidationMySQL(validation.Socket, exited); err != nil {
+		return err
+	}
+
+	job.SetPhase("queries")
+	for _, query := range config.Config.SnapshotValidationQueries {
+		output, err := commandOutput(sudoCmd(fmt.Sprintf(`%s -N -e "%s"`, validationMySQLClient("mysql", validation.Socket), query)))
+		if err != nil {
+			return fmt.Errorf("Sanity query failed: %s: %+v", query, err)
+		}
+		validation.QueryResults[query] = strings.TrimSpace(string(output))
+	}
+	return nil
+}
+
+// ValidateSnapshot test-restores a snapshot. The snapshot itself is never written to: a throwaway layer over
+// it (an LVM snapshot of a thin snapshot, a device mapper overlay of a classic snapshot) is mounted read-write,
+// as InnoDB recovery writes, a throwaway mysqld is started on the MySQL data path with networking disabled,
+// recovery is waited for and sanity queries run, then mysqld is shut down and the throwaway layer unmounted
+// and removed. The result is recorded as snapshot tags.
+// Validation of input is synchronous; the test-restore itself runs as a job.
+func ValidateSnapshot(snapshotName string) (*Job, error) {
+	if snapshotName == "" {
+		return nil, errors.New("Empty snapshot name in ValidateSnapshot")
+	}
+	logicalVolumes, err := LogicalVolumes(snapshotName, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(logicalVolumes) == 0 || !logicalVolumes[0].IsSnapshot {
+		return nil, fmt.Errorf("Not a snapshot: %s", snapshotName)
+	}
+	snapshot := logicalVolumes[0]
+	mountPoint := config.Config.SnapshotMountPoint
+	if mount, err := FindMount(mountPoint); err != nil {
+		return nil, err
+	} else if mount != nil {
+		return nil, fmt.Errorf("%s is already mounted; unmount before validating", mountPoint)
+	}
+	if JobInProgress("validate") {
+		return nil, errors.New("A snapshot validation is already in progress")
+	}
+
+	job := NewJob("validate", snapshot.Path)
+	job.Run(func(job *Job) error {
+		validation := &SnapshotValidation{
+			SnapshotPath: snapshot.Path,
+			MountPoint:   mountPoint,
+			Port:         config.Config.SnapshotValidationPort,
+			QueryResults: make(map[string]string),
+		}
+		defer func() { job.SetDetails(*validation) }()
+
+		runDir, err := ioutil.TempDir("", "orchestrator-agent-validate-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(runDir)
+		os.Chmod(runDir, 0777)
+		validation.Socket = path.Join(runDir, "mysql.sock")
+		validation.ErrorLog = path.Join(runDir, "error.log")
+
+		if snapshot.IsThinSnapshot {
+			job.SetPhase("create validation snapshot")
+			if validation.ValidationVolume, err = createValidationSnapshot(&snapshot); err != nil {
+				return err
+			}
+			defer func() {
+				job.SetPhase("remove validation snapshot")
+				if err := RemoveLV(validation.ValidationVolume); err != nil {
+					log.Errorf("Cannot remove validation snapshot %s: %+v", validation.ValidationVolume, err)
+				}
+			}()
+		} else {
+			job.SetPhase("create validation overlay")
+			overlay, err := createValidationOverlay(&snapshot)
+			if err != nil {
+				return err
+			}
+			validation.ValidationVolume = overlay.device
+			defer func() {
+				job.SetPhase("remove validation overlay")
+				if err := overlay.remove(); err != nil {
+					log.Errorf("Cannot remove validation overlay %s: %+v", overlay.device, err)
+				}
+			}()
+		}
+
+		job.SetPhase("mount")
+		mount, err := MountLV(mountPoint, validation.ValidationVolume, false, false, false)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			job.SetPhase("unmount")
+			if _, err := Unmount(mountPoint); err != nil {
+				log.Errorf("Cannot unmount %s after validation: %+v", mountPoint, err)
+			}
+		}()
+		if mount.MySQLDataPath == "" {
+			return fmt.Errorf("Cannot find MySQL data on snapshot %s", snapshot.Path)
+		}
+		validation.MySQLDataPath = mount.MySQLDataPath
+
+		err = runSnapshotValidation(job, validation)
+		validation.Passed = (err == nil)
+		if tagErr := recordSnapshotValidation(&snapshot, validation); tagErr != nil {
+			log.Errore(tagErr)
+		}
+		return err
+	})
+	return job, nil
+}
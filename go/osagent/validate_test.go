@@ -0,0 +1,67 @@
+package osagent
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestValidationMySQLDCommand(t *testing.T) {
+	validation := &SnapshotValidation{MySQLDataPath: "/mnt/snap/mysql", Port: 33306, Socket: "/tmp/validate/mysql.sock", ErrorLog: "/tmp/validate/error.log"}
+	command := validationMySQLDCommand(validation, map[string]string{"innodb-log-file-size": "1G", "innodb-file-per-table": ""})
+	for _, expected := range []string{"--no-defaults --datadir=/mnt/snap/mysql", "--tmpdir=/tmp/validate", "--socket=/tmp/validate/mysql.sock", "--pid-file=/tmp/validate/mysqld.pid", "--skip-networking",
+		"--loose-innodb-file-per-table --loose-innodb-log-file-size=1G"} {
+		if !strings.Contains(command, expected) {
+			t.Errorf("Expected %s in command: %s", expected, command)
+		}
+	}
+	if strings.Contains(command, "--defaults-file") {
+		t.Errorf("Unexpected defaults file in command: %s", command)
+	}
+	if name := validationSnapshotName(&LogicalVolume{Name: "mysql-snap"}); name != "mysql-snap-validate" {
+		t.Errorf("Unexpected validation snapshot name: %s", name)
+	}
+	if table := validationOverlayTable(2097152, "/dev/vg00/mysql-snap", "/dev/loop3"); table != "0 2097152 snapshot /dev/vg00/mysql-snap /dev/loop3 N 8" {
+		t.Errorf("Unexpected overlay table: %s", table)
+	}
+}
+
+func TestReadValidationInnoDBOptions(t *testing.T) {
+	configDir, err := ioutil.TempDir("", "orchestrator-agent-test-")
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(configDir)
+
+	configFile := path.Join(configDir, "my.cnf")
+	contents := `
+[mysqld]
+datadir = /var/lib/mysql
+innodb_buffer_pool_size = 96G
+innodb_log_group_home_dir = /data/redo
+innodb_log_file_size = 2G
+innodb_file_per_table
+tmpdir = /data/tmp
+slow_query_log_file = /var/log/mysql/slow.log
+[client]
+innodb_page_size = 8K
+`
+	if err := ioutil.WriteFile(configFile, []byte(contents), 0644); err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	options, err := readValidationInnoDBOptions(configFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %+v", err)
+	}
+	if len(options) != 2 || options["innodb-log-file-size"] != "2G" {
+		t.Errorf("Unexpected InnoDB options: %+v", options)
+	}
+	if _, ok := options["innodb-file-per-table"]; !ok {
+		t.Errorf("Expected innodb-file-per-table in InnoDB options: %+v", options)
+	}
+	if options, err := readValidationInnoDBOptions(""); err != nil || len(options) != 0 {
+		t.Errorf("Unexpected InnoDB options without defaults file: %+v, %+v", options, err)
+	}
+}